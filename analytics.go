@@ -34,6 +34,9 @@ type AnalyticsRecord struct {
 	Geo           GeoData
 	Tags          []string
 	Alias         string
+	Attempts      int
+	MirrorCode    int
+	MirrorTime    int64
 	ExpireAt      time.Time `bson:"expireAt" json:"expireAt"`
 }
 
@@ -173,6 +176,7 @@ type RedisAnalyticsHandler struct {
 	Store   *RedisClusterStorageManager
 	Clean   Purger
 	GeoIPDB *maxminddb.Reader
+	Buffer  *AnalyticsBuffer
 }
 
 func (r *RedisAnalyticsHandler) Init() {
@@ -180,7 +184,9 @@ func (r *RedisAnalyticsHandler) Init() {
 		go r.reloadDB()
 	}
 
-	analytics.Store.Connect()
+	if r.Store != nil {
+		r.Store.Connect()
+	}
 }
 
 func (r *RedisAnalyticsHandler) reloadDB() {
@@ -224,6 +230,15 @@ func (r RedisAnalyticsHandler) RecordHit(thisRecord AnalyticsRecord) error {
 
 	thisRecord.Tags = append(thisRecord.Tags, "api-"+thisRecord.APIID)
 
+	// Hand off to the configured sink, the buffer will not block if the sink is slow
+	if r.Buffer != nil {
+		return r.Buffer.RecordHit(thisRecord)
+	}
+
+	if r.Store == nil {
+		return AnalyticsError{}
+	}
+
 	encoded, err := msgpack.Marshal(thisRecord)
 
 	if err != nil {
@@ -0,0 +1,550 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"gopkg.in/vmihailenco/msgpack.v2"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ANALYTICS_SINK_REDIS  string = "redis"
+	ANALYTICS_SINK_FILE   string = "file"
+	ANALYTICS_SINK_STATSD string = "statsd"
+	ANALYTICS_SINK_HTTP   string = "http"
+
+	defaultAnalyticsBufferSize    int = 10000
+	defaultAnalyticsBatchSize     int = 100
+	defaultAnalyticsFlushInterval int = 1000
+	analyticsBufferStopTimeout    int = 5
+	statsdMaxPacketSize           int = 1432
+)
+
+// AnalyticsSink is a destination that analytics records are written to in batches
+type AnalyticsSink interface {
+	Init() error
+	WriteBatch([]AnalyticsRecord) error
+}
+
+// AnalyticsSinkNeedsRedis returns true if the configured analytics type writes to Redis
+func AnalyticsSinkNeedsRedis() bool {
+	switch config.AnalyticsConfig.Type {
+	case ANALYTICS_SINK_FILE, ANALYTICS_SINK_STATSD, ANALYTICS_SINK_HTTP:
+		return false
+	}
+
+	return true
+}
+
+// AnalyticsCanFallBackToRedis returns true if batches that a sink fails to write can be pushed to the
+// Redis list for the pump instead, this is only possible when Redis is the storage backend
+func AnalyticsCanFallBackToRedis() bool {
+	return !AnalyticsSinkNeedsRedis() && config.Storage.Type == "redis"
+}
+
+// GetAnalyticsSink will return the sink selected by AnalyticsConfig.Type, anything that
+// isn't a known type (csv, mongo, rpc) falls back to the Redis list the pump reads from
+func GetAnalyticsSink(store *RedisClusterStorageManager) AnalyticsSink {
+	switch config.AnalyticsConfig.Type {
+	case ANALYTICS_SINK_FILE:
+		return &FileSpoolAnalyticsSink{
+			Path:      config.AnalyticsConfig.FileSpool.Path,
+			MaxSizeMB: config.AnalyticsConfig.FileSpool.MaxSizeMB,
+			MaxFiles:  config.AnalyticsConfig.FileSpool.MaxFiles,
+		}
+	case ANALYTICS_SINK_STATSD:
+		return &StatsDAnalyticsSink{
+			Address:   config.AnalyticsConfig.StatsD.Address,
+			Prefix:    config.AnalyticsConfig.StatsD.Prefix,
+			DogStatsD: config.AnalyticsConfig.StatsD.DogStatsD,
+		}
+	case ANALYTICS_SINK_HTTP:
+		return &HTTPPushAnalyticsSink{
+			URL:     config.AnalyticsConfig.HTTPPush.URL,
+			Headers: config.AnalyticsConfig.HTTPPush.Headers,
+			Timeout: config.AnalyticsConfig.HTTPPush.Timeout,
+		}
+	}
+
+	return &RedisListAnalyticsSink{Store: store}
+}
+
+// batchQueue holds items in a bounded queue and hands them to write in batches from a single worker,
+// a batch is written once it is full or when the flush interval passes. If the queue is full, items
+// are dropped rather than holding up the request path
+type batchQueue struct {
+	BatchSize     int
+	FlushInterval time.Duration
+
+	write   func([]interface{})
+	items   chan interface{}
+	dropped uint64
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+func newBatchQueue(size int, batchSize int, flushInterval time.Duration, write func([]interface{})) *batchQueue {
+	return &batchQueue{
+		BatchSize:     batchSize,
+		FlushInterval: flushInterval,
+		write:         write,
+		items:         make(chan interface{}, size),
+		stop:          make(chan struct{}),
+		stopped:       make(chan struct{}),
+	}
+}
+
+// start runs the worker, it should only be called once
+func (q *batchQueue) start() {
+	go q.worker()
+}
+
+// push queues an item, it never blocks and returns false if the item was dropped
+func (q *batchQueue) push(item interface{}) bool {
+	select {
+	case q.items <- item:
+		return true
+	default:
+		atomic.AddUint64(&q.dropped, 1)
+		return false
+	}
+}
+
+// takeDropped returns the number of items dropped since it was last called
+func (q *batchQueue) takeDropped() uint64 {
+	return atomic.SwapUint64(&q.dropped, 0)
+}
+
+// close writes out the items that are still queued and stops the worker, it returns false if that
+// takes longer than the timeout. Items that come in afterwards are not written
+func (q *batchQueue) close(timeout time.Duration) bool {
+	q.once.Do(func() { close(q.stop) })
+
+	select {
+	case <-q.stopped:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (q *batchQueue) worker() {
+	batch := make([]interface{}, 0, q.BatchSize)
+	ticker := time.NewTicker(q.FlushInterval)
+	defer ticker.Stop()
+	defer close(q.stopped)
+
+	for {
+		select {
+		case <-q.stop:
+			q.drain(batch)
+			return
+		case item := <-q.items:
+			batch = append(batch, item)
+			if len(batch) >= q.BatchSize {
+				q.write(batch)
+				batch = make([]interface{}, 0, q.BatchSize)
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				q.write(batch)
+				batch = make([]interface{}, 0, q.BatchSize)
+			}
+		}
+	}
+}
+
+// drain writes the current batch and everything left in the queue
+func (q *batchQueue) drain(batch []interface{}) {
+	for {
+		select {
+		case item := <-q.items:
+			batch = append(batch, item)
+			if len(batch) >= q.BatchSize {
+				q.write(batch)
+				batch = make([]interface{}, 0, q.BatchSize)
+			}
+		default:
+			if len(batch) > 0 {
+				q.write(batch)
+			}
+			return
+		}
+	}
+}
+
+// AnalyticsBuffer implements AnalyticsHandler, it queues records and hands them to its sink in batches
+// so that writing them never holds up the request path. Batches the sink fails to write are handed to
+// the fallback sink if set.
+type AnalyticsBuffer struct {
+	Sink     AnalyticsSink
+	Fallback AnalyticsSink
+
+	queue *batchQueue
+}
+
+// NewAnalyticsBuffer creates a buffer in front of a sink, zero values will use the defaults
+func NewAnalyticsBuffer(sink AnalyticsSink, size int, batchSize int, flushInterval int) *AnalyticsBuffer {
+	if size <= 0 {
+		size = defaultAnalyticsBufferSize
+	}
+
+	if batchSize <= 0 {
+		batchSize = defaultAnalyticsBatchSize
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = defaultAnalyticsFlushInterval
+	}
+
+	b := &AnalyticsBuffer{Sink: sink}
+	b.queue = newBatchQueue(size, batchSize, time.Duration(flushInterval)*time.Millisecond, b.flush)
+	return b
+}
+
+// Init initialises the sink and starts the flush worker
+func (b *AnalyticsBuffer) Init() error {
+	if err := b.Sink.Init(); err != nil {
+		return err
+	}
+
+	b.queue.start()
+	return nil
+}
+
+// RecordHit queues a record for the sink, it never blocks
+func (b *AnalyticsBuffer) RecordHit(thisRecord AnalyticsRecord) error {
+	if !b.queue.push(thisRecord) {
+		return AnalyticsError{}
+	}
+
+	return nil
+}
+
+// Stop writes out the records that are still queued and stops the worker, it gives up after a few
+// seconds so that a slow sink can't hold up a shutdown. Records that come in afterwards are not written
+func (b *AnalyticsBuffer) Stop() {
+	if !b.queue.close(time.Duration(analyticsBufferStopTimeout) * time.Second) {
+		log.WithFields(logrus.Fields{
+			"prefix": "analytics",
+		}).Warning("Timed out writing the remaining analytics records")
+	}
+}
+
+// Dropped returns the number of records dropped that have not been logged yet, the count is reset
+// when a batch is flushed
+func (b *AnalyticsBuffer) Dropped() uint64 {
+	return atomic.LoadUint64(&b.queue.dropped)
+}
+
+func (b *AnalyticsBuffer) flush(items []interface{}) {
+	if dropped := b.queue.takeDropped(); dropped > 0 {
+		log.WithFields(logrus.Fields{
+			"prefix": "analytics",
+		}).Warning("Analytics buffer full, dropped records: ", dropped)
+	}
+
+	batch := make([]AnalyticsRecord, len(items))
+	for i, item := range items {
+		batch[i] = item.(AnalyticsRecord)
+	}
+
+	err := b.Sink.WriteBatch(batch)
+	if err == nil {
+		return
+	}
+
+	if b.Fallback == nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "analytics",
+		}).Error("Failed to write analytics batch, dropped records: ", len(batch), ": ", err)
+		return
+	}
+
+	log.WithFields(logrus.Fields{
+		"prefix": "analytics",
+	}).Warning("Failed to write analytics batch, using the fallback sink: ", err)
+
+	if err := b.Fallback.WriteBatch(batch); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "analytics",
+		}).Error("Failed to write analytics batch to the fallback sink, dropped records: ", len(batch), ": ", err)
+	}
+}
+
+// RedisListAnalyticsSink writes msgpack encoded records to the analytics list for the pump to collect
+type RedisListAnalyticsSink struct {
+	Store *RedisClusterStorageManager
+}
+
+func (r *RedisListAnalyticsSink) Init() error {
+	if r.Store == nil {
+		return errors.New("No analytics store set")
+	}
+
+	return nil
+}
+
+func (r *RedisListAnalyticsSink) WriteBatch(records []AnalyticsRecord) error {
+	values := make([]string, 0, len(records))
+	for _, thisRecord := range records {
+		encoded, err := msgpack.Marshal(thisRecord)
+		if err != nil {
+			log.Error("Error encoding analytics data: ", err)
+			continue
+		}
+		values = append(values, string(encoded))
+	}
+
+	return r.Store.AppendToSetMulti(ANALYTICS_KEYNAME, values)
+}
+
+// FileSpoolAnalyticsSink writes records as newline delimited JSON, rotating the file
+// once it grows beyond MaxSizeMB and keeping at most MaxFiles rotated files
+type FileSpoolAnalyticsSink struct {
+	Path      string
+	MaxSizeMB int
+	MaxFiles  int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+func (f *FileSpoolAnalyticsSink) fileName() string {
+	return filepath.Join(f.Path, "tyk-analytics.json")
+}
+
+func (f *FileSpoolAnalyticsSink) Init() error {
+	if f.Path == "" {
+		return errors.New("No analytics spool path set")
+	}
+
+	if err := os.MkdirAll(f.Path, 0755); err != nil {
+		return err
+	}
+
+	return f.open()
+}
+
+func (f *FileSpoolAnalyticsSink) open() error {
+	file, err := os.OpenFile(f.fileName(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// rotate moves the current file aside and starts a new one, the current file is kept open until the
+// new one is so that the sink always has a file to write to
+func (f *FileSpoolAnalyticsSink) rotate() error {
+	rotatedName := filepath.Join(f.Path, "tyk-analytics-"+strconv.FormatInt(time.Now().UnixNano(), 10)+".json")
+	if err := os.Rename(f.fileName(), rotatedName); err != nil {
+		return err
+	}
+
+	rotatedFile := f.file
+	if err := f.open(); err != nil {
+		return err
+	}
+	rotatedFile.Close()
+
+	if f.MaxFiles > 0 {
+		rotated, _ := filepath.Glob(filepath.Join(f.Path, "tyk-analytics-*.json"))
+		sort.Strings(rotated)
+		for len(rotated) > f.MaxFiles {
+			os.Remove(rotated[0])
+			rotated = rotated[1:]
+		}
+	}
+
+	return nil
+}
+
+func (f *FileSpoolAnalyticsSink) WriteBatch(records []AnalyticsRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return errors.New("Analytics spool file is not open")
+	}
+
+	w := bufio.NewWriter(f.file)
+	var written int64
+	for _, thisRecord := range records {
+		asJSON, err := json.Marshal(thisRecord)
+		if err != nil {
+			log.Error("Error encoding analytics data: ", err)
+			continue
+		}
+		n, _ := w.Write(asJSON)
+		w.WriteByte('\n')
+		written += int64(n) + 1
+	}
+
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	f.size += written
+	if f.MaxSizeMB > 0 && f.size >= int64(f.MaxSizeMB)*1024*1024 {
+		// The batch is written, so a failed rotation is tried again after the next batch
+		if err := f.rotate(); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "analytics",
+			}).Error("Failed to rotate analytics spool file: ", err)
+		}
+	}
+
+	return nil
+}
+
+// StatsDAnalyticsSink emits a request counter and timer per record over UDP, with DogStatsD
+// the API, org and response code are sent as tags instead of being part of the metric name
+type StatsDAnalyticsSink struct {
+	Address   string
+	Prefix    string
+	DogStatsD bool
+
+	conn net.Conn
+}
+
+func (s *StatsDAnalyticsSink) Init() error {
+	if s.Address == "" {
+		return errors.New("No StatsD address set")
+	}
+
+	if s.Prefix == "" {
+		s.Prefix = "tyk"
+	}
+
+	conn, err := net.Dial("udp", s.Address)
+	if err != nil {
+		return err
+	}
+
+	s.conn = conn
+	return nil
+}
+
+func (s *StatsDAnalyticsSink) metricLines(thisRecord AnalyticsRecord) []string {
+	if s.DogStatsD {
+		tags := fmt.Sprintf("|#api_id:%s,org_id:%s,code:%d", thisRecord.APIID, thisRecord.OrgID, thisRecord.ResponseCode)
+		return []string{
+			s.Prefix + ".request:1|c" + tags,
+			s.Prefix + ".request_time:" + strconv.FormatInt(thisRecord.RequestTime, 10) + "|ms" + tags,
+		}
+	}
+
+	base := s.Prefix + "." + thisRecord.APIID
+	return []string{
+		base + ".request:1|c",
+		base + ".code." + strconv.Itoa(thisRecord.ResponseCode) + ":1|c",
+		base + ".request_time:" + strconv.FormatInt(thisRecord.RequestTime, 10) + "|ms",
+	}
+}
+
+func (s *StatsDAnalyticsSink) WriteBatch(records []AnalyticsRecord) error {
+	var packet bytes.Buffer
+	var lastErr error
+
+	send := func() {
+		if packet.Len() == 0 {
+			return
+		}
+		if _, err := s.conn.Write(packet.Bytes()); err != nil {
+			lastErr = err
+		}
+		packet.Reset()
+	}
+
+	for _, thisRecord := range records {
+		for _, line := range s.metricLines(thisRecord) {
+			if packet.Len()+len(line)+1 > statsdMaxPacketSize {
+				send()
+			}
+			if packet.Len() > 0 {
+				packet.WriteByte('\n')
+			}
+			packet.WriteString(line)
+		}
+	}
+	send()
+
+	return lastErr
+}
+
+// HTTPPushAnalyticsSink POSTs each batch as a JSON array to a collector
+type HTTPPushAnalyticsSink struct {
+	URL     string
+	Headers map[string]string
+	Timeout int
+
+	client *http.Client
+}
+
+func (h *HTTPPushAnalyticsSink) Init() error {
+	if h.URL == "" {
+		return errors.New("No analytics push URL set")
+	}
+
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = 10
+	}
+
+	h.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+	return nil
+}
+
+func (h *HTTPPushAnalyticsSink) WriteBatch(records []AnalyticsRecord) error {
+	asJSON, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", h.URL, bytes.NewReader(asJSON))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("Analytics push returned status: " + strings.TrimSpace(resp.Status))
+	}
+
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testAnalyticsSink struct {
+	mu      sync.Mutex
+	records []AnalyticsRecord
+	block   chan bool
+	fail    bool
+}
+
+func (t *testAnalyticsSink) Init() error {
+	return nil
+}
+
+func (t *testAnalyticsSink) WriteBatch(records []AnalyticsRecord) error {
+	if t.block != nil {
+		<-t.block
+	}
+	if t.fail {
+		return errors.New("sink unavailable")
+	}
+	t.mu.Lock()
+	t.records = append(t.records, records...)
+	t.mu.Unlock()
+	return nil
+}
+
+func (t *testAnalyticsSink) count() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.records)
+}
+
+func TestAnalyticsBufferFlushesBatches(t *testing.T) {
+	sink := &testAnalyticsSink{}
+	buffer := NewAnalyticsBuffer(sink, 100, 5, 50)
+	buffer.Init()
+
+	for i := 0; i < 12; i++ {
+		if err := buffer.RecordHit(AnalyticsRecord{APIID: "test"}); err != nil {
+			t.Fatal("Record should have been queued: ", err)
+		}
+	}
+
+	time.Sleep(200 * time.Millisecond)
+
+	if sink.count() != 12 {
+		t.Error("Expected 12 records to be flushed, got: ", sink.count())
+	}
+}
+
+func TestAnalyticsBufferStopFlushesQueue(t *testing.T) {
+	sink := &testAnalyticsSink{}
+	buffer := NewAnalyticsBuffer(sink, 100, 50, 60000)
+	buffer.Init()
+
+	for i := 0; i < 12; i++ {
+		buffer.RecordHit(AnalyticsRecord{APIID: "test"})
+	}
+
+	buffer.Stop()
+	if sink.count() != 12 {
+		t.Error("Queued records should be written on stop, got: ", sink.count())
+	}
+}
+
+func TestAnalyticsBufferUsesFallbackOnWriteFailure(t *testing.T) {
+	fallback := &testAnalyticsSink{}
+	buffer := NewAnalyticsBuffer(&testAnalyticsSink{fail: true}, 100, 50, 60000)
+	buffer.Fallback = fallback
+	buffer.Init()
+
+	for i := 0; i < 3; i++ {
+		buffer.RecordHit(AnalyticsRecord{APIID: "test"})
+	}
+
+	buffer.Stop()
+	if fallback.count() != 3 {
+		t.Error("Failed batch should be written to the fallback sink, got: ", fallback.count())
+	}
+}
+
+func TestAnalyticsBufferDropsWhenFull(t *testing.T) {
+	sink := &testAnalyticsSink{block: make(chan bool)}
+	buffer := NewAnalyticsBuffer(sink, 2, 1, 1000)
+	buffer.Init()
+
+	var failed int
+	for i := 0; i < 10; i++ {
+		if err := buffer.RecordHit(AnalyticsRecord{APIID: "test"}); err != nil {
+			failed++
+		}
+	}
+
+	if failed == 0 {
+		t.Error("Expected records to be dropped when the buffer is full")
+	}
+
+	close(sink.block)
+}
+
+func TestFileSpoolAnalyticsSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &FileSpoolAnalyticsSink{Path: dir}
+	if err := sink.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	records := []AnalyticsRecord{{APIID: "1", ResponseCode: 200}, {APIID: "2", ResponseCode: 500}}
+	if err := sink.WriteBatch(records); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(filepath.Join(dir, "tyk-analytics.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var lines int
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var decoded AnalyticsRecord
+		if err := json.Unmarshal(scanner.Bytes(), &decoded); err != nil {
+			t.Error("Line is not valid JSON: ", err)
+		}
+		lines++
+	}
+
+	if lines != 2 {
+		t.Error("Expected 2 lines in spool file, got: ", lines)
+	}
+}
+
+func TestFileSpoolAnalyticsSinkFailedRotation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-analytics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	sink := &FileSpoolAnalyticsSink{Path: dir, MaxSizeMB: 1}
+	if err := sink.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rename fails as the spool file is gone, while the open handle can still be written to
+	os.Remove(sink.fileName())
+	sink.size = 1024 * 1024
+
+	records := []AnalyticsRecord{{APIID: "1", ResponseCode: 200}}
+	if err := sink.WriteBatch(records); err != nil {
+		t.Fatal("Batch was written before the rotation, got: ", err)
+	}
+
+	if _, err := sink.file.Stat(); err != nil {
+		t.Fatal("Sink should keep a usable file after a failed rotation: ", err)
+	}
+
+	if err := sink.WriteBatch(records); err != nil {
+		t.Error("Later batches should still be written, got: ", err)
+	}
+}
@@ -1882,6 +1882,88 @@ func healthCheckhandler(w http.ResponseWriter, r *http.Request) {
 	DoJSONWrite(w, code, responseMessage)
 }
 
+// APICanaryWeights is the canary split of an API as shown and changed through the control API
+type APICanaryWeights struct {
+	APIID   string         `json:"api_id"`
+	Weights map[string]int `json:"weights"`
+}
+
+// canaryHandler shows and changes the canary weights of an API without changing its definition, the
+// weights are validated against the versions of the API and DELETE goes back to the definition's weights.
+// Changes are saved in the cluster store and the other gateways are notified to pick them up
+func canaryHandler(w http.ResponseWriter, r *http.Request) {
+	APIID := r.URL.Path[len("/tyk/canary/"):]
+	var responseMessage []byte
+	var code int = 200
+
+	thisAPISpec := GetSpecForApi(APIID)
+	if thisAPISpec == nil {
+		DoJSONWrite(w, 404, createError("API ID not found"))
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+	case "POST", "PUT":
+		var update APICanaryWeights
+		if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+			DoJSONWrite(w, 400, createError("Request malformed"))
+			return
+		}
+
+		var total int
+		for versionName, weight := range update.Weights {
+			if _, found := thisAPISpec.VersionData.Versions[versionName]; !found {
+				DoJSONWrite(w, 400, createError("Version not found: "+versionName))
+				return
+			}
+			if weight < 0 {
+				DoJSONWrite(w, 400, createError("Weights can't be negative"))
+				return
+			}
+			total += weight
+		}
+
+		if total == 0 {
+			DoJSONWrite(w, 400, createError("At least one version needs a weight"))
+			return
+		}
+
+		if err := CanaryWeights.Set(APIID, update.Weights); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "api",
+				"api_id": APIID,
+			}).Error("Failed to save canary weights: ", err)
+			DoJSONWrite(w, 500, createError("Failed to save canary weights"))
+			return
+		}
+		MainNotifier.Notify(Notification{Command: NoticeCanaryUpdated, Payload: APIID})
+		log.WithFields(logrus.Fields{
+			"prefix":  "api",
+			"api_id":  APIID,
+			"weights": update.Weights,
+		}).Info("Canary weights updated")
+	case "DELETE":
+		CanaryWeights.Delete(APIID)
+		MainNotifier.Notify(Notification{Command: NoticeCanaryUpdated, Payload: APIID})
+		log.WithFields(logrus.Fields{
+			"prefix": "api",
+			"api_id": APIID,
+		}).Info("Canary weights reset")
+	default:
+		DoJSONWrite(w, 405, createError("Method not supported"))
+		return
+	}
+
+	responseMessage, err := json.Marshal(APICanaryWeights{APIID: APIID, Weights: thisAPISpec.canaryWeights()})
+	if err != nil {
+		code = 500
+		responseMessage = createError("Failed to encode data")
+	}
+
+	DoJSONWrite(w, code, responseMessage)
+}
+
 func UserRatesCheck() func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		code := 200
@@ -7,6 +7,7 @@ import (
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/context"
 	"github.com/lonelycode/tykcommon"
+	"github.com/mitchellh/mapstructure"
 	"github.com/rubyist/circuitbreaker"
 	"gopkg.in/mgo.v2"
 	"io/ioutil"
@@ -26,6 +27,8 @@ const (
 	DefaultStorageEngine   tykcommon.StorageEngineCode   = "redis"
 	LDAPStorageEngine      tykcommon.StorageEngineCode   = "ldap"
 	RPCStorageEngine       tykcommon.StorageEngineCode   = "rpc"
+	MemoryStorageEngine    tykcommon.StorageEngineCode   = "memory"
+	BoltStorageEngine      tykcommon.StorageEngineCode   = "bolt"
 )
 
 // URLStatus is a custom enum type to avoid collisions
@@ -48,6 +51,7 @@ const (
 	VirtualPath            URLStatus = 12
 	RequestSizeLimit       URLStatus = 13
 	MethodTransformed      URLStatus = 14
+	RateLimited            URLStatus = 15
 )
 
 // RequestStatus is a custom type to avoid collisions
@@ -77,6 +81,7 @@ const (
 	StatusURLRewrite               RequestStatus = "URL Rewritten"
 	StatusVirtualPath              RequestStatus = "Virtual Endpoint"
 	StatusRequestSizeControlled    RequestStatus = "Request Size Limited"
+	StatusRateLimited              RequestStatus = "Rate limited path"
 )
 
 // URLSpec represents a flattened specification for URLs, used to check if a proxy URL
@@ -96,6 +101,61 @@ type URLSpec struct {
 	VirtualPathSpec         tykcommon.VirtualMeta
 	RequestSize             tykcommon.RequestSizeMeta
 	MethodTransform         tykcommon.MethodTransformMeta
+	RateLimit               RateLimitMeta
+}
+
+// RateLimitMeta sets a rolling window rate limit on a single endpoint, this is applied on top
+// of the key-level rate limit. If Method is empty the limit applies to all methods with one window
+type RateLimitMeta struct {
+	Path   string  `mapstructure:"path" bson:"path" json:"path"`
+	Method string  `mapstructure:"method" bson:"method" json:"method"`
+	Rate   float64 `mapstructure:"rate" bson:"rate" json:"rate"`
+	Per    float64 `mapstructure:"per" bson:"per" json:"per"`
+}
+
+// ExtendedPathsRawData holds extended path sections that are not part of tykcommon.ExtendedPathsSet,
+// these are decoded from the raw API definition
+type ExtendedPathsRawData struct {
+	RateLimit []RateLimitMeta `mapstructure:"rate_limit" bson:"rate_limit" json:"rate_limit"`
+}
+
+type rawVersionData struct {
+	VersionData struct {
+		Versions map[string]struct {
+			ExtendedPaths ExtendedPathsRawData `mapstructure:"extended_paths"`
+		} `mapstructure:"versions"`
+	} `mapstructure:"version_data"`
+}
+
+// decodeRawConfig decodes a section of the raw API definition into out, name is used to log decode
+// errors. A nil definition leaves out as it is
+func decodeRawConfig(rawData map[string]interface{}, name string, out interface{}) bool {
+	if rawData == nil {
+		return true
+	}
+
+	if err := mapstructure.Decode(rawData, out); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+		}).Error("Couldn't decode ", name, ": ", err)
+		return false
+	}
+
+	return true
+}
+
+// getRawExtendedPaths decodes the additional extended path sections for a version from the raw API definition
+func getRawExtendedPaths(rawData map[string]interface{}, versionName string) ExtendedPathsRawData {
+	var thisRawVersionData rawVersionData
+	if rawData == nil {
+		return ExtendedPathsRawData{}
+	}
+
+	if !decodeRawConfig(rawData, "extended paths from raw definition", &thisRawVersionData) {
+		return ExtendedPathsRawData{}
+	}
+
+	return thisRawVersionData.VersionData.Versions[versionName].ExtendedPaths
 }
 
 type TransformSpec struct {
@@ -112,18 +172,35 @@ type ExtendedCircuitBreakerMeta struct {
 // flattened URL list is checked for matching paths and then it's status evaluated if found.
 type APISpec struct {
 	tykcommon.APIDefinition
-	RxPaths           map[string][]URLSpec
-	WhiteListEnabled  map[string]bool
-	target            *url.URL
-	AuthManager       AuthorisationHandler
-	SessionManager    SessionHandler
-	OAuthManager      *OAuthManager
-	OrgSessionManager SessionHandler
-	EventPaths        map[tykcommon.TykEvent][]TykEventHandler
-	Health            HealthChecker
-	JSVM              *JSVM
-	ResponseChain     *[]TykResponseHandler
-	RoundRobin        *RoundRobin
+	RxPaths            map[string][]URLSpec
+	WhiteListEnabled   map[string]bool
+	target             *url.URL
+	AuthManager        AuthorisationHandler
+	SessionManager     SessionHandler
+	OAuthManager       *OAuthManager
+	OrgSessionManager  SessionHandler
+	EventPaths         map[tykcommon.TykEvent][]TykEventHandler
+	Health             HealthChecker
+	JSVM               *JSVM
+	ResponseChain      *[]TykResponseHandler
+	RoundRobin         *RoundRobin
+	RateLimit          APIRateLimitConfig
+	ClientCertificates ClientCertificateConfig
+	AuthModes          []string
+	HMAC               HMACConfig
+	UpstreamSigning    UpstreamSigningConfig
+	PKCE               PKCEConfig
+	JWTValidation      JWTValidationConfig
+	ClaimMappings      []ClaimMapping
+	ForwardAuth        ForwardAuthConfig
+	LoadBalancing      LoadBalancingConfig
+	LoadBalancer       LoadBalancer
+	RetryPolicy        RetryPolicyConfig
+	OutlierDetection   OutlierDetectionConfig
+	OutlierDetector    *OutlierDetector
+	TrafficMirror      *TrafficMirrorConfig
+	TrafficMirrors     map[string]*TrafficMirrorConfig
+	Canary             CanaryConfig
 }
 
 // APIDefinitionLoader will load an Api definition from a storage system. It has two methods LoadDefinitionsFromMongo()
@@ -153,6 +230,23 @@ func (a *APIDefinitionLoader) Disconnect() {
 func (a *APIDefinitionLoader) MakeSpec(thisAppConfig tykcommon.APIDefinition) APISpec {
 	newAppSpec := APISpec{}
 	newAppSpec.APIDefinition = thisAppConfig
+	newAppSpec.RateLimit = getAPIRateLimitConfig(thisAppConfig.RawData)
+	newAppSpec.ClientCertificates = getClientCertificateConfig(thisAppConfig.RawData)
+	newAppSpec.AuthModes = getAuthModes(thisAppConfig.RawData)
+	newAppSpec.HMAC = getHMACConfig(thisAppConfig.RawData)
+	newAppSpec.UpstreamSigning = getUpstreamSigningConfig(thisAppConfig.RawData)
+	newAppSpec.PKCE = getPKCEConfig(thisAppConfig.RawData)
+	newAppSpec.JWTValidation = getJWTValidationConfig(thisAppConfig.RawData)
+	newAppSpec.ClaimMappings = getClaimMappings(thisAppConfig.RawData)
+	newAppSpec.ForwardAuth = getForwardAuthConfig(thisAppConfig.RawData)
+	newAppSpec.LoadBalancing = getLoadBalancingConfig(thisAppConfig.RawData)
+	newAppSpec.RetryPolicy = getRetryPolicyConfig(thisAppConfig.RawData)
+	newAppSpec.OutlierDetection = getOutlierDetectionConfig(thisAppConfig.RawData)
+	newAppSpec.TrafficMirror, newAppSpec.TrafficMirrors = getTrafficMirrorConfig(thisAppConfig.RawData)
+	newAppSpec.Canary = getCanaryConfig(thisAppConfig.RawData)
+	if newAppSpec.Canary.Enabled {
+		CanaryWeights.Load(newAppSpec.APIID)
+	}
 
 	// We'll push the default HealthChecker:
 	newAppSpec.Health = &DefaultHealthChecker{
@@ -829,6 +923,31 @@ func (a *APIDefinitionLoader) compileRequestSizePathSpec(paths []tykcommon.Reque
 	return thisURLSpec
 }
 
+func (a *APIDefinitionLoader) compileRateLimitPathSpec(paths []RateLimitMeta, stat URLStatus) []URLSpec {
+
+	// transform an extended configuration URL into an array of URLSpecs
+	// This way we can iterate the whole array once, on match we break with status
+	thisURLSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		if stringSpec.Rate <= 0 || stringSpec.Per <= 0 {
+			log.WithFields(logrus.Fields{
+				"prefix": "main",
+			}).Warning("Skipping endpoint rate limit with no rate or per set: ", stringSpec.Path)
+			continue
+		}
+
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat)
+		// Extend with method actions
+		newSpec.RateLimit = stringSpec
+
+		thisURLSpec = append(thisURLSpec, newSpec)
+	}
+
+	return thisURLSpec
+}
+
 func (a *APIDefinitionLoader) compileCircuitBreakerPathSpec(paths []tykcommon.CircuitBreakerMeta, stat URLStatus, apiSpec *APISpec) []URLSpec {
 
 	// transform an extended configuration URL into an array of URLSpecs
@@ -853,6 +972,7 @@ func (a *APIDefinitionLoader) compileCircuitBreakerPathSpec(paths []tykcommon.Ci
 				switch e {
 				case circuit.BreakerTripped:
 					log.Warning("[PROXY] [CIRCUIT BREKER] Breaker tripped for path: ", path)
+					GatewayMetrics.CircuitBreaker.Set(1, spec.APIID, path)
 					log.Debug("Breaker tripped: ", e)
 					// Start a timer function
 
@@ -883,6 +1003,7 @@ func (a *APIDefinitionLoader) compileCircuitBreakerPathSpec(paths []tykcommon.Ci
 						})
 
 				case circuit.BreakerReset:
+					GatewayMetrics.CircuitBreaker.Set(0, spec.APIID, path)
 					spec.FireEvent(EVENT_BreakerTriggered,
 						EVENT_CurcuitBreakerMeta{
 							EventMetaDefault: EventMetaDefault{Message: "Breaker Reset"},
@@ -961,6 +1082,9 @@ func (a *APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef tykcommon.Versi
 	requestSizes := a.compileRequestSizePathSpec(apiVersionDef.ExtendedPaths.SizeLimit, RequestSizeLimit)
 	methodTransforms := a.compileMethodTransformSpec(apiVersionDef.ExtendedPaths.MethodTransforms, MethodTransformed)
 
+	rawExtendedPaths := getRawExtendedPaths(apiSpec.APIDefinition.RawData, apiVersionDef.Name)
+	rateLimitPaths := a.compileRateLimitPathSpec(rawExtendedPaths.RateLimit, RateLimited)
+
 	combinedPath := []URLSpec{}
 	combinedPath = append(combinedPath, ignoredPaths...)
 	combinedPath = append(combinedPath, blackListPaths...)
@@ -976,6 +1100,7 @@ func (a *APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef tykcommon.Versi
 	combinedPath = append(combinedPath, requestSizes...)
 	combinedPath = append(combinedPath, virtualPaths...)
 	combinedPath = append(combinedPath, methodTransforms...)
+	combinedPath = append(combinedPath, rateLimitPaths...)
 
 	if len(whiteListPaths) > 0 {
 		return combinedPath, true
@@ -1021,6 +1146,8 @@ func (a *APISpec) getURLStatus(stat URLStatus) RequestStatus {
 		return StatusRequestSizeControlled
 	case MethodTransformed:
 		return StatusMethodTransformed
+	case RateLimited:
+		return StatusRateLimited
 	default:
 		log.Error("URL Status was not one of Ignored, Blacklist or WhiteList! Blocking.")
 		return EndPointNotAllowed
@@ -1145,6 +1272,10 @@ func (a *APISpec) CheckSpecMatchesStatus(url string, method interface{}, RxPaths
 					if method != nil && method.(string) == v.MethodTransform.Method {
 						return true, &v.MethodTransform
 					}
+				case RateLimited:
+					if method != nil && (v.RateLimit.Method == "" || method.(string) == v.RateLimit.Method) {
+						return true, &v.RateLimit
+					}
 				}
 
 			}
@@ -1153,7 +1284,18 @@ func (a *APISpec) CheckSpecMatchesStatus(url string, method interface{}, RxPaths
 	return false, nil
 }
 
+// getVersionFromRequest returns the version the request is routed to, which is the canary pick for requests
+// that were split and otherwise the version the request asked for
 func (a *APISpec) getVersionFromRequest(r *http.Request) string {
+	if canaryVersion, isCanary := context.Get(r, CanaryVersion).(string); isCanary {
+		return canaryVersion
+	}
+
+	return a.getRequestedVersion(r)
+}
+
+// getRequestedVersion reads the version from the request
+func (a *APISpec) getRequestedVersion(r *http.Request) string {
 	if a.APIDefinition.VersionDefinition.Location == "header" {
 		versionHeaderVal := r.Header.Get(a.APIDefinition.VersionDefinition.Key)
 		if versionHeaderVal != "" {
@@ -1285,8 +1427,9 @@ func (a *APISpec) GetVersionData(r *http.Request) (*tykcommon.VersionInfo, *[]UR
 		thisVersion = aVersion.(tykcommon.VersionInfo)
 		versionKey = context.Get(r, VersionKeyContext).(string)
 	} else {
-		// Are we versioned?
-		if a.APIDefinition.VersionData.NotVersioned {
+		// Are we versioned? Requests picked by the canary split are always treated as versioned
+		_, isCanary := context.GetOk(r, CanaryVersion)
+		if a.APIDefinition.VersionData.NotVersioned && !isCanary {
 			// Get the first one in the list
 			for k, v := range a.APIDefinition.VersionData.Versions {
 				versionKey = k
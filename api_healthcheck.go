@@ -61,6 +61,10 @@ func (h *DefaultHealthChecker) CreateKeyName(subKey HealthPrefix) string {
 func ReportHealthCheckValue(checker HealthChecker, counter HealthPrefix, value string) {
 	// TODO: Wrap this in a conditional so it can be deactivated
 	go checker.StoreCounterVal(counter, value)
+
+	if thisChecker, ok := checker.(*DefaultHealthChecker); ok {
+		GatewayMetrics.RecordHealthValue(thisChecker.APIID, counter)
+	}
 }
 
 func (h *DefaultHealthChecker) StoreCounterVal(counterType HealthPrefix, value string) {
@@ -537,3 +537,31 @@ func TestAPIAuthOk(t *testing.T) {
 		t.Error("Access to API should have been blocked, but response code was: ", recorder.Code)
 	}
 }
+
+func TestCanaryHandler(t *testing.T) {
+	MakeSampleAPI()
+	defer CanaryWeights.Delete("1")
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "/tyk/canary/1", strings.NewReader(`{"weights": {"Unknown": 5}}`))
+	canaryHandler(recorder, req)
+	if recorder.Code != 400 {
+		t.Error("Weights for unknown versions should be rejected, got: ", recorder.Code)
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("PUT", "/tyk/canary/1", strings.NewReader(`{"weights": {"Default": 100}}`))
+	canaryHandler(recorder, req)
+	if recorder.Code != 200 {
+		t.Fatal("Weights should be updated, got: ", recorder.Code, recorder.Body.String())
+	}
+
+	var canaryWeights APICanaryWeights
+	if err := json.Unmarshal(recorder.Body.Bytes(), &canaryWeights); err != nil || canaryWeights.Weights["Default"] != 100 {
+		t.Error("Updated weights were not returned: ", recorder.Body.String())
+	}
+
+	if weights, found := CanaryWeights.Get("1"); !found || weights["Default"] != 100 {
+		t.Error("Weights were not stored: ", weights)
+	}
+}
@@ -0,0 +1,535 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/boltdb/bolt"
+	"github.com/garyburd/redigo/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	BoltDefaultPath   string = "tyk.db"
+	BoltStorageBucket string = "tyk"
+)
+
+// boltDB is shared by all BoltStorageManager instances, bolt only allows a single process to open
+// the file, so like redis the key prefixes are used to separate the data. Rate limiter state changes
+// on every request and is only useful for a short time, so it is kept in memory rather than paying
+// for a synced write to the file on each hit
+type boltDB struct {
+	db     *bolt.DB
+	pubSub *localPubSub
+	limits *memoryStore
+}
+
+var boltDBSingleton *boltDB
+var boltDBLock sync.Mutex
+
+func openBoltDB(path string) (*boltDB, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 1 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(BoltStorageBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &boltDB{db: db, pubSub: newLocalPubSub(), limits: newMemoryStore()}, nil
+}
+
+func getBoltDB() (*boltDB, error) {
+	boltDBLock.Lock()
+	defer boltDBLock.Unlock()
+
+	if boltDBSingleton != nil {
+		return boltDBSingleton, nil
+	}
+
+	path := config.Storage.Path
+	if path == "" {
+		path = BoltDefaultPath
+	}
+
+	log.WithFields(logrus.Fields{
+		"prefix": "bolt",
+	}).Info("Opening storage file: ", path)
+
+	thisDB, err := openBoltDB(path)
+	if err != nil {
+		return nil, err
+	}
+
+	boltDBSingleton = thisDB
+	go boltDBSingleton.startExpiryLoop(time.Minute)
+	go boltDBSingleton.limits.startExpiryLoop(time.Minute)
+
+	return boltDBSingleton, nil
+}
+
+func decodeStorageEntry(data []byte) *storageEntry {
+	if data == nil {
+		return nil
+	}
+
+	entry := &storageEntry{}
+	if err := json.Unmarshal(data, entry); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "bolt",
+		}).Error("Couldn't decode stored value: ", err)
+		return nil
+	}
+
+	return entry
+}
+
+// startExpiryLoop removes expired keys, bolt has no native expiry so without this keys that are never
+// read again would stay in the file forever
+func (b *boltDB) startExpiryLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := b.removeExpired(time.Now()); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "bolt",
+			}).Error("Failed to remove expired keys: ", err)
+		}
+	}
+}
+
+func (b *boltDB) removeExpired(now time.Time) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BoltStorageBucket))
+
+		expired := [][]byte{}
+		bucket.ForEach(func(k, v []byte) error {
+			entry := decodeStorageEntry(v)
+			if entry == nil || entry.expired(now) {
+				expired = append(expired, append([]byte{}, k...))
+			}
+			return nil
+		})
+
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// view runs fn with the live entry for a key, entry is nil if the key does not exist
+func (b *boltDB) view(key string, fn func(entry *storageEntry)) error {
+	return b.db.View(func(tx *bolt.Tx) error {
+		entry := decodeStorageEntry(tx.Bucket([]byte(BoltStorageBucket)).Get([]byte(key)))
+		if entry != nil && entry.expired(time.Now()) {
+			entry = nil
+		}
+
+		fn(entry)
+		return nil
+	})
+}
+
+// update runs fn with the live entry for a key, creating it if needed, and saves the result
+func (b *boltDB) update(key string, fn func(entry *storageEntry)) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BoltStorageBucket))
+
+		entry := decodeStorageEntry(bucket.Get([]byte(key)))
+		if entry == nil || entry.expired(time.Now()) {
+			entry = &storageEntry{}
+		}
+
+		fn(entry)
+
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+
+		return bucket.Put([]byte(key), encoded)
+	})
+}
+
+func (b *boltDB) delete(keys ...string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BoltStorageBucket))
+		for _, key := range keys {
+			if err := bucket.Delete([]byte(key)); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// BoltStorageManager implements the StorageHandler interface with an embedded bolt file, it can be
+// used instead of redis for a single node
+type BoltStorageManager struct {
+	KeyPrefix string
+	HashKeys  bool
+	db        *boltDB
+}
+
+// Connect opens the shared bolt file
+func (b *BoltStorageManager) Connect() bool {
+	if b.db != nil {
+		return true
+	}
+
+	thisDB, err := getBoltDB()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "bolt",
+		}).Error("Could not open storage file: ", err)
+		return false
+	}
+
+	b.db = thisDB
+	return true
+}
+
+func (b *BoltStorageManager) store() (*boltDB, error) {
+	if b.db == nil && !b.Connect() {
+		return nil, errors.New("Bolt storage is not available")
+	}
+
+	return b.db, nil
+}
+
+func (b *BoltStorageManager) hashKey(in string) string {
+	if !b.HashKeys {
+		// Not hashing? Return the raw key
+		return in
+	}
+	return doHash(in)
+}
+
+func (b *BoltStorageManager) fixKey(keyName string) string {
+	return b.KeyPrefix + b.hashKey(keyName)
+}
+
+func (b *BoltStorageManager) cleanKey(keyName string) string {
+	return strings.Replace(keyName, b.KeyPrefix, "", 1)
+}
+
+func (b *BoltStorageManager) view(key string, fn func(entry *storageEntry)) error {
+	db, err := b.store()
+	if err != nil {
+		return err
+	}
+
+	return db.view(key, fn)
+}
+
+func (b *BoltStorageManager) update(key string, fn func(entry *storageEntry)) error {
+	db, err := b.store()
+	if err != nil {
+		return err
+	}
+
+	if err := db.update(key, fn); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "bolt",
+		}).Error("Error trying to update key: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// GetKey will retreive a key from the database
+func (b *BoltStorageManager) GetKey(keyName string) (string, error) {
+	return b.GetRawKey(b.fixKey(keyName))
+}
+
+func (b *BoltStorageManager) GetRawKey(keyName string) (string, error) {
+	var value string
+	found := false
+
+	b.view(keyName, func(entry *storageEntry) {
+		if entry != nil && entry.isString() {
+			value = entry.Value
+			found = true
+		}
+	})
+
+	if !found {
+		return "", KeyError{}
+	}
+
+	return value, nil
+}
+
+// GetExp returns the TTL of a key in seconds, -1 if it has no expiry and -2 if it does not exist
+func (b *BoltStorageManager) GetExp(keyName string) (int64, error) {
+	ttl := int64(-2)
+	err := b.view(b.fixKey(keyName), func(entry *storageEntry) {
+		if entry != nil {
+			ttl = entry.ttl()
+		}
+	})
+
+	return ttl, err
+}
+
+// SetKey will create (or update) a key value in the store
+func (b *BoltStorageManager) SetKey(keyName string, sessionState string, timeout int64) error {
+	return b.SetRawKey(b.fixKey(keyName), sessionState, timeout)
+}
+
+func (b *BoltStorageManager) SetRawKey(keyName string, sessionState string, timeout int64) error {
+	return b.update(keyName, func(entry *storageEntry) {
+		*entry = storageEntry{Value: sessionState}
+		if timeout > 0 {
+			entry.expireIn(timeout)
+		}
+	})
+}
+
+// Decrement will decrement a key
+func (b *BoltStorageManager) Decrement(keyName string) {
+	b.update(b.fixKey(keyName), func(entry *storageEntry) {
+		entry.incrementBy(-1)
+	})
+}
+
+// IncrememntWithExpire will increment a raw key, setting the expiry if the key is new
+func (b *BoltStorageManager) IncrememntWithExpire(keyName string, expire int64) int64 {
+	return b.IncrementByWithExpire(keyName, 1, expire)
+}
+
+// IncrementByWithExpire adds n to a raw counter key, setting the expiry if the key is new
+func (b *BoltStorageManager) IncrementByWithExpire(keyName string, n int64, expire int64) int64 {
+	var val int64
+	b.update(keyName, func(entry *storageEntry) {
+		val = entry.incrementBy(n)
+		if val == n {
+			entry.expireIn(expire)
+		}
+	})
+
+	return val
+}
+
+func (b *BoltStorageManager) filterKeys(prefix string) map[string]string {
+	values := make(map[string]string)
+
+	db, err := b.store()
+	if err != nil {
+		return values
+	}
+
+	now := time.Now()
+	db.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(BoltStorageBucket)).Cursor()
+		for k, v := cursor.Seek([]byte(prefix)); k != nil && strings.HasPrefix(string(k), prefix); k, v = cursor.Next() {
+			entry := decodeStorageEntry(v)
+			if entry != nil && !entry.expired(now) {
+				values[b.cleanKey(string(k))] = entry.Value
+			}
+		}
+		return nil
+	})
+
+	return values
+}
+
+// GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
+func (b *BoltStorageManager) GetKeys(filter string) []string {
+	values := b.filterKeys(b.KeyPrefix + b.hashKey(filter))
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// GetKeysAndValuesWithFilter will return all keys and their values with a filter
+func (b *BoltStorageManager) GetKeysAndValuesWithFilter(filter string) map[string]string {
+	return b.filterKeys(b.KeyPrefix + b.hashKey(filter))
+}
+
+// GetKeysAndValues will return all keys and their values - not to be used lightly
+func (b *BoltStorageManager) GetKeysAndValues() map[string]string {
+	return b.filterKeys(b.KeyPrefix)
+}
+
+// DeleteKey will remove a key from the database
+func (b *BoltStorageManager) DeleteKey(keyName string) bool {
+	return b.DeleteRawKey(b.fixKey(keyName))
+}
+
+// DeleteRawKey will remove a key from the database without prefixing, assumes user knows what they are doing
+func (b *BoltStorageManager) DeleteRawKey(keyName string) bool {
+	return b.deleteKeys([]string{keyName})
+}
+
+// DeleteKeys will remove a group of keys in bulk
+func (b *BoltStorageManager) DeleteKeys(keys []string) bool {
+	fixedKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixedKeys[i] = b.fixKey(key)
+	}
+
+	return b.deleteKeys(fixedKeys)
+}
+
+// DeleteRawKeys will remove a group of keys in bulk without a prefix handler
+func (b *BoltStorageManager) DeleteRawKeys(keys []string, prefix string) bool {
+	fixedKeys := make([]string, len(keys))
+	for i, key := range keys {
+		fixedKeys[i] = prefix + key
+	}
+
+	return b.deleteKeys(fixedKeys)
+}
+
+func (b *BoltStorageManager) deleteKeys(keys []string) bool {
+	db, err := b.store()
+	if err != nil {
+		return false
+	}
+
+	if err := db.delete(keys...); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "bolt",
+		}).Error("Error trying to delete keys: ", err)
+	}
+
+	return true
+}
+
+// StartPubSubHandler will listen for a signal and run the callback with the message, messages are
+// only delivered to subscribers in this process
+func (b *BoltStorageManager) StartPubSubHandler(channel string, callback func(redis.Message)) error {
+	db, err := b.store()
+	if err != nil {
+		return err
+	}
+
+	for message := range db.pubSub.subscribe(channel) {
+		callback(message)
+	}
+
+	return errors.New("Connection closed.")
+}
+
+func (b *BoltStorageManager) Publish(channel string, message string) error {
+	db, err := b.store()
+	if err != nil {
+		return err
+	}
+
+	db.pubSub.publish(channel, message)
+	return nil
+}
+
+// GetAndDeleteSet returns and removes all the values in a list
+func (b *BoltStorageManager) GetAndDeleteSet(keyName string) []interface{} {
+	db, err := b.store()
+	if err != nil {
+		return []interface{}{}
+	}
+
+	vals := []interface{}{}
+	fixedKey := b.fixKey(keyName)
+	db.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(BoltStorageBucket))
+		entry := decodeStorageEntry(bucket.Get([]byte(fixedKey)))
+		if entry != nil && !entry.expired(time.Now()) {
+			vals = entry.listValues()
+		}
+
+		return bucket.Delete([]byte(fixedKey))
+	})
+
+	return vals
+}
+
+func (b *BoltStorageManager) AppendToSet(keyName string, value string) {
+	b.AppendToSetMulti(keyName, []string{value})
+}
+
+// AppendToSetMulti pushes a batch of values onto a list
+func (b *BoltStorageManager) AppendToSetMulti(keyName string, values []string) error {
+	return b.update(b.fixKey(keyName), func(entry *storageEntry) {
+		entry.List = append(entry.List, values...)
+	})
+}
+
+func (b *BoltStorageManager) GetSet(keyName string) (map[string]string, error) {
+	vals := map[string]string{}
+	err := b.view(b.fixKey(keyName), func(entry *storageEntry) {
+		if entry != nil {
+			vals = entry.setMembers()
+		}
+	})
+
+	return vals, err
+}
+
+func (b *BoltStorageManager) AddToSet(keyName string, value string) {
+	b.update(b.fixKey(keyName), func(entry *storageEntry) {
+		entry.addToSet(value)
+	})
+}
+
+func (b *BoltStorageManager) RemoveFromSet(keyName string, value string) {
+	b.update(b.fixKey(keyName), func(entry *storageEntry) {
+		delete(entry.Set, value)
+	})
+}
+
+// SetRollingWindow will append to a sorted set and extract a timed window of values, windows are kept
+// in memory and start again when the gateway restarts
+func (b *BoltStorageManager) SetRollingWindow(keyName string, per int64, value_override string) (int, []interface{}) {
+	db, err := b.store()
+	if err != nil {
+		return 0, []interface{}{}
+	}
+
+	db.limits.mu.Lock()
+	defer db.limits.mu.Unlock()
+
+	return db.limits.getOrCreate(keyName).rollingWindow(per, value_override)
+}
+
+// SetRollingWindowPipeline is the same as SetRollingWindow, there is no round trip to save
+func (b *BoltStorageManager) SetRollingWindowPipeline(keyName string, per int64, value_override string) (int, []interface{}) {
+	return b.SetRollingWindow(keyName, per, value_override)
+}
+
+// SetRateLimit runs the token bucket or GCRA algorithms, these follow the redis scripts in rate_limit_algorithms.go.
+// Like rolling windows the limiter state is kept in memory
+func (b *BoltStorageManager) SetRateLimit(keyName string, algorithm string, rate float64, per float64, burst float64) (RateLimitResult, error) {
+	if err := checkRateLimitArgs(algorithm, rate, per); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	db, err := b.store()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	db.limits.mu.Lock()
+	defer db.limits.mu.Unlock()
+
+	now := time.Now()
+	reply := db.limits.getOrCreate(keyName).rateLimit(algorithm, rate, per, burst, now)
+	return parseRateLimitReply(reply, nil, rateLimitBurst(rate, burst), now)
+}
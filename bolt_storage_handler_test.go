@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func createBoltStorageManager(t *testing.T, prefix string) (*BoltStorageManager, func()) {
+	dir, err := ioutil.TempDir("", "tyk-bolt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := openBoltDB(filepath.Join(dir, "tyk.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	cleanup := func() {
+		db.db.Close()
+		os.RemoveAll(dir)
+	}
+
+	return &BoltStorageManager{KeyPrefix: prefix, db: db}, cleanup
+}
+
+func TestBoltStorageKeys(t *testing.T) {
+	store, cleanup := createBoltStorageManager(t, "apikey-")
+	defer cleanup()
+	other := &BoltStorageManager{KeyPrefix: "orgkey.", db: store.db}
+
+	store.SetKey("1234", "session", 0)
+	store.SetKey("5678", "session", 0)
+	other.SetKey("1234", "org", 0)
+
+	if val, err := store.GetKey("1234"); err != nil || val != "session" {
+		t.Error("Key not stored: ", val, err)
+	}
+
+	if val, _ := store.GetRawKey("orgkey.1234"); val != "org" {
+		t.Error("Prefixes should not collide, got: ", val)
+	}
+
+	if keys := store.GetKeysAndValues(); len(keys) != 2 || keys["5678"] != "session" {
+		t.Error("Expected only prefixed keys without the prefix: ", keys)
+	}
+
+	store.DeleteKeys([]string{"1234", "5678"})
+	if len(store.GetKeys("")) != 0 {
+		t.Error("Keys should have been deleted")
+	}
+
+	if _, err := other.GetKey("1234"); err != nil {
+		t.Error("Deleting keys should not touch other prefixes")
+	}
+}
+
+func TestBoltStorageExpiry(t *testing.T) {
+	store, cleanup := createBoltStorageManager(t, "")
+	defer cleanup()
+
+	store.SetRawKey("expiring", "1", 1)
+	store.SetRawKey("kept", "1", 0)
+
+	if exp, _ := store.GetExp("expiring"); exp < 0 || exp > 1 {
+		t.Error("Unexpected expiry: ", exp)
+	}
+
+	if err := store.db.removeExpired(time.Now().Add(2 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.GetRawKey("expiring"); err == nil {
+		t.Error("Expired key should have been swept")
+	}
+
+	if _, err := store.GetRawKey("kept"); err != nil {
+		t.Error("Key without a timeout should not be swept")
+	}
+}
+
+func TestBoltStorageCountersAndSets(t *testing.T) {
+	store, cleanup := createBoltStorageManager(t, "")
+	defer cleanup()
+
+	for i := 0; i < 5; i++ {
+		store.IncrememntWithExpire("counter", 60)
+	}
+
+	if val, _ := store.GetRawKey("counter"); val != "5" {
+		t.Error("Expected counter to be 5, got: ", val)
+	}
+
+	store.AddToSet("set", "a")
+	store.AddToSet("set", "b")
+	store.RemoveFromSet("set", "a")
+	if members, _ := store.GetSet("set"); len(members) != 1 || members["0"] != "b" {
+		t.Error("Unexpected set members: ", members)
+	}
+
+	store.AppendToSetMulti("list", []string{"1", "2"})
+	if vals := store.GetAndDeleteSet("list"); len(vals) != 2 || string(vals[0].([]byte)) != "1" {
+		t.Error("Unexpected list values: ", vals)
+	}
+
+	if len(store.GetAndDeleteSet("list")) != 0 {
+		t.Error("List should have been deleted")
+	}
+}
+
+func TestBoltStorageRateLimits(t *testing.T) {
+	store, cleanup := createBoltStorageManager(t, "")
+	defer cleanup()
+
+	for i := 0; i < 3; i++ {
+		if count, _ := store.SetRollingWindow("window", 60, "-1"); count != i {
+			t.Error("Expected ", i, " previous hits, got: ", count)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		result, err := store.SetRateLimit("bucket", RateLimitTokenBucket, 2, 60, 0)
+		if err != nil || !result.Allowed {
+			t.Error("Request should be allowed: ", i, err)
+		}
+	}
+
+	if result, _ := store.SetRateLimit("bucket", RateLimitTokenBucket, 2, 60, 0); result.Allowed {
+		t.Error("Request should be blocked once the bucket is empty")
+	}
+
+	if result, _ := store.SetRateLimit("burst", RateLimitGCRA, 2, 60, 5); result.Limit != 5 {
+		t.Error("Limit should be the burst: ", result)
+	}
+
+	// Limiter state is not written to the file
+	if keys := store.GetKeys(""); len(keys) != 0 {
+		t.Error("Rate limits should be kept in memory, found keys: ", keys)
+	}
+}
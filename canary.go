@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	CanaryStickyOnAPIKey     string = "api_key"
+	CanaryStickyOnCookie     string = "cookie"
+	CanaryDefaultCookieName  string = "tyk_canary"
+	CanaryBuckets            int    = 10000
+	CanaryCookieMaxAgeInDays int    = 30
+)
+
+// CanaryConfig splits the requests that don't ask for a version between versions by weight, so traffic can
+// be moved to a version with a new override target gradually. Requests are put in a bucket, which is taken
+// from the API key or a cookie for sticky routing, and the buckets are shared out by the weights in version
+// name order. With two versions, raising the weight of one only moves clients to it from the other, with
+// more versions a change to one weight can also move clients between the others
+type CanaryConfig struct {
+	Enabled    bool           `mapstructure:"enabled" bson:"enabled" json:"enabled"`
+	Weights    map[string]int `mapstructure:"weights" bson:"weights" json:"weights"`
+	StickyOn   string         `mapstructure:"sticky_on" bson:"sticky_on" json:"sticky_on"`
+	CookieName string         `mapstructure:"cookie_name" bson:"cookie_name" json:"cookie_name"`
+}
+
+type canaryRawData struct {
+	Canary CanaryConfig `mapstructure:"canary"`
+}
+
+// getCanaryConfig decodes the canary options from the raw API definition
+func getCanaryConfig(rawData map[string]interface{}) CanaryConfig {
+	var thisConfig canaryRawData
+	if !decodeRawConfig(rawData, "canary options", &thisConfig) {
+		return CanaryConfig{}
+	}
+
+	if thisConfig.Canary.CookieName == "" {
+		thisConfig.Canary.CookieName = CanaryDefaultCookieName
+	}
+
+	return thisConfig.Canary
+}
+
+// CanaryWeightRegistry holds the weights set through the control API, they take precedence over the
+// weights in the API definition and are kept when the APIs are reloaded. The weights are saved in the
+// cluster store so that every gateway uses the same split and it survives restarts, other gateways
+// are told to load them again through the notification channel
+type CanaryWeightRegistry struct {
+	mu      sync.RWMutex
+	weights map[string]map[string]int
+	store   StorageHandler
+}
+
+var CanaryWeights = &CanaryWeightRegistry{weights: make(map[string]map[string]int)}
+
+// InitCanaryWeights sets the store the weights are saved in
+func InitCanaryWeights(store StorageHandler) {
+	CanaryWeights.mu.Lock()
+	defer CanaryWeights.mu.Unlock()
+
+	CanaryWeights.store = store
+}
+
+// Get returns the weights that were set for an API
+func (c *CanaryWeightRegistry) Get(APIID string) (map[string]int, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	weights, found := c.weights[APIID]
+	return weights, found
+}
+
+// Set replaces the weights for an API
+func (c *CanaryWeightRegistry) Set(APIID string, weights map[string]int) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != nil {
+		asJSON, err := json.Marshal(weights)
+		if err != nil {
+			return err
+		}
+		if err := c.store.SetKey(APIID, string(asJSON), 0); err != nil {
+			return err
+		}
+	}
+
+	c.weights[APIID] = weights
+	return nil
+}
+
+// Delete goes back to the weights in the API definition
+func (c *CanaryWeightRegistry) Delete(APIID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store != nil {
+		c.store.DeleteKey(APIID)
+	}
+
+	delete(c.weights, APIID)
+}
+
+// Load reads the weights for an API from the store, it is used when the API is loaded and when
+// another gateway has changed them
+func (c *CanaryWeightRegistry) Load(APIID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.store == nil {
+		return
+	}
+
+	asJSON, err := c.store.GetKey(APIID)
+	if err != nil {
+		delete(c.weights, APIID)
+		return
+	}
+
+	var weights map[string]int
+	if err := json.Unmarshal([]byte(asJSON), &weights); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+			"api_id": APIID,
+		}).Error("Couldn't decode canary weights: ", err)
+		return
+	}
+
+	c.weights[APIID] = weights
+}
+
+// canaryWeights are the weights in use for the API
+func (a *APISpec) canaryWeights() map[string]int {
+	if weights, found := CanaryWeights.Get(a.APIID); found {
+		return weights
+	}
+
+	return a.Canary.Weights
+}
+
+// canaryBucket puts the request in a bucket, sticky requests always get the same bucket
+func (a *APISpec) canaryBucket(w http.ResponseWriter, r *http.Request) int {
+	switch a.Canary.StickyOn {
+	case CanaryStickyOnAPIKey:
+		if key := getAuthKeyFromRequest(a, r); key != "" {
+			return int(crc32.ChecksumIEEE([]byte(key)) % uint32(CanaryBuckets))
+		}
+	case CanaryStickyOnCookie:
+		if cookie, err := r.Cookie(a.Canary.CookieName); err == nil {
+			if bucket, err := strconv.Atoi(cookie.Value); err == nil && bucket >= 0 && bucket < CanaryBuckets {
+				return bucket
+			}
+		}
+
+		bucket := rand.Intn(CanaryBuckets)
+		http.SetCookie(w, &http.Cookie{
+			Name:     a.Canary.CookieName,
+			Value:    strconv.Itoa(bucket),
+			Path:     a.Proxy.ListenPath,
+			MaxAge:   CanaryCookieMaxAgeInDays * 24 * 60 * 60,
+			HttpOnly: true,
+		})
+		return bucket
+	}
+
+	return rand.Intn(CanaryBuckets)
+}
+
+// canaryVersionForBucket shares the buckets out between the versions, in name order so that the split is stable
+func canaryVersionForBucket(weights map[string]int, versions map[string]bool, bucket int) string {
+	names := make([]string, 0, len(weights))
+	var total int
+	for versionName, weight := range weights {
+		if weight > 0 && versions[versionName] {
+			names = append(names, versionName)
+			total += weight
+		}
+	}
+
+	if total == 0 {
+		return ""
+	}
+
+	sort.Strings(names)
+	point := bucket * total / CanaryBuckets
+	for _, versionName := range names {
+		point -= weights[versionName]
+		if point < 0 {
+			return versionName
+		}
+	}
+
+	return names[len(names)-1]
+}
+
+// setCanaryVersion picks the version for requests that don't ask for one, the version check uses it
+// instead of the default or a missing version
+func (a *APISpec) setCanaryVersion(w http.ResponseWriter, r *http.Request) {
+	if !a.Canary.Enabled {
+		return
+	}
+
+	if !a.APIDefinition.VersionData.NotVersioned && a.getRequestedVersion(r) != "" {
+		return
+	}
+
+	versions := make(map[string]bool)
+	for versionName := range a.APIDefinition.VersionData.Versions {
+		versions[versionName] = true
+	}
+
+	bucket := a.canaryBucket(w, r)
+	if versionName := canaryVersionForBucket(a.canaryWeights(), versions, bucket); versionName != "" {
+		context.Set(r, CanaryVersion, versionName)
+		context.Set(r, CanaryBucket, bucket)
+	}
+}
+
+// canaryVersionFor keeps the canary split within the versions a key may use, a request that was split to
+// a version the key can't access is moved to one of the allowed versions in the split using the same bucket.
+// The version is left as it is if the key can't use any version in the split
+func (a *APISpec) canaryVersionFor(r *http.Request, allowed []string) string {
+	canaryVersion, isCanary := context.Get(r, CanaryVersion).(string)
+	if !isCanary {
+		return a.getVersionFromRequest(r)
+	}
+
+	versions := make(map[string]bool)
+	for _, versionName := range allowed {
+		if versionName == canaryVersion {
+			return canaryVersion
+		}
+		if _, found := a.APIDefinition.VersionData.Versions[versionName]; found {
+			versions[versionName] = true
+		}
+	}
+
+	bucket, _ := context.Get(r, CanaryBucket).(int)
+	versionName := canaryVersionForBucket(a.canaryWeights(), versions, bucket)
+	if versionName == "" {
+		return canaryVersion
+	}
+
+	// The version data was looked up for the first pick
+	context.Set(r, CanaryVersion, versionName)
+	context.Delete(r, VersionData)
+	context.Delete(r, VersionKeyContext)
+	return versionName
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"github.com/gorilla/context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+var canaryVersions = map[string]bool{"v1": true, "v2": true}
+
+var canaryTestDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"auth": {
+			"auth_header_name": "authorization"
+		},
+		"version_data": {
+			"not_versioned": false,
+			"versions": {
+				"v1": {
+					"name": "v1",
+					"expires": "3000-01-02 15:04"
+				},
+				"v2": {
+					"name": "v2",
+					"expires": "3000-01-02 15:04"
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://lonelycode.com",
+			"strip_listen_path": false
+		}
+	}
+
+`
+
+func TestCanaryVersionForBucket(t *testing.T) {
+	weights := map[string]int{"v1": 95, "v2": 5}
+
+	counts := make(map[string]int)
+	for bucket := 0; bucket < CanaryBuckets; bucket++ {
+		counts[canaryVersionForBucket(weights, canaryVersions, bucket)]++
+	}
+
+	if counts["v1"] != 9500 || counts["v2"] != 500 {
+		t.Error("Buckets were not split by weight: ", counts)
+	}
+
+	// Unknown versions and empty weights are ignored
+	if canaryVersionForBucket(map[string]int{"v3": 100}, canaryVersions, 0) != "" {
+		t.Error("Unknown versions should not be picked")
+	}
+}
+
+func TestCanaryStickyOnAPIKey(t *testing.T) {
+	spec := createDefinitionFromString(apiTestDef)
+	spec.Canary = CanaryConfig{Enabled: true, Weights: map[string]int{"Default": 100}, StickyOn: CanaryStickyOnAPIKey}
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("authorization", "1234")
+
+	bucket := spec.canaryBucket(httptest.NewRecorder(), req)
+	for i := 0; i < 5; i++ {
+		if spec.canaryBucket(httptest.NewRecorder(), req) != bucket {
+			t.Fatal("The same key should always get the same bucket")
+		}
+	}
+
+	spec.setCanaryVersion(httptest.NewRecorder(), req)
+	defer context.Clear(req)
+	if context.Get(req, CanaryVersion) != "Default" {
+		t.Error("Canary version was not set for a request without a version")
+	}
+
+	if spec.getVersionFromRequest(req) != "Default" {
+		t.Error("The canary version should be the version of the request")
+	}
+}
+
+func TestCanaryStickyOnAPIKeyFromParam(t *testing.T) {
+	spec := createDefinitionFromString(apiTestDef)
+	spec.APIDefinition.Auth.UseParam = true
+	spec.Canary = CanaryConfig{Enabled: true, Weights: map[string]int{"Default": 100}, StickyOn: CanaryStickyOnAPIKey}
+
+	req, _ := http.NewRequest("GET", "/v1/test?authorization=1234", nil)
+	header, _ := http.NewRequest("GET", "/v1/test", nil)
+	header.Header.Set("authorization", "1234")
+
+	if spec.canaryBucket(httptest.NewRecorder(), req) != spec.canaryBucket(httptest.NewRecorder(), header) {
+		t.Error("Keys sent as a query param should get the same bucket as in the header")
+	}
+}
+
+func TestCanaryStickyOnCookie(t *testing.T) {
+	spec := createDefinitionFromString(apiTestDef)
+	spec.Canary = getCanaryConfig(map[string]interface{}{
+		"canary": map[string]interface{}{"enabled": true, "sticky_on": "cookie"},
+	})
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	bucket := spec.canaryBucket(recorder, req)
+
+	cookies := (&http.Response{Header: recorder.Header()}).Cookies()
+	if len(cookies) != 1 || cookies[0].Name != CanaryDefaultCookieName || cookies[0].Value != strconv.Itoa(bucket) {
+		t.Fatal("Bucket cookie was not set: ", recorder.Header())
+	}
+
+	req.AddCookie(cookies[0])
+	if spec.canaryBucket(httptest.NewRecorder(), req) != bucket {
+		t.Error("Bucket should be taken from the cookie")
+	}
+}
+
+func TestCanaryExplicitVersion(t *testing.T) {
+	spec := createDefinitionFromString(apiTestDef)
+	spec.Canary = CanaryConfig{Enabled: true, Weights: map[string]int{"Default": 100}}
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("version", "Default")
+	spec.setCanaryVersion(httptest.NewRecorder(), req)
+	defer context.Clear(req)
+
+	if _, found := context.GetOk(req, CanaryVersion); found {
+		t.Error("Requests that ask for a version should not be split")
+	}
+}
+
+func TestCanaryWeightsShared(t *testing.T) {
+	store := createMemoryStorageManager("canary-weights-")
+	thisNode := &CanaryWeightRegistry{weights: make(map[string]map[string]int), store: store}
+	otherNode := &CanaryWeightRegistry{weights: make(map[string]map[string]int), store: store}
+
+	if err := thisNode.Set("1", map[string]int{"v1": 90, "v2": 10}); err != nil {
+		t.Fatal("Weights were not saved: ", err)
+	}
+
+	otherNode.Load("1")
+	if weights, found := otherNode.Get("1"); !found || weights["v2"] != 10 {
+		t.Fatal("Weights should be loaded from the store: ", weights)
+	}
+
+	thisNode.Delete("1")
+	otherNode.Load("1")
+	if _, found := otherNode.Get("1"); found {
+		t.Error("Deleted weights should be dropped when loaded again")
+	}
+}
+
+func TestCanaryKeepsKeysOnAllowedVersions(t *testing.T) {
+	spec := createDefinitionFromString(canaryTestDef)
+	spec.Canary = CanaryConfig{Enabled: true, Weights: map[string]int{"v1": 50, "v2": 50}}
+	mw := &AccessRightsCheck{&TykMiddleware{&spec, nil}}
+
+	thisSession := createStandardSession()
+	thisSession.AccessRights = map[string]AccessDefinition{"1": {APIName: "Tyk Test API", APIID: "1", Versions: []string{"v1"}}}
+
+	// The request was split to the canary version, which the key can't use
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	context.Set(req, SessionData, thisSession)
+	context.Set(req, CanaryVersion, "v2")
+	context.Set(req, CanaryBucket, CanaryBuckets-1)
+	defer context.Clear(req)
+
+	if err, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+		t.Fatal("Key limited to the stable version should be let through, got: ", code, err)
+	}
+
+	if spec.getVersionFromRequest(req) != "v1" {
+		t.Error("Request should be moved to the version the key can use, got: ", spec.getVersionFromRequest(req))
+	}
+
+	// Keys that can't use any version in the split are still refused
+	spec.Canary.Weights = map[string]int{"v2": 100}
+	req, _ = http.NewRequest("GET", "/v1/test", nil)
+	context.Set(req, SessionData, thisSession)
+	context.Set(req, CanaryVersion, "v2")
+	context.Set(req, CanaryBucket, 0)
+	defer context.Clear(req)
+
+	if _, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 403 {
+		t.Error("Key without access to the split versions should be refused, got: ", code)
+	}
+}
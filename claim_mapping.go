@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/context"
+	"net/http"
+	"strings"
+)
+
+// ClaimContextPrefix is prepended to the meta key of mapped claims in the context variables
+const ClaimContextPrefix string = "claim_"
+
+// ClaimMapping copies a JWT or OpenID claim to an upstream header and to the session meta data. Nested
+// claims can be selected with dots (e.g. "realm_access.roles"), the meta key defaults to the claim name
+type ClaimMapping struct {
+	Claim   string `mapstructure:"claim" bson:"claim" json:"claim"`
+	Header  string `mapstructure:"header" bson:"header" json:"header"`
+	MetaKey string `mapstructure:"meta_key" bson:"meta_key" json:"meta_key"`
+}
+
+type claimMappingsRawData struct {
+	ClaimMappings []ClaimMapping `mapstructure:"claim_mappings"`
+}
+
+// getClaimMappings decodes the claim mapping table from the raw API definition
+func getClaimMappings(rawData map[string]interface{}) []ClaimMapping {
+	var thisConfig claimMappingsRawData
+	if rawData == nil {
+		return thisConfig.ClaimMappings
+	}
+
+	if !decodeRawConfig(rawData, "claim mappings", &thisConfig) {
+		return nil
+	}
+
+	for i, mapping := range thisConfig.ClaimMappings {
+		if mapping.MetaKey == "" {
+			thisConfig.ClaimMappings[i].MetaKey = mapping.Claim
+		}
+	}
+
+	return thisConfig.ClaimMappings
+}
+
+// lookupClaim finds a claim, following dots into nested objects
+func lookupClaim(claims map[string]interface{}, name string) (interface{}, bool) {
+	if claim, found := claims[name]; found {
+		return claim, true
+	}
+
+	parts := strings.SplitN(name, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+
+	nested, isObject := claims[parts[0]].(map[string]interface{})
+	if !isObject {
+		return nil, false
+	}
+
+	return lookupClaim(nested, parts[1])
+}
+
+// claimValueString flattens a claim for use in a header, arrays are comma separated and objects are JSON
+func claimValueString(claim interface{}) string {
+	var value string
+	switch claimValue := claim.(type) {
+	case string:
+		value = claimValue
+	case []interface{}:
+		values := make([]string, len(claimValue))
+		for i, item := range claimValue {
+			values[i] = claimValueString(item)
+		}
+		value = strings.Join(values, ",")
+	case map[string]interface{}:
+		asJSON, _ := json.Marshal(claimValue)
+		value = string(asJSON)
+	default:
+		value = fmt.Sprint(claimValue)
+	}
+
+	// Claims must not be able to add headers
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
+
+// applyClaimMappings sets the mapped claims as upstream headers and in the session meta data of the
+// request, it must be called after the session has been set on the request context. Mapped headers
+// that the client sent are removed if the claim is missing so they can't be spoofed
+func applyClaimMappings(r *http.Request, mappings []ClaimMapping, claims map[string]interface{}) {
+	if len(mappings) == 0 {
+		return
+	}
+
+	thisSessionState, sessionFound := context.Get(r, SessionData).(SessionState)
+
+	// The session may be shared with the session cache, so the meta data is copied
+	metaData := make(map[string]interface{})
+	if sessionFound {
+		if existing, ok := thisSessionState.MetaData.(map[string]interface{}); ok {
+			for key, value := range existing {
+				metaData[key] = value
+			}
+		}
+	}
+
+	claimData := make(map[string]string)
+	for _, mapping := range mappings {
+		claim, found := lookupClaim(claims, mapping.Claim)
+		if !found {
+			if mapping.Header != "" {
+				r.Header.Del(mapping.Header)
+			}
+			continue
+		}
+
+		value := claimValueString(claim)
+		if mapping.Header != "" {
+			r.Header.Set(mapping.Header, value)
+		}
+		metaData[mapping.MetaKey] = value
+		claimData[mapping.MetaKey] = value
+	}
+
+	if sessionFound {
+		thisSessionState.MetaData = metaData
+		context.Set(r, SessionData, thisSessionState)
+	}
+
+	context.Set(r, ClaimData, claimData)
+}
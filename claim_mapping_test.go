@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/gorilla/context"
+	"net/http"
+	"testing"
+)
+
+func TestClaimMappingsDecoded(t *testing.T) {
+	mappings := getClaimMappings(map[string]interface{}{
+		"claim_mappings": []interface{}{
+			map[string]interface{}{"claim": "email", "header": "X-User-Email"},
+			map[string]interface{}{"claim": "realm_access.roles", "meta_key": "roles"},
+		},
+	})
+
+	if len(mappings) != 2 || mappings[0].MetaKey != "email" || mappings[1].MetaKey != "roles" {
+		t.Error("Claim mappings were not decoded: ", mappings)
+	}
+}
+
+func TestApplyClaimMappings(t *testing.T) {
+	mappings := []ClaimMapping{
+		{Claim: "email", Header: "X-User-Email", MetaKey: "email"},
+		{Claim: "realm_access.roles", Header: "X-User-Roles", MetaKey: "roles"},
+		{Claim: "tenant", Header: "X-Tenant", MetaKey: "tenant"},
+	}
+
+	claims := map[string]interface{}{
+		"email":        "user@example.com\r\nX-Injected: true",
+		"realm_access": map[string]interface{}{"roles": []interface{}{"admin", "user"}},
+	}
+
+	sharedMeta := map[string]interface{}{"TykJWTSessionID": "session"}
+	thisSession := createStandardSession()
+	thisSession.MetaData = sharedMeta
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("X-Tenant", "spoofed")
+	context.Set(req, SessionData, thisSession)
+	defer context.Clear(req)
+
+	applyClaimMappings(req, mappings, claims)
+
+	if req.Header.Get("X-User-Email") != "user@example.comX-Injected: true" {
+		t.Error("Claim header was not set safely: ", req.Header.Get("X-User-Email"))
+	}
+
+	if req.Header.Get("X-User-Roles") != "admin,user" {
+		t.Error("Nested array claim was not mapped: ", req.Header.Get("X-User-Roles"))
+	}
+
+	if req.Header.Get("X-Tenant") != "" {
+		t.Error("Headers for missing claims should be removed")
+	}
+
+	metaData := context.Get(req, SessionData).(SessionState).MetaData.(map[string]interface{})
+	if metaData["roles"] != "admin,user" || metaData["TykJWTSessionID"] != "session" {
+		t.Error("Claims were not added to the session meta data: ", metaData)
+	}
+
+	if len(sharedMeta) != 1 {
+		t.Error("Existing session meta data should not be changed")
+	}
+
+	if claimData := context.Get(req, ClaimData).(map[string]string); claimData["roles"] != "admin,user" {
+		t.Error("Claims were not stored for the context variables: ", claimData)
+	}
+}
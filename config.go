@@ -31,16 +31,20 @@ type Config struct {
 	} `json:"db_app_conf_options"`
 	AppPath string `json:"app_path"`
 	Storage struct {
-		Type          string            `json:"type"`
-		Host          string            `json:"host"`
-		Port          int               `json:"port"`
-		Hosts         map[string]string `json:"hosts"`
-		Username      string            `json:"username"`
-		Password      string            `json:"password"`
-		Database      int               `json:"database"`
-		MaxIdle       int               `json:"optimisation_max_idle"`
-		MaxActive     int               `json:"optimisation_max_active"`
-		EnableCluster bool              `json:"enable_cluster"`
+		Type               string            `json:"type"`
+		Host               string            `json:"host"`
+		Port               int               `json:"port"`
+		Hosts              map[string]string `json:"hosts"`
+		Username           string            `json:"username"`
+		Password           string            `json:"password"`
+		Database           int               `json:"database"`
+		MaxIdle            int               `json:"optimisation_max_idle"`
+		MaxActive          int               `json:"optimisation_max_active"`
+		EnableCluster      bool              `json:"enable_cluster"`
+		Path               string            `json:"path"`
+		EnableSentinel     bool              `json:"enable_sentinel"`
+		SentinelMasterName string            `json:"sentinel_master_name"`
+		SentinelHosts      map[string]string `json:"sentinel_hosts"`
 	} `json:"storage"`
 	EnableAnalytics bool `json:"enable_analytics"`
 	AnalyticsConfig struct {
@@ -56,6 +60,24 @@ type Config struct {
 			Custom             []string             `json:"custom_patterns"`
 			compiledPatternSet NormaliseURLPatterns // see analytics.go
 		} `json:"normalise_urls"`
+		BufferSize    int `json:"buffer_size"`
+		BatchSize     int `json:"batch_size"`
+		FlushInterval int `json:"flush_interval"`
+		FileSpool     struct {
+			Path      string `json:"path"`
+			MaxSizeMB int    `json:"max_size_mb"`
+			MaxFiles  int    `json:"max_files"`
+		} `json:"file_spool"`
+		StatsD struct {
+			Address   string `json:"address"`
+			Prefix    string `json:"prefix"`
+			DogStatsD bool   `json:"dogstatsd"`
+		} `json:"statsd"`
+		HTTPPush struct {
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+			Timeout int               `json:"timeout"`
+		} `json:"http_push"`
 		ignoredIPsCompiled map[string]bool
 	} `json:"analytics_config"`
 	HealthCheck struct {
@@ -75,6 +97,8 @@ type Config struct {
 	ExperimentalProcessOrgOffThread   bool   `json:"experimental_process_org_off_thread"`
 	EnableNonTransactionalRateLimiter bool   `json:"enable_non_transactional_rate_limiter"`
 	EnableSentinelRateLImiter         bool   `json:"enable_sentinel_rate_limiter"`
+	EnableLocalRateLimiter            bool   `json:"enable_local_rate_limiter"`
+	LocalRateLimiterSyncInterval      int    `json:"local_rate_limiter_sync_interval"`
 	Monitor                           struct {
 		EnableTriggerMonitors bool               `json:"enable_trigger_monitors"`
 		Config                WebHookHandlerConf `json:"configuration"`
@@ -102,15 +126,16 @@ type Config struct {
 	} `json:"local_session_cache"`
 
 	HttpServerOptions struct {
-		OverrideDefaults bool       `json:"override_defaults"`
-		ReadTimeout      int        `json:"read_timeout"`
-		WriteTimeout     int        `json:"write_timeout"`
-		UseSSL           bool       `json:"use_ssl"`
-		EnableWebSockets bool       `json:"enable_websockets"`
-		Certificates     []CertData `json:"certificates"`
-		ServerName       string     `json:"server_name"`
-		MinVersion       uint16     `json:"min_version"`
-		FlushInterval    int        `json:"flush_interval"`
+		OverrideDefaults          bool       `json:"override_defaults"`
+		ReadTimeout               int        `json:"read_timeout"`
+		WriteTimeout              int        `json:"write_timeout"`
+		UseSSL                    bool       `json:"use_ssl"`
+		EnableWebSockets          bool       `json:"enable_websockets"`
+		Certificates              []CertData `json:"certificates"`
+		ServerName                string     `json:"server_name"`
+		MinVersion                uint16     `json:"min_version"`
+		FlushInterval             int        `json:"flush_interval"`
+		RequestClientCertificates bool       `json:"request_client_certificates"`
 	} `json:"http_server_options"`
 	ServiceDiscovery struct {
 		DefaultCacheTimeout int `json:"default_cache_timeout"`
@@ -131,6 +156,17 @@ type Config struct {
 			EnableUptimeAnalytics    bool `json:"enable_uptime_analytics"`
 		} `json:"config"`
 	} `json:"uptime_tests"`
+	Tracing struct {
+		Enabled       bool              `json:"enabled"`
+		Exporter      string            `json:"exporter"`
+		ServiceName   string            `json:"service_name"`
+		OTLPEndpoint  string            `json:"otlp_endpoint"`
+		OTLPHeaders   map[string]string `json:"otlp_headers"`
+		FilePath      string            `json:"file_path"`
+		BufferSize    int               `json:"buffer_size"`
+		BatchSize     int               `json:"batch_size"`
+		FlushInterval int               `json:"flush_interval"`
+	} `json:"tracing"`
 	HostName             string                                   `json:"hostname"`
 	EnableAPISegregation bool                                     `json:"enable_api_segregation"`
 	ControlAPIHostname   string                                   `json:"control_api_hostname"`
@@ -41,16 +41,16 @@ type WebHookHandlerConf struct {
 type WebHookHandler struct {
 	conf     WebHookHandlerConf
 	template *template.Template
-	store    *RedisClusterStorageManager
+	store    ClusterStorageHandler
 }
 
 // Not Pretty, but will avoi dmillions of connections
-var WebHookRedisStorePointer *RedisClusterStorageManager
+var WebHookRedisStorePointer ClusterStorageHandler
 
 // GetRedisInterfacePointer creates a reference to a redis connection pool that can be shared across all webhook instances
-func GetRedisInterfacePointer() *RedisClusterStorageManager {
+func GetRedisInterfacePointer() ClusterStorageHandler {
 	if WebHookRedisStorePointer == nil {
-		WebHookRedisStorePointer = &RedisClusterStorageManager{KeyPrefix: "webhook.cache."}
+		WebHookRedisStorePointer = GetClusterStorageHandler("webhook.cache.", false)
 		WebHookRedisStorePointer.Connect()
 	}
 
@@ -58,9 +58,10 @@ type EVENT_QuotaExceededMeta struct {
 // EVENT_RateLimitExceededMeta is the metadata structure for a rate limit exceeded event (EVENT_RateLimitExceeded)
 type EVENT_RateLimitExceededMeta struct {
 	EventMetaDefault
-	Path   string
-	Origin string
-	Key    string
+	Path     string
+	Origin   string
+	Key      string
+	Endpoint string // The rate limited path pattern, empty if the key-level limit was hit
 }
 
 // EVENT_AuthFailureMeta is the metadata structure for an auth failure (EVENT_AuthFailure)
@@ -69,6 +70,7 @@ type EVENT_AuthFailureMeta struct {
 	Path   string
 	Origin string
 	Key    string
+	Reason string
 }
 
 // EVENT_CurcuitBreakerMeta is the event status for a circuit breaker tripping
@@ -40,6 +40,8 @@ func (e ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, err st
 		return
 	}
 
+	GatewayMetrics.RecordRequest(e.Spec, e.Spec.getVersionFromRequest(r), errCode, 0, false)
+
 	keyName := ""
 	// Track the key ID if it exists
 	authHeaderValue := context.Get(r, AuthHeaderValue)
@@ -53,6 +55,9 @@ func (e ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, err st
 			keyName = authHeaderValue.(string)
 		}
 
+		// Requests that were retried upstream record the number of attempts
+		attempts, _ := context.Get(r, UpstreamAttempts).(int)
+
 		version := e.Spec.getVersionFromRequest(r)
 		if version == "" {
 			version = "Non Versioned"
@@ -119,6 +124,9 @@ func (e ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, err st
 			GeoData{},
 			tags,
 			alias,
+			attempts,
+			0,
+			0,
 			time.Now(),
 		}
 
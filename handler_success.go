@@ -19,12 +19,21 @@ type ContextKey int
 // Enums for keys to be stored in a session context - this is how gorilla expects
 // these to be implemented and is lifted pretty much from docs
 const (
-	SessionData       = 0
-	AuthHeaderValue   = 1
-	VersionData       = 2
-	VersionKeyContext = 3
-	OrgSessionContext = 4
-	ContextData       = 5
+	SessionData         = 0
+	AuthHeaderValue     = 1
+	VersionData         = 2
+	VersionKeyContext   = 3
+	OrgSessionContext   = 4
+	ContextData         = 5
+	TraceContext        = 6
+	SuppressAuthFailure = 7
+	ClaimData           = 8
+	UpstreamTarget      = 9
+	UpstreamExcluded    = 10
+	UpstreamAttempts    = 11
+	TrafficMirrorData   = 12
+	CanaryVersion       = 13
+	CanaryBucket        = 14
 )
 
 var SessionCache *cache.Cache = cache.New(10*time.Second, 5*time.Second)
@@ -123,6 +132,8 @@ func (t TykMiddleware) ApplyPolicyIfExists(key string, thisSession *SessionState
 					thisSession.Allowance = policy.Rate // This is a legacy thing, merely to make sure output is consistent. Needs to be purged
 					thisSession.Rate = policy.Rate
 					thisSession.Per = policy.Per
+					thisSession.RateLimitAlgorithm = policy.RateLimitAlgorithm
+					thisSession.RateLimitBurst = policy.RateLimitBurst
 				}
 
 				if policy.Partitions.Acl {
@@ -143,6 +154,8 @@ func (t TykMiddleware) ApplyPolicyIfExists(key string, thisSession *SessionState
 				thisSession.Allowance = policy.Rate // This is a legacy thing, merely to make sure output is consistent. Needs to be purged
 				thisSession.Rate = policy.Rate
 				thisSession.Per = policy.Per
+				thisSession.RateLimitAlgorithm = policy.RateLimitAlgorithm
+				thisSession.RateLimitBurst = policy.RateLimitBurst
 
 				// ACL
 				thisSession.AccessRights = policy.AccessRights
@@ -234,6 +247,9 @@ func (s SuccessHandler) RecordHit(w http.ResponseWriter, r *http.Request, timing
 			keyName = authHeaderValue.(string)
 		}
 
+		// Requests that were retried upstream record the number of attempts
+		attempts, _ := context.Get(r, UpstreamAttempts).(int)
+
 		// Track version data
 		version := s.Spec.getVersionFromRequest(r)
 		if version == "" {
@@ -294,6 +310,9 @@ func (s SuccessHandler) RecordHit(w http.ResponseWriter, r *http.Request, timing
 			GeoData{},
 			tags,
 			alias,
+			attempts,
+			0,
+			0,
 			time.Now(),
 		}
 
@@ -315,11 +334,15 @@ func (s SuccessHandler) RecordHit(w http.ResponseWriter, r *http.Request, timing
 			thisRecord.NormalisePath()
 		}
 
-		go analytics.RecordHit(thisRecord)
+		// Mirrored requests add the mirror's results before the record is stored
+		if mirror, found := context.Get(r, TrafficMirrorData).(*trafficMirror); !found || !mirror.recordWith(thisRecord) {
+			go analytics.RecordHit(thisRecord)
+		}
 	}
 
 	// Report in health check
 	ReportHealthCheckValue(s.Spec.Health, RequestLog, strconv.FormatInt(int64(timing), 10))
+	GatewayMetrics.RecordRequest(s.Spec, s.Spec.getVersionFromRequest(r), code, timing, true)
 
 	if doMemoryProfile {
 		pprof.WriteHeapProfile(profileFile)
@@ -345,6 +368,11 @@ func (s SuccessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) *http.
 		copiedRequest = CopyHttpRequest(r)
 	}
 
+	if mirror := startTrafficMirror(s.Spec, r); mirror != nil {
+		context.Set(r, TrafficMirrorData, mirror)
+		defer mirror.finish()
+	}
+
 	t1 := time.Now()
 	resp := s.Proxy.ServeHTTP(w, r)
 	t2 := time.Now()
@@ -377,6 +405,11 @@ func (s SuccessHandler) ServeHTTPWithCache(w http.ResponseWriter, r *http.Reques
 		copiedRequest = CopyHttpRequest(r)
 	}
 
+	if mirror := startTrafficMirror(s.Spec, r); mirror != nil {
+		context.Set(r, TrafficMirrorData, mirror)
+		defer mirror.finish()
+	}
+
 	t1 := time.Now()
 	inRes := s.Proxy.ServeHTTPForCache(w, r)
 	t2 := time.Now()
@@ -16,7 +16,7 @@ var GlobalHostChecker HostCheckerManager
 
 type HostCheckerManager struct {
 	Id                string
-	store             *RedisClusterStorageManager
+	store             ClusterStorageHandler
 	checker           *HostUptimeChecker
 	stopLoop          bool
 	pollerStarted     bool
@@ -67,7 +67,7 @@ const (
 	UptimeAnalytics_KEYNAME string = "tyk-uptime-analytics"
 )
 
-func (hc *HostCheckerManager) Init(store *RedisClusterStorageManager) {
+func (hc *HostCheckerManager) Init(store ClusterStorageHandler) {
 	hc.store = store
 	hc.unhealthyHostList = make(map[string]bool)
 	hc.resetsInitiated = make(map[string]bool)
@@ -500,7 +500,7 @@ func (hc HostCheckerManager) RecordUptimeAnalytics(thisReport HostHealthReport)
 	return nil
 }
 
-func InitHostCheckManager(store *RedisClusterStorageManager) {
+func InitHostCheckManager(store ClusterStorageHandler) {
 	GlobalHostChecker = HostCheckerManager{}
 	GlobalHostChecker.Init(store)
 	GlobalHostChecker.Start()
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	b64 "encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// JWKSDefaultTTL is used when the JWKS response has no max-age
+	JWKSDefaultTTL time.Duration = 240 * time.Second
+	// JWKSMinRefetchInterval limits how often a JWKS URL is fetched, including refetches for unknown kids
+	JWKSMinRefetchInterval time.Duration = 30 * time.Second
+	// JWKSDefaultStaleIfError is how long keys are kept past their TTL while the JWKS URL is failing,
+	// unless the response sets stale-if-error
+	JWKSDefaultStaleIfError time.Duration = time.Hour
+	// JWKSIdleTimeout stops the background refresh of JWKS URLs that are no longer used
+	JWKSIdleTimeout time.Duration = time.Hour
+)
+
+var errJWKNotFound = errors.New("No matching KID could be found")
+
+// jwksKey is a parsed public key from a JWKS, PEM encoded x5c values are kept as bytes
+type jwksKey struct {
+	kty string
+	key interface{}
+}
+
+type jwksEntry struct {
+	url string
+
+	// fetchMu serialises fetches of the URL
+	fetchMu sync.Mutex
+
+	mu         sync.RWMutex
+	keys       map[string][]jwksKey
+	expires    time.Time
+	staleUntil time.Time
+	lastFetch  time.Time
+	lastUsed   time.Time
+}
+
+// JWKSCache holds the keys of each JWKS URL by kid and refreshes them in the background
+type JWKSCache struct {
+	mu      sync.Mutex
+	entries map[string]*jwksEntry
+	client  *http.Client
+}
+
+var jwksCache = newJWKSCache()
+
+func newJWKSCache() *JWKSCache {
+	return &JWKSCache{
+		entries: make(map[string]*jwksEntry),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// GetKey returns the public key for a kid, the JWKS is refetched if the kid is unknown as long as it
+// wasn't fetched too recently
+func (c *JWKSCache) GetKey(url string, kid string, kty string) (interface{}, error) {
+	c.mu.Lock()
+	entry, found := c.entries[url]
+	if !found {
+		entry = &jwksEntry{url: url, lastUsed: time.Now()}
+		c.entries[url] = entry
+	}
+	c.mu.Unlock()
+
+	if !found {
+		if err := c.refresh(entry, true); err != nil {
+			log.Error("Failed to fetch JWKS: ", err)
+		}
+		go c.refreshLoop(entry)
+	}
+
+	key, err := entry.find(kid, kty)
+	if err != nil {
+		log.Debug("Key not available, refetching JWKS: ", err)
+		if refreshErr := c.refresh(entry, false); refreshErr != nil {
+			log.Error("Failed to fetch JWKS: ", refreshErr)
+		}
+		key, err = entry.find(kid, kty)
+	}
+
+	return key, err
+}
+
+func (e *jwksEntry) find(kid string, kty string) (interface{}, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.lastUsed = time.Now()
+	if e.keys == nil {
+		return nil, errors.New("JWKS is not available")
+	}
+
+	if time.Now().After(e.staleUntil) {
+		return nil, errors.New("JWKS has expired and could not be refreshed")
+	}
+
+	for _, key := range e.keys[kid] {
+		if strings.ToLower(key.kty) == strings.ToLower(kty) {
+			return key.key, nil
+		}
+	}
+
+	return nil, errJWKNotFound
+}
+
+// refresh fetches the JWKS, unless forced it is skipped if the URL was fetched recently. On failure
+// the current keys are kept until they go stale
+func (c *JWKSCache) refresh(entry *jwksEntry, force bool) error {
+	entry.fetchMu.Lock()
+	defer entry.fetchMu.Unlock()
+
+	entry.mu.Lock()
+	if !force && time.Since(entry.lastFetch) < JWKSMinRefetchInterval {
+		entry.mu.Unlock()
+		return nil
+	}
+	entry.lastFetch = time.Now()
+	entry.mu.Unlock()
+
+	keys, ttl, staleIfError, err := c.fetch(entry.url)
+	if err != nil {
+		return err
+	}
+
+	entry.mu.Lock()
+	entry.keys = keys
+	entry.expires = time.Now().Add(ttl)
+	entry.staleUntil = entry.expires.Add(staleIfError)
+	entry.mu.Unlock()
+
+	return nil
+}
+
+// refreshLoop refetches the JWKS as it expires until it is no longer used
+func (c *JWKSCache) refreshLoop(entry *jwksEntry) {
+	for {
+		entry.mu.RLock()
+		wait := entry.expires.Sub(time.Now())
+		idle := time.Since(entry.lastUsed) > JWKSIdleTimeout
+		entry.mu.RUnlock()
+
+		if idle {
+			log.Debug("JWKS is no longer used, stopping refresh: ", entry.url)
+			c.mu.Lock()
+			delete(c.entries, entry.url)
+			c.mu.Unlock()
+			return
+		}
+
+		if wait < JWKSMinRefetchInterval {
+			wait = JWKSMinRefetchInterval
+		}
+		time.Sleep(wait)
+
+		if err := c.refresh(entry, true); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "jwt",
+			}).Warning("Failed to refresh JWKS, using cached keys: ", err)
+		}
+	}
+}
+
+func (c *JWKSCache) fetch(url string) (map[string][]jwksKey, time.Duration, time.Duration, error) {
+	log.Debug("Pulling JWK")
+	response, err := c.client.Get(url)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != 200 {
+		return nil, 0, 0, errors.New("JWKS request failed with status: " + strconv.Itoa(response.StatusCode))
+	}
+
+	contents, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	var thisJWKSet JWKs
+	if err := json.Unmarshal(contents, &thisJWKSet); err != nil {
+		return nil, 0, 0, err
+	}
+
+	keys := make(map[string][]jwksKey)
+	for _, jwk := range thisJWKSet.Keys {
+		key, err := jwk.publicKey()
+		if err != nil {
+			log.Warning("Skipping JWK ", jwk.KID, ": ", err)
+			continue
+		}
+		keys[jwk.KID] = append(keys[jwk.KID], jwksKey{kty: jwk.Kty, key: key})
+	}
+
+	ttl, staleIfError := jwksCacheTimes(response.Header.Get("Cache-Control"))
+	return keys, ttl, staleIfError, nil
+}
+
+// jwksCacheTimes reads the TTL and stale-if-error period from a Cache-Control header
+func jwksCacheTimes(cacheControl string) (time.Duration, time.Duration) {
+	ttl := JWKSDefaultTTL
+	staleIfError := JWKSDefaultStaleIfError
+
+	for _, directive := range strings.Split(cacheControl, ",") {
+		parts := strings.SplitN(strings.TrimSpace(strings.ToLower(directive)), "=", 2)
+		switch parts[0] {
+		case "no-cache", "no-store":
+			ttl = 0
+		case "max-age", "stale-if-error":
+			if len(parts) != 2 {
+				continue
+			}
+			seconds, err := strconv.Atoi(strings.Trim(parts[1], "\""))
+			if err != nil || seconds < 0 {
+				continue
+			}
+			if parts[0] == "max-age" {
+				ttl = time.Duration(seconds) * time.Second
+			} else {
+				staleIfError = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if ttl < JWKSMinRefetchInterval {
+		ttl = JWKSMinRefetchInterval
+	}
+
+	return ttl, staleIfError
+}
+
+func decodeJWKParameter(value string) ([]byte, error) {
+	return b64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+// publicKey returns the key from the first certificate in x5c, or from the raw RSA or EC parameters
+func (j JWK) publicKey() (interface{}, error) {
+	if len(j.X5c) > 0 {
+		decodedCert, err := b64.StdEncoding.DecodeString(j.X5c[0])
+		if err != nil {
+			return nil, err
+		}
+
+		// Some key servers put a PEM encoded key here, it is parsed with the signing method
+		if block, _ := pem.Decode(decodedCert); block != nil {
+			return decodedCert, nil
+		}
+
+		cert, err := x509.ParseCertificate(decodedCert)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+
+	switch strings.ToUpper(j.Kty) {
+	case "RSA":
+		if j.N == "" || j.E == "" {
+			return nil, errors.New("RSA JWK is missing n or e")
+		}
+		n, err := decodeJWKParameter(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeJWKParameter(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, errors.New("Unsupported EC curve: " + j.Crv)
+		}
+		x, err := decodeJWKParameter(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeJWKParameter(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC JWK point is not on the curve")
+		}
+		return key, nil
+	}
+
+	return nil, errors.New("No certificates or key parameters in JWK")
+}
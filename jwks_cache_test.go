@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	b64 "encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func createTestJWKS(t *testing.T) (JWKs, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks := JWKs{Keys: []JWK{
+		{
+			Kty: "RSA",
+			KID: "rsa-key",
+			N:   b64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			E:   b64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		},
+		{
+			Kty: "EC",
+			KID: "ec-key",
+			Crv: "P-256",
+			X:   b64.RawURLEncoding.EncodeToString(ecKey.X.Bytes()),
+			Y:   b64.RawURLEncoding.EncodeToString(ecKey.Y.Bytes()),
+		},
+	}}
+
+	return jwks, rsaKey, ecKey
+}
+
+func TestJWKSCacheTimes(t *testing.T) {
+	ttl, staleIfError := jwksCacheTimes("public, max-age=600, stale-if-error=120")
+	if ttl != 600*time.Second || staleIfError != 120*time.Second {
+		t.Error("Cache-Control was not used: ", ttl, staleIfError)
+	}
+
+	if ttl, _ := jwksCacheTimes(""); ttl != JWKSDefaultTTL {
+		t.Error("Expected the default TTL, got: ", ttl)
+	}
+
+	if ttl, _ := jwksCacheTimes("no-cache"); ttl != JWKSMinRefetchInterval {
+		t.Error("TTL should not go below the refetch interval, got: ", ttl)
+	}
+}
+
+func TestJWKSCacheRawKeys(t *testing.T) {
+	jwks, rsaKey, ecKey := createTestJWKS(t)
+
+	var requests int32
+	failing := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(500)
+			return
+		}
+		w.Header().Set("Cache-Control", "max-age=300")
+		json.NewEncoder(w).Encode(jwks)
+	}))
+	defer server.Close()
+
+	cache := newJWKSCache()
+
+	key, err := cache.GetKey(server.URL, "rsa-key", "rsa")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asRSA, ok := key.(*rsa.PublicKey); !ok || asRSA.N.Cmp(rsaKey.N) != 0 || asRSA.E != rsaKey.E {
+		t.Error("RSA key was not decoded from n and e")
+	}
+
+	key, err = cache.GetKey(server.URL, "ec-key", "EC")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if asEC, ok := key.(*ecdsa.PublicKey); !ok || asEC.X.Cmp(ecKey.X) != 0 || asEC.Y.Cmp(ecKey.Y) != 0 {
+		t.Error("EC key was not decoded from x and y")
+	}
+
+	// Unknown kids can't trigger a refetch straight after a fetch
+	if _, err := cache.GetKey(server.URL, "unknown", "rsa"); err != errJWKNotFound {
+		t.Error("Expected the kid to be unknown, got: ", err)
+	}
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Error("JWKS should only be fetched once, got: ", requests)
+	}
+
+	// Keys are kept while the JWKS URL fails
+	atomic.StoreInt32(&failing, 1)
+	entry := cache.entries[server.URL]
+	if err := cache.refresh(entry, true); err == nil {
+		t.Error("Refresh should fail")
+	}
+	if _, err := cache.GetKey(server.URL, "rsa-key", "rsa"); err != nil {
+		t.Error("Cached key should be used while the JWKS fails: ", err)
+	}
+
+	entry.mu.Lock()
+	entry.staleUntil = time.Now().Add(-time.Second)
+	entry.mu.Unlock()
+	if _, err := cache.GetKey(server.URL, "rsa-key", "rsa"); err == nil {
+		t.Error("Stale keys should not be used")
+	}
+}
+
+func TestJWKSCertificateChain(t *testing.T) {
+	ca, _ := createTestCertificate(t, "JWKS", nil, nil)
+
+	jwk := JWK{Kty: "RSA", KID: "cert-key", X5c: []string{b64.StdEncoding.EncodeToString(ca.Raw)}}
+	key, err := jwk.publicKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if asRSA, ok := key.(*rsa.PublicKey); !ok || asRSA.N.Cmp(ca.PublicKey.(*rsa.PublicKey).N) != 0 {
+		t.Error("Key was not taken from the certificate")
+	}
+}
@@ -149,6 +149,11 @@ func (l *LDAPStorageHandler) IncrememntWithExpire(keyName string, timeout int64)
 	return 999
 }
 
+func (l *LDAPStorageHandler) IncrementByWithExpire(keyName string, n int64, timeout int64) int64 {
+	l.notifyReadOnly()
+	return 999
+}
+
 func (l *LDAPStorageHandler) notifyReadOnly() bool {
 	log.Warning("LDAP storage is READ ONLY")
 	return false
@@ -159,6 +164,11 @@ func (s *LDAPStorageHandler) SetRollingWindow(keyName string, per int64, val str
 	return 0, []interface{}{}
 }
 
+func (s *LDAPStorageHandler) SetRateLimit(keyName string, algorithm string, rate float64, per float64, burst float64) (RateLimitResult, error) {
+	log.Warning("Not Implemented!")
+	return RateLimitResult{}, ErrRateLimitAlgorithmNotSupported
+}
+
 func (s *LDAPStorageHandler) SetRollingWindowPipeline(keyName string, per int64, val string) (int, []interface{}) {
 	log.Warning("Not Implemented!")
 	return 0, []interface{}{}
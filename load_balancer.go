@@ -0,0 +1,268 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	LBRoundRobin         string = "round_robin"
+	LBWeightedRoundRobin string = "weighted_round_robin"
+	LBLeastConnections   string = "least_connections"
+	LBConsistentHash     string = "consistent_hash"
+
+	LBHashOnAPIKey string = "api_key"
+	LBHashOnHeader string = "header"
+	LBHashOnIP     string = "ip"
+
+	// LBHashReplicas is the number of points each target has on the hash ring
+	LBHashReplicas int = 100
+)
+
+// LoadBalancingConfig selects how upstream targets are picked when load balancing is enabled, weights
+// are keyed by the target as it appears in the target list or in the service discovery results
+type LoadBalancingConfig struct {
+	Strategy   string         `mapstructure:"strategy" bson:"strategy" json:"strategy"`
+	Weights    map[string]int `mapstructure:"weights" bson:"weights" json:"weights"`
+	HashOn     string         `mapstructure:"hash_on" bson:"hash_on" json:"hash_on"`
+	HashHeader string         `mapstructure:"hash_header" bson:"hash_header" json:"hash_header"`
+}
+
+type loadBalancingRawData struct {
+	LoadBalancing LoadBalancingConfig `mapstructure:"load_balancing"`
+}
+
+// getLoadBalancingConfig decodes the load balancing options from the raw API definition
+func getLoadBalancingConfig(rawData map[string]interface{}) LoadBalancingConfig {
+	var thisConfig loadBalancingRawData
+	if !decodeRawConfig(rawData, "load balancing options", &thisConfig) {
+		return LoadBalancingConfig{Strategy: LBRoundRobin}
+	}
+
+	if thisConfig.LoadBalancing.Strategy == "" {
+		thisConfig.LoadBalancing.Strategy = LBRoundRobin
+	}
+	if thisConfig.LoadBalancing.HashOn == "" {
+		thisConfig.LoadBalancing.HashOn = LBHashOnIP
+	}
+
+	return thisConfig.LoadBalancing
+}
+
+// LoadBalancer picks an upstream target for a request. Targets in excluded have failed their uptime
+// tests and should be avoided, Done is called with the picked target once the request has finished
+type LoadBalancer interface {
+	Next(targets []string, r *http.Request, excluded map[string]bool) string
+	Done(target string)
+}
+
+// NewLoadBalancer creates the balancer for the configured strategy, unknown strategies fall back to round robin
+func NewLoadBalancer(spec *APISpec) LoadBalancer {
+	switch spec.LoadBalancing.Strategy {
+	case LBWeightedRoundRobin:
+		return &WeightedBalancer{weights: spec.LoadBalancing.Weights, current: make(map[string]int)}
+	case LBLeastConnections:
+		return &LeastConnectionsBalancer{outstanding: make(map[string]int)}
+	case LBConsistentHash:
+		return &ConsistentHashBalancer{config: spec.LoadBalancing}
+	case LBRoundRobin:
+	default:
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+			"api_id": spec.APIID,
+		}).Warning("Unknown load balancing strategy, using round robin: ", spec.LoadBalancing.Strategy)
+	}
+
+	return &RoundRobinBalancer{rr: spec.RoundRobin}
+}
+
+// RoundRobinBalancer hands out the targets in turn
+type RoundRobinBalancer struct {
+	mu sync.Mutex
+	rr *RoundRobin
+}
+
+func (b *RoundRobinBalancer) Next(targets []string, r *http.Request, excluded map[string]bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.rr.SetMax(&targets)
+	target := targets[b.rr.GetPos()]
+	for i := 1; i < len(targets) && excluded[target]; i++ {
+		target = targets[b.rr.GetPos()]
+	}
+
+	return target
+}
+
+func (b *RoundRobinBalancer) Done(target string) {}
+
+// WeightedBalancer is a smooth weighted round robin, targets without a weight count as 1 and a weight of 0
+// takes a target out of rotation
+type WeightedBalancer struct {
+	mu      sync.Mutex
+	weights map[string]int
+	current map[string]int
+}
+
+func (b *WeightedBalancer) weight(target string) int {
+	if weight, found := b.weights[target]; found {
+		return weight
+	}
+	return 1
+}
+
+func (b *WeightedBalancer) Next(targets []string, r *http.Request, excluded map[string]bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var best string
+	var total int
+	for _, target := range targets {
+		weight := b.weight(target)
+		if excluded[target] || weight <= 0 {
+			continue
+		}
+
+		b.current[target] += weight
+		total += weight
+		if best == "" || b.current[target] > b.current[best] {
+			best = target
+		}
+	}
+
+	if best == "" {
+		return targets[0]
+	}
+
+	b.current[best] -= total
+	return best
+}
+
+func (b *WeightedBalancer) Done(target string) {}
+
+// LeastConnectionsBalancer sends requests to the target with the fewest requests in flight
+type LeastConnectionsBalancer struct {
+	mu          sync.Mutex
+	outstanding map[string]int
+	offset      int
+}
+
+func (b *LeastConnectionsBalancer) Next(targets []string, r *http.Request, excluded map[string]bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// Start at a different target each time so that ties are spread out
+	b.offset = (b.offset + 1) % len(targets)
+
+	best := targets[b.offset]
+	bestCount := -1
+	for i := range targets {
+		target := targets[(b.offset+i)%len(targets)]
+		if excluded[target] {
+			continue
+		}
+		if count := b.outstanding[target]; bestCount < 0 || count < bestCount {
+			best = target
+			bestCount = count
+		}
+	}
+
+	b.outstanding[best]++
+	return best
+}
+
+func (b *LeastConnectionsBalancer) Done(target string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.outstanding[target]--
+	if b.outstanding[target] <= 0 {
+		delete(b.outstanding, target)
+	}
+}
+
+// ConsistentHashBalancer maps a request key onto a hash ring so the same client keeps hitting the same
+// target, and only the clients of a target move if it is removed
+type ConsistentHashBalancer struct {
+	mu      sync.Mutex
+	config  LoadBalancingConfig
+	ringFor string
+	ring    []uint32
+	owners  map[uint32]string
+}
+
+// hashKey finds the value to hash for a request, the client IP is used if the key is missing
+func (b *ConsistentHashBalancer) hashKey(r *http.Request) string {
+	var key string
+	switch b.config.HashOn {
+	case LBHashOnAPIKey:
+		key, _ = context.Get(r, AuthHeaderValue).(string)
+	case LBHashOnHeader:
+		key = r.Header.Get(b.config.HashHeader)
+	}
+
+	if key == "" {
+		key = GetIPFromRequest(r)
+	}
+
+	return key
+}
+
+// buildRing recreates the ring when the target list changes (e.g. after a service discovery refresh)
+func (b *ConsistentHashBalancer) buildRing(targets []string) {
+	ringFor := strings.Join(targets, ",")
+	if ringFor == b.ringFor && b.ring != nil {
+		return
+	}
+
+	b.ring = make([]uint32, 0, len(targets)*LBHashReplicas)
+	b.owners = make(map[uint32]string)
+	for _, target := range targets {
+		for i := 0; i < LBHashReplicas; i++ {
+			point := crc32.ChecksumIEEE([]byte(target + "#" + strconv.Itoa(i)))
+			if _, taken := b.owners[point]; taken {
+				continue
+			}
+			b.owners[point] = target
+			b.ring = append(b.ring, point)
+		}
+	}
+
+	sort.Sort(uint32Slice(b.ring))
+	b.ringFor = ringFor
+}
+
+func (b *ConsistentHashBalancer) Next(targets []string, r *http.Request, excluded map[string]bool) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.buildRing(targets)
+
+	hash := crc32.ChecksumIEEE([]byte(b.hashKey(r)))
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i] >= hash })
+
+	// Walk the ring past any targets that are down
+	for i := 0; i < len(b.ring); i++ {
+		target := b.owners[b.ring[(start+i)%len(b.ring)]]
+		if !excluded[target] {
+			return target
+		}
+	}
+
+	return targets[0]
+}
+
+func (b *ConsistentHashBalancer) Done(target string) {}
+
+type uint32Slice []uint32
+
+func (s uint32Slice) Len() int           { return len(s) }
+func (s uint32Slice) Less(i, j int) bool { return s[i] < s[j] }
+func (s uint32Slice) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"github.com/gorilla/context"
+	"net/http"
+	"testing"
+)
+
+var lbTargets = []string{"http://upstream-1", "http://upstream-2", "http://upstream-3"}
+
+func TestLoadBalancingConfigDecoded(t *testing.T) {
+	thisConfig := getLoadBalancingConfig(map[string]interface{}{
+		"load_balancing": map[string]interface{}{
+			"strategy": "weighted_round_robin",
+			"weights":  map[string]interface{}{"http://upstream-1": 3},
+		},
+	})
+
+	if thisConfig.Strategy != LBWeightedRoundRobin || thisConfig.Weights["http://upstream-1"] != 3 {
+		t.Error("Load balancing options were not decoded: ", thisConfig)
+	}
+
+	if getLoadBalancingConfig(nil).Strategy != LBRoundRobin {
+		t.Error("Round robin should be the default")
+	}
+}
+
+func TestRoundRobinBalancerSkipsExcluded(t *testing.T) {
+	balancer := &RoundRobinBalancer{rr: &RoundRobin{}}
+	excluded := map[string]bool{"http://upstream-2": true}
+
+	for i := 0; i < 6; i++ {
+		if target := balancer.Next(lbTargets, nil, excluded); target == "http://upstream-2" {
+			t.Error("Excluded target was returned")
+		}
+	}
+}
+
+func TestWeightedBalancer(t *testing.T) {
+	balancer := &WeightedBalancer{
+		weights: map[string]int{"http://upstream-1": 3, "http://upstream-3": 0},
+		current: make(map[string]int),
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 8; i++ {
+		counts[balancer.Next(lbTargets, nil, nil)]++
+	}
+
+	if counts["http://upstream-1"] != 6 || counts["http://upstream-2"] != 2 || counts["http://upstream-3"] != 0 {
+		t.Error("Targets were not picked by weight: ", counts)
+	}
+}
+
+func TestLeastConnectionsBalancer(t *testing.T) {
+	balancer := &LeastConnectionsBalancer{outstanding: make(map[string]int)}
+
+	// One target per slot while all are busy
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		seen[balancer.Next(lbTargets, nil, nil)] = true
+	}
+	if len(seen) != 3 {
+		t.Error("Requests should be spread over idle targets: ", seen)
+	}
+
+	balancer.Done("http://upstream-2")
+	if target := balancer.Next(lbTargets, nil, nil); target != "http://upstream-2" {
+		t.Error("Expected the target with the fewest requests, got: ", target)
+	}
+}
+
+func TestConsistentHashBalancer(t *testing.T) {
+	balancer := &ConsistentHashBalancer{config: LoadBalancingConfig{HashOn: LBHashOnAPIKey}}
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	context.Set(req, AuthHeaderValue, "key-1")
+	defer context.Clear(req)
+
+	target := balancer.Next(lbTargets, req, nil)
+	for i := 0; i < 5; i++ {
+		if balancer.Next(lbTargets, req, nil) != target {
+			t.Fatal("The same key should always get the same target")
+		}
+	}
+
+	// Only the clients of a failing target should move
+	failover := balancer.Next(lbTargets, req, map[string]bool{target: true})
+	if failover == target {
+		t.Error("Excluded target was returned")
+	}
+
+	for i := 0; i < 50; i++ {
+		context.Set(req, AuthHeaderValue, "key-"+string(rune('a'+i)))
+		before := balancer.Next(lbTargets, req, nil)
+		if before == target {
+			continue
+		}
+		if after := balancer.Next(lbTargets, req, map[string]bool{target: true}); after != before {
+			t.Error("Key moved although its target is up: ", before, after)
+		}
+	}
+}
+
+func TestBalancersPickATargetWhenAllExcluded(t *testing.T) {
+	excluded := map[string]bool{"http://upstream-1": true, "http://upstream-2": true, "http://upstream-3": true}
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	balancers := []LoadBalancer{
+		&RoundRobinBalancer{rr: &RoundRobin{}},
+		&WeightedBalancer{current: make(map[string]int)},
+		&LeastConnectionsBalancer{outstanding: make(map[string]int)},
+		&ConsistentHashBalancer{},
+	}
+
+	// The proxy still sends the request somewhere when every target is down
+	for _, balancer := range balancers {
+		if target := balancer.Next(lbTargets, req, excluded); target == "" {
+			t.Errorf("%T returned no target", balancer)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	LocalRateLimitKeyPrefix  string = "rate-limit-local-"
+	LocalRateLimitNodePrefix string = "rate-limit-local-nodes."
+)
+
+// GlobalLocalRateLimiter is used instead of the redis rolling window when enable_local_rate_limiter is set
+var GlobalLocalRateLimiter *LocalRateLimiter
+
+// localWindow is a fixed window counter for a single rate limit key on this node
+type localWindow struct {
+	start    int64 // Unix time the window started
+	per      float64
+	local    int64 // Hits on this node in the window
+	unsynced int64 // Hits not yet pushed to redis
+	global   int64 // Total hits across all nodes as of the last sync
+}
+
+// LocalRateLimiter enforces rate limits from in-memory counters so requests don't have to wait on redis.
+// Every sync interval the counters are pushed to redis and the cluster-wide totals are read back, each
+// node also announces itself so the rate can be shared between the live nodes. This is approximate: a
+// cluster can go over the limit by up to one sync interval worth of requests.
+type LocalRateLimiter struct {
+	Store        StorageHandler
+	SyncInterval time.Duration
+
+	mu        sync.Mutex
+	windows   map[string]*localWindow
+	nodeCount int64
+	lastBeat  int64
+}
+
+// NewLocalRateLimiter creates a limiter that syncs with store every syncIntervalMs milliseconds
+func NewLocalRateLimiter(store StorageHandler, syncIntervalMs int) *LocalRateLimiter {
+	if syncIntervalMs <= 0 {
+		syncIntervalMs = 1000
+	}
+
+	return &LocalRateLimiter{
+		Store:        store,
+		SyncInterval: time.Duration(syncIntervalMs) * time.Millisecond,
+		windows:      make(map[string]*localWindow),
+		nodeCount:    1,
+	}
+}
+
+// InitLocalRateLimiter sets up the global limiter if it has been enabled in the config
+func InitLocalRateLimiter() {
+	if !config.EnableLocalRateLimiter {
+		return
+	}
+
+	store := GetGlobalStorageHandler("", false)
+	store.Connect()
+
+	log.WithFields(logrus.Fields{
+		"prefix": "main",
+	}).Info("Using local rate limiter, limits will be approximate")
+
+	GlobalLocalRateLimiter = NewLocalRateLimiter(store, config.LocalRateLimiterSyncInterval)
+	go GlobalLocalRateLimiter.StartSyncLoop()
+}
+
+// NodeCount returns the number of live nodes seen at the last sync
+func (l *LocalRateLimiter) NodeCount() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.nodeCount
+}
+
+// Allow records a hit against key and returns false if it is over rate requests per seconds
+func (l *LocalRateLimiter) Allow(key string, rate float64, per float64) bool {
+	return l.allow(key, rate, per, time.Now())
+}
+
+func (l *LocalRateLimiter) allow(key string, rate float64, per float64, now time.Time) bool {
+	if rate <= 0 || per <= 0 {
+		return false
+	}
+
+	windowStart := now.Unix() - (now.Unix() % int64(math.Max(per, 1)))
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	thisWindow, found := l.windows[key]
+	if !found || thisWindow.start != windowStart {
+		thisWindow = &localWindow{start: windowStart, per: per}
+		l.windows[key] = thisWindow
+	}
+
+	// Each node gets an equal share of the rate, the last known global count stops the
+	// cluster going over if the load isn't spread evenly
+	nodeShare := int64(math.Ceil(rate / float64(l.nodeCount)))
+	if thisWindow.local >= nodeShare || thisWindow.global+thisWindow.unsynced >= int64(rate) {
+		return false
+	}
+
+	thisWindow.local++
+	thisWindow.unsynced++
+	return true
+}
+
+// StartSyncLoop syncs the counters with redis every SyncInterval, it does not return
+func (l *LocalRateLimiter) StartSyncLoop() {
+	for {
+		time.Sleep(l.SyncInterval)
+		l.Sync()
+	}
+}
+
+// Sync pushes local hits to redis, reads back the cluster totals and updates the live node count
+func (l *LocalRateLimiter) Sync() {
+	l.sync(time.Now())
+}
+
+func (l *LocalRateLimiter) sync(now time.Time) {
+	type pendingSync struct {
+		key   string
+		start int64
+		per   float64
+		delta int64
+	}
+
+	// Take a snapshot so requests aren't blocked on redis
+	l.mu.Lock()
+	pending := make([]pendingSync, 0, len(l.windows))
+	for key, thisWindow := range l.windows {
+		if thisWindow.start+int64(thisWindow.per) <= now.Unix() {
+			delete(l.windows, key)
+			continue
+		}
+
+		pending = append(pending, pendingSync{key, thisWindow.start, thisWindow.per, thisWindow.unsynced})
+		thisWindow.unsynced = 0
+	}
+	l.mu.Unlock()
+
+	totals := make([]int64, len(pending))
+	for i, p := range pending {
+		redisKey := LocalRateLimitKeyPrefix + p.key + "." + strconv.FormatInt(p.start, 10)
+		totals[i] = l.Store.IncrementByWithExpire(redisKey, p.delta, int64(p.per)+1)
+	}
+
+	nodeCount := l.heartbeat(now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for i, p := range pending {
+		thisWindow, found := l.windows[p.key]
+		if found && thisWindow.start == p.start {
+			thisWindow.global = totals[i]
+		}
+	}
+
+	l.nodeCount = nodeCount
+}
+
+// heartbeat announces this node once per sync interval and returns the number of nodes that
+// announced themselves in the previous interval
+func (l *LocalRateLimiter) heartbeat(now time.Time) int64 {
+	intervalMs := int64(l.SyncInterval / time.Millisecond)
+	if intervalMs <= 0 {
+		intervalMs = 1
+	}
+
+	slot := (now.UnixNano() / int64(time.Millisecond)) / intervalMs
+	expire := (2*intervalMs)/1000 + 1
+
+	l.mu.Lock()
+	beat := slot != l.lastBeat
+	l.lastBeat = slot
+	nodeCount := l.nodeCount
+	l.mu.Unlock()
+
+	if beat {
+		l.Store.IncrementByWithExpire(LocalRateLimitNodePrefix+strconv.FormatInt(slot, 10), 1, expire)
+	}
+
+	previous, err := l.Store.GetRawKey(LocalRateLimitNodePrefix + strconv.FormatInt(slot-1, 10))
+	if err != nil {
+		// No nodes in the last interval, we must have just started
+		return nodeCount
+	}
+
+	if seen, convErr := strconv.ParseInt(previous, 10, 64); convErr == nil && seen > 0 {
+		return seen
+	}
+
+	return nodeCount
+}
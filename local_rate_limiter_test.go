@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func createLocalRateLimiterNodes(n int) ([]*LocalRateLimiter, *InMemoryStorageManager) {
+	store := &InMemoryStorageManager{db: newMemoryStore()}
+	nodes := make([]*LocalRateLimiter, n)
+	for i := range nodes {
+		nodes[i] = NewLocalRateLimiter(store, 1000)
+	}
+
+	return nodes, store
+}
+
+func syncLocalRateLimiterNodes(nodes []*LocalRateLimiter, now time.Time) {
+	for _, node := range nodes {
+		node.sync(now)
+	}
+}
+
+func TestLocalRateLimiterSingleNode(t *testing.T) {
+	nodes, _ := createLocalRateLimiterNodes(1)
+	now := time.Unix(6000, 0)
+
+	for i := 0; i < 10; i++ {
+		if !nodes[0].allow("key", 10, 60, now) {
+			t.Fatal("Request should be allowed: ", i)
+		}
+	}
+
+	if nodes[0].allow("key", 10, 60, now) {
+		t.Error("Request should be over the limit")
+	}
+
+	if !nodes[0].allow("other-key", 10, 60, now) {
+		t.Error("Keys should be limited separately")
+	}
+
+	// The next window starts from zero
+	if !nodes[0].allow("key", 10, 60, now.Add(60*time.Second)) {
+		t.Error("Request in the next window should be allowed")
+	}
+}
+
+func TestLocalRateLimiterSharesRateBetweenNodes(t *testing.T) {
+	nodes, _ := createLocalRateLimiterNodes(2)
+	now := time.Unix(6000, 0)
+
+	// Two heartbeats are needed before the nodes see each other
+	syncLocalRateLimiterNodes(nodes, now)
+	syncLocalRateLimiterNodes(nodes, now.Add(time.Second))
+
+	for _, node := range nodes {
+		if node.NodeCount() != 2 {
+			t.Fatal("Expected 2 live nodes, got: ", node.NodeCount())
+		}
+	}
+
+	now = now.Add(2 * time.Second)
+	for i, node := range nodes {
+		allowed := 0
+		for j := 0; j < 10; j++ {
+			if node.allow("key", 10, 60, now) {
+				allowed++
+			}
+		}
+
+		if allowed != 5 {
+			t.Error("Each node should allow half the rate, node ", i, " allowed: ", allowed)
+		}
+	}
+}
+
+func TestLocalRateLimiterReconcilesGlobalCount(t *testing.T) {
+	nodes, _ := createLocalRateLimiterNodes(2)
+	now := time.Unix(6000, 0)
+
+	// Neither node knows about the other yet, so the first node can use the whole rate
+	for i := 0; i < 10; i++ {
+		if !nodes[0].allow("key", 10, 60, now) {
+			t.Fatal("Request should be allowed: ", i)
+		}
+	}
+
+	if !nodes[1].allow("key", 10, 60, now) {
+		t.Fatal("Second node shouldn't know about the first node's hits before a sync")
+	}
+
+	syncLocalRateLimiterNodes(nodes, now.Add(time.Second))
+
+	if nodes[1].allow("key", 10, 60, now.Add(time.Second)) {
+		t.Error("Second node should block once it has synced the global count")
+	}
+}
+
+func TestLocalRateLimiterDropsExpiredWindows(t *testing.T) {
+	nodes, _ := createLocalRateLimiterNodes(1)
+	now := time.Unix(6000, 0)
+
+	nodes[0].allow("key", 10, 60, now)
+	nodes[0].sync(now.Add(60 * time.Second))
+
+	if len(nodes[0].windows) != 0 {
+		t.Error("Expired windows should be removed on sync")
+	}
+}
@@ -91,14 +91,17 @@ func setupGlobals() {
 		defaultRouter = mainRouter
 	}
 
-	if (config.EnableAnalytics == true) && (config.Storage.Type != "redis") {
+	if (config.EnableAnalytics == true) && AnalyticsSinkNeedsRedis() && (config.Storage.Type != "redis") {
 		log.WithFields(logrus.Fields{
 			"prefix": "main",
 		}).Panic("Analytics requires Redis Storage backend, please enable Redis in the tyk.conf file.")
 	}
 
+	// Find the redis master before any connection pools are created
+	InitRedisSentinel()
+
 	// Initialise our Host Checker
-	HealthCheckStore := &RedisClusterStorageManager{KeyPrefix: "host-checker:"}
+	HealthCheckStore := GetClusterStorageHandler("host-checker:", false)
 	InitHostCheckManager(HealthCheckStore)
 
 	if config.EnableAnalytics {
@@ -108,12 +111,42 @@ func setupGlobals() {
 			"prefix": "main",
 		}).Debug("Setting up analytics DB connection")
 
-		analytics = RedisAnalyticsHandler{
-			Store: &AnalyticsStore,
+		analytics = RedisAnalyticsHandler{}
+		if AnalyticsSinkNeedsRedis() {
+			analytics.Store = &AnalyticsStore
 		}
 
 		analytics.Init()
 
+		analytics.Buffer = NewAnalyticsBuffer(GetAnalyticsSink(analytics.Store),
+			config.AnalyticsConfig.BufferSize,
+			config.AnalyticsConfig.BatchSize,
+			config.AnalyticsConfig.FlushInterval)
+
+		// Batches the sink can't take are pushed to the pump's Redis list, which is only there with Redis storage
+		canFallBack := AnalyticsCanFallBackToRedis()
+		if canFallBack {
+			AnalyticsStore.Connect()
+			analytics.Buffer.Fallback = &RedisListAnalyticsSink{Store: &AnalyticsStore}
+		}
+
+		if bufErr := analytics.Buffer.Init(); bufErr != nil {
+			if !canFallBack {
+				log.WithFields(logrus.Fields{
+					"prefix": "main",
+				}).Fatal("Failed to initialise analytics sink: ", bufErr)
+			}
+
+			log.WithFields(logrus.Fields{
+				"prefix": "main",
+			}).Error("Failed to initialise analytics sink, falling back to the Redis analytics list: ", bufErr)
+
+			analytics.Store = &AnalyticsStore
+			analytics.Buffer.Sink = analytics.Buffer.Fallback
+			analytics.Buffer.Fallback = nil
+			analytics.Buffer.Init()
+		}
+
 		if config.AnalyticsConfig.Type == "rpc" {
 			log.Debug("Using RPC cache purge")
 			thisPurger := RPCPurger{Store: &AnalyticsStore, Address: config.SlaveOptions.ConnectionString}
@@ -133,6 +166,12 @@ func setupGlobals() {
 	templateFile := fmt.Sprintf("%s/error.json", config.TemplatePath)
 	templates = template.Must(template.ParseFiles(templateFile))
 
+	// Set up tracing, spans are only created if this is enabled
+	InitTracer()
+
+	// Rate limits are enforced in-process and synced to redis if this is enabled
+	InitLocalRateLimiter()
+
 	// Set up global JSVM
 	if config.EnableJSVM {
 		GlobalEventsJSVM.Init(config.TykJSPath)
@@ -142,9 +181,14 @@ func setupGlobals() {
 	log.WithFields(logrus.Fields{
 		"prefix": "main",
 	}).Debug("Notifier will not work in hybrid mode")
-	MainNotifierStore := RedisClusterStorageManager{}
+	MainNotifierStore := GetClusterStorageHandler("", false)
 	MainNotifierStore.Connect()
-	MainNotifier = RedisNotifier{&MainNotifierStore, RedisPubSubChannel}
+	MainNotifier = RedisNotifier{MainNotifierStore, RedisPubSubChannel}
+
+	// Canary weights set through the API are shared by the cluster
+	CanaryWeightStore := GetClusterStorageHandler("canary-weights-", false)
+	CanaryWeightStore.Connect()
+	InitCanaryWeights(CanaryWeightStore)
 
 	if config.Monitor.EnableTriggerMonitors {
 		var monitorErr error
@@ -285,6 +329,7 @@ func loadAPIEndpoints(Muxer *mux.Router) {
 		ApiMuxer.HandleFunc("/tyk/keys/create", CheckIsAPIOwner(createKeyHandler))
 		ApiMuxer.HandleFunc("/tyk/apis/"+"{rest:.*}", CheckIsAPIOwner(apiHandler))
 		ApiMuxer.HandleFunc("/tyk/health/", CheckIsAPIOwner(healthCheckhandler))
+		ApiMuxer.HandleFunc("/tyk/canary/"+"{rest:.*}", CheckIsAPIOwner(canaryHandler))
 		ApiMuxer.HandleFunc("/tyk/oauth/clients/create", CheckIsAPIOwner(createOauthClient))
 		ApiMuxer.HandleFunc("/tyk/oauth/refresh/"+"{rest:.*}", CheckIsAPIOwner(invalidateOauthRefresh))
 	} else {
@@ -294,6 +339,7 @@ func loadAPIEndpoints(Muxer *mux.Router) {
 	}
 
 	ApiMuxer.HandleFunc("/tyk/keys/"+"{rest:.*}", CheckIsAPIOwner(keyHandler))
+	ApiMuxer.HandleFunc("/tyk/metrics", CheckIsAPIOwner(metricsHandler))
 	ApiMuxer.HandleFunc("/tyk/oauth/clients/"+"{rest:.*}", CheckIsAPIOwner(oAuthClientHandler))
 
 	log.WithFields(logrus.Fields{
@@ -310,6 +356,8 @@ func addOAuthHandlers(spec *APISpec, Muxer *mux.Router, test bool) *OAuthManager
 	apiAuthorizePath := spec.Proxy.ListenPath + "tyk/oauth/authorize-client/"
 	clientAuthPath := spec.Proxy.ListenPath + "oauth/authorize/"
 	clientAccessPath := spec.Proxy.ListenPath + "oauth/token/"
+	introspectionPath := spec.Proxy.ListenPath + "oauth/introspect/"
+	revocationPath := spec.Proxy.ListenPath + "oauth/revoke/"
 
 	serverConfig := osin.NewServerConfig()
 	serverConfig.ErrorStatusCode = 403
@@ -357,6 +405,8 @@ func addOAuthHandlers(spec *APISpec, Muxer *mux.Router, test bool) *OAuthManager
 	Muxer.HandleFunc(apiAuthorizePath, CheckIsAPIOwner(oauthHandlers.HandleGenerateAuthCodeData))
 	Muxer.HandleFunc(clientAuthPath, oauthHandlers.HandleAuthorizePassthrough)
 	Muxer.HandleFunc(clientAccessPath, oauthHandlers.HandleAccessRequest)
+	Muxer.HandleFunc(introspectionPath, oauthHandlers.HandleIntrospection)
+	Muxer.HandleFunc(revocationPath, oauthHandlers.HandleRevocation)
 
 	return &oauthManager
 }
@@ -542,9 +592,9 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 	var tempSpecRegister = make(map[string]*APISpec)
 
 	// Only create this once, add other types here as needed, seems wasteful but we can let the GC handle it
-	redisStore := RedisClusterStorageManager{KeyPrefix: "apikey-", HashKeys: config.HashKeys}
-	redisOrgStore := RedisClusterStorageManager{KeyPrefix: "orgkey."}
-	healthStore := &RedisClusterStorageManager{KeyPrefix: "apihealth."}
+	redisStore := GetClusterStorageHandler("apikey-", config.HashKeys)
+	redisOrgStore := GetClusterStorageHandler("orgkey.", false)
+	healthStore := GetClusterStorageHandler("apihealth.", false)
 	rpcAuthStore := RPCStorageHandler{KeyPrefix: "apikey-", HashKeys: config.HashKeys, UserKey: config.SlaveOptions.APIKey, Address: config.SlaveOptions.ConnectionString}
 	rpcOrgStore := RPCStorageHandler{KeyPrefix: "orgkey.", UserKey: config.SlaveOptions.APIKey, Address: config.SlaveOptions.ConnectionString}
 
@@ -555,7 +605,7 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 
 	listenPaths := make(map[string][]string)
 
-	FallbackKeySesionManager.Init(&redisStore)
+	FallbackKeySesionManager.Init(redisStore)
 
 	sort.Sort(SortableAPISpecListByHost(*APISpecs))
 	sort.Sort(SortableAPISpecListByListen(*APISpecs))
@@ -655,13 +705,13 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 
 			switch authStorageEngineToUse {
 			case DefaultStorageEngine:
-				authStore = &redisStore
-				orgStore = &redisOrgStore
+				authStore = redisStore
+				orgStore = redisOrgStore
 			case LDAPStorageEngine:
 				thisStorageEngine := LDAPStorageHandler{}
 				thisStorageEngine.LoadConfFromMeta(referenceSpec.AuthProvider.Meta)
 				authStore = &thisStorageEngine
-				orgStore = &redisOrgStore
+				orgStore = redisOrgStore
 			case RPCStorageEngine:
 				thisStorageEngine := &rpcAuthStore // &RPCStorageHandler{KeyPrefix: "apikey-", HashKeys: config.HashKeys, UserKey: config.SlaveOptions.APIKey, Address: config.SlaveOptions.ConnectionString}
 				authStore = thisStorageEngine
@@ -670,8 +720,8 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 				config.EnforceOrgQuotas = true
 
 			default:
-				authStore = &redisStore
-				orgStore = &redisOrgStore
+				authStore = redisStore
+				orgStore = redisOrgStore
 			}
 
 			SessionStorageEngineToUse := referenceSpec.SessionProvider.StorageEngine
@@ -681,12 +731,12 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 
 			switch SessionStorageEngineToUse {
 			case DefaultStorageEngine:
-				sessionStore = &redisStore
+				sessionStore = redisStore
 
 			case RPCStorageEngine:
 				sessionStore = &RPCStorageHandler{KeyPrefix: "apikey-", HashKeys: config.HashKeys, UserKey: config.SlaveOptions.APIKey, Address: config.SlaveOptions.ConnectionString}
 			default:
-				sessionStore = &redisStore
+				sessionStore = redisStore
 			}
 
 			// Health checkers are initialised per spec so that each API handler has it's own connection and redis sotorage pool
@@ -752,7 +802,7 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 			tykMiddleware := &TykMiddleware{referenceSpec, proxy}
 
 			keyPrefix := "cache-" + referenceSpec.APIDefinition.APIID
-			CacheStore := &RedisClusterStorageManager{KeyPrefix: keyPrefix}
+			CacheStore := GetClusterStorageHandler(keyPrefix, false)
 			CacheStore.Connect()
 
 			if referenceSpec.APIDefinition.UseKeylessAccess {
@@ -766,10 +816,12 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 
 				var baseChainArray = []alice.Constructor{
 					CreateMiddleware(&IPWhiteListMiddleware{TykMiddleware: tykMiddleware}, tykMiddleware),
+					CreateMiddleware(&APIRateLimit{tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&OrganizationMonitor{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&MiddlewareContextVars{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&VersionCheck{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&RequestSizeLimitMiddleware{tykMiddleware}, tykMiddleware),
+					CreateMiddleware(&EndpointRateLimit{tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&TransformMiddleware{tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&TransformHeaders{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&RedisCacheMiddleware{TykMiddleware: tykMiddleware, CacheStore: CacheStore}, tykMiddleware),
@@ -790,7 +842,8 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 					chainArray = append(chainArray, CreateDynamicMiddleware(obj.Name, false, obj.RequireSession, tykMiddleware))
 				}
 
-				// for KeyLessAccess we can't support rate limiting, versioning or access rules
+				// for KeyLessAccess we can't support key rate limiting, versioning or access rules, the API and
+				// endpoint limits still apply
 				chain := alice.New(chainArray...).Then(DummyProxyHandler{SH: SuccessHandler{tykMiddleware}})
 				log.WithFields(logrus.Fields{
 					"prefix": "main",
@@ -802,7 +855,25 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 				// Select the keying method to use for setting session states
 				var keyCheck func(http.Handler) http.Handler
 
-				if referenceSpec.APIDefinition.UseOauth2 {
+				if len(referenceSpec.AuthModes) > 0 {
+					// Several auth modes, tried in order
+					log.WithFields(logrus.Fields{
+						"prefix": "main",
+					}).Info("----> Checking security policy: ", strings.Join(referenceSpec.AuthModes, ", "))
+					keyCheck = CreateMiddleware(&MultiAuthMiddleware{TykMiddleware: tykMiddleware}, tykMiddleware)
+				} else if referenceSpec.ClientCertificates.UseClientCertificates {
+					// Mutual TLS
+					log.WithFields(logrus.Fields{
+						"prefix": "main",
+					}).Info("----> Checking security policy: Client Certificate")
+					keyCheck = CreateMiddleware(&ClientCertificateCheck{TykMiddleware: tykMiddleware}, tykMiddleware)
+				} else if referenceSpec.ForwardAuth.UseForwardAuth {
+					// External auth service
+					log.WithFields(logrus.Fields{
+						"prefix": "main",
+					}).Info("----> Checking security policy: Forward Auth")
+					keyCheck = CreateMiddleware(&ForwardAuth{TykMiddleware: tykMiddleware}, tykMiddleware)
+				} else if referenceSpec.APIDefinition.UseOauth2 {
 					// Oauth2
 					log.WithFields(logrus.Fields{
 						"prefix": "main",
@@ -849,6 +920,7 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 				handleCORS(&chainArray, referenceSpec)
 				var baseChainArray = []alice.Constructor{
 					CreateMiddleware(&IPWhiteListMiddleware{TykMiddleware: tykMiddleware}, tykMiddleware),
+					CreateMiddleware(&APIRateLimit{tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&OrganizationMonitor{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&VersionCheck{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&RequestSizeLimitMiddleware{tykMiddleware}, tykMiddleware),
@@ -857,6 +929,7 @@ func loadApps(APISpecs *[]*APISpec, Muxer *mux.Router) {
 					CreateMiddleware(&AccessRightsCheck{tykMiddleware}, tykMiddleware),
 					//CreateMiddleware(&WebsockethandlerMiddleware{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&RateLimitAndQuotaCheck{tykMiddleware}, tykMiddleware),
+					CreateMiddleware(&EndpointRateLimit{tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&GranularAccessMiddleware{tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&MiddlewareContextVars{TykMiddleware: tykMiddleware}, tykMiddleware),
 					CreateMiddleware(&TransformMiddleware{tykMiddleware}, tykMiddleware),
@@ -1090,12 +1163,24 @@ func init() {
 
 	loadConfig(filename, &config)
 
-	if config.Storage.Type != "redis" {
+	if config.Storage.Type != "redis" && config.Storage.Type != string(MemoryStorageEngine) && config.Storage.Type != string(BoltStorageEngine) {
 		log.WithFields(logrus.Fields{
 			"prefix": "main",
 		}).Fatal("Redis connection details not set, please ensure that the storage type is set to Redis and that the connection parameters are correct.")
 	}
 
+	if config.Storage.Type == string(MemoryStorageEngine) {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+		}).Warning("Using in-memory storage, data will not be shared with other nodes or kept on restart")
+	}
+
+	if config.Storage.Type == string(BoltStorageEngine) {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+		}).Warning("Using embedded storage, data will not be shared with other nodes")
+	}
+
 	setupGlobals()
 
 	port, _ := arguments["--port"]
@@ -1170,11 +1255,28 @@ func StartRPCKeepaliveWatcher(engine *RPCStorageHandler) {
 	}()
 }
 
+// GetClusterStorageHandler returns the storage shared by the gateways in this cluster, this is redis
+// unless the storage type is set to memory or bolt for a single node
+func GetClusterStorageHandler(KeyPrefix string, hashKeys bool) ClusterStorageHandler {
+	switch tykcommon.StorageEngineCode(config.Storage.Type) {
+	case MemoryStorageEngine:
+		return &InMemoryStorageManager{KeyPrefix: KeyPrefix, HashKeys: hashKeys}
+	case BoltStorageEngine:
+		return &BoltStorageManager{KeyPrefix: KeyPrefix, HashKeys: hashKeys}
+	}
+
+	return &RedisClusterStorageManager{KeyPrefix: KeyPrefix, HashKeys: hashKeys}
+}
+
 func GetGlobalStorageHandler(KeyPrefix string, hashKeys bool) StorageHandler {
 	var Name tykcommon.StorageEngineCode
 	// Select configuration options
 	if config.SlaveOptions.UseRPC {
 		Name = RPCStorageEngine
+	} else if config.Storage.Type == string(MemoryStorageEngine) {
+		Name = MemoryStorageEngine
+	} else if config.Storage.Type == string(BoltStorageEngine) {
+		Name = BoltStorageEngine
 	} else {
 		Name = DefaultStorageEngine
 	}
@@ -1182,6 +1284,10 @@ func GetGlobalStorageHandler(KeyPrefix string, hashKeys bool) StorageHandler {
 	switch Name {
 	case DefaultStorageEngine:
 		return &RedisClusterStorageManager{KeyPrefix: KeyPrefix, HashKeys: hashKeys}
+	case MemoryStorageEngine:
+		return &InMemoryStorageManager{KeyPrefix: KeyPrefix, HashKeys: hashKeys}
+	case BoltStorageEngine:
+		return &BoltStorageManager{KeyPrefix: KeyPrefix, HashKeys: hashKeys}
 	case RPCStorageEngine:
 		engine := &RPCStorageHandler{KeyPrefix: KeyPrefix, HashKeys: hashKeys, UserKey: config.SlaveOptions.APIKey, Address: config.SlaveOptions.ConnectionString}
 		return engine
@@ -1282,11 +1388,18 @@ func listen() {
 				certNameMap[certData.Name] = &certs[i]
 			}
 
+			// Certificates are verified per API against its own CA bundle, so only ask for them here
+			clientAuth := tls.NoClientCert
+			if config.HttpServerOptions.RequestClientCertificates {
+				clientAuth = tls.RequestClientCert
+			}
+
 			config := tls.Config{
 				Certificates:      certs,
 				NameToCertificate: certNameMap,
 				ServerName:        config.HttpServerOptions.ServerName,
 				MinVersion:        config.HttpServerOptions.MinVersion,
+				ClientAuth:        clientAuth,
 			}
 			l, err = tls.Listen("tcp", targetPort, &config)
 		} else {
@@ -1423,5 +1536,10 @@ func listen() {
 			"prefix": "main",
 		}).Fatalln(err)
 	}
+
+	// Write out the analytics records that are still buffered
+	if analytics.Buffer != nil {
+		analytics.Buffer.Stop()
+	}
 	//time.Sleep(1e9)
 }
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"strings"
+	"sync"
+	"time"
+)
+
+// memoryStore is the shared backend for all InMemoryStorageManager instances, it plays the role of the
+// redis server so the key prefixes of different managers don't collide
+type memoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*storageEntry
+	pubSub  *localPubSub
+}
+
+var memoryStoreSingleton *memoryStore
+var memoryStoreOnce sync.Once
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		entries: make(map[string]*storageEntry),
+		pubSub:  newLocalPubSub(),
+	}
+}
+
+func getMemoryStore() *memoryStore {
+	memoryStoreOnce.Do(func() {
+		memoryStoreSingleton = newMemoryStore()
+		go memoryStoreSingleton.startExpiryLoop(time.Minute)
+	})
+
+	return memoryStoreSingleton
+}
+
+// startExpiryLoop removes expired keys, they are also removed when read so this just stops
+// keys that are never read again from using up memory
+func (m *memoryStore) startExpiryLoop(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		m.removeExpired(time.Now())
+	}
+}
+
+func (m *memoryStore) removeExpired(now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, entry := range m.entries {
+		if entry.expired(now) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// get returns a live entry, the lock must be held
+func (m *memoryStore) get(key string) *storageEntry {
+	entry, found := m.entries[key]
+	if !found {
+		return nil
+	}
+
+	if entry.expired(time.Now()) {
+		delete(m.entries, key)
+		return nil
+	}
+
+	return entry
+}
+
+// getOrCreate returns a live entry, creating it if needed, the lock must be held
+func (m *memoryStore) getOrCreate(key string) *storageEntry {
+	entry := m.get(key)
+	if entry == nil {
+		entry = &storageEntry{}
+		m.entries[key] = entry
+	}
+
+	return entry
+}
+
+// InMemoryStorageManager implements the StorageHandler interface without redis, all managers in the
+// process share the same data, so it can only be used for a single node or for testing
+type InMemoryStorageManager struct {
+	KeyPrefix string
+	HashKeys  bool
+	db        *memoryStore
+}
+
+// Connect attaches the manager to the shared in-memory store
+func (s *InMemoryStorageManager) Connect() bool {
+	if s.db == nil {
+		s.db = getMemoryStore()
+	}
+
+	return true
+}
+
+func (s *InMemoryStorageManager) store() *memoryStore {
+	if s.db == nil {
+		s.Connect()
+	}
+
+	return s.db
+}
+
+func (s *InMemoryStorageManager) hashKey(in string) string {
+	if !s.HashKeys {
+		// Not hashing? Return the raw key
+		return in
+	}
+	return doHash(in)
+}
+
+func (s *InMemoryStorageManager) fixKey(keyName string) string {
+	return s.KeyPrefix + s.hashKey(keyName)
+}
+
+func (s *InMemoryStorageManager) cleanKey(keyName string) string {
+	return strings.Replace(keyName, s.KeyPrefix, "", 1)
+}
+
+// GetKey will retreive a key from the database
+func (s *InMemoryStorageManager) GetKey(keyName string) (string, error) {
+	return s.GetRawKey(s.fixKey(keyName))
+}
+
+func (s *InMemoryStorageManager) GetRawKey(keyName string) (string, error) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := db.get(keyName)
+	if entry == nil || !entry.isString() {
+		return "", KeyError{}
+	}
+
+	return entry.Value, nil
+}
+
+// GetExp returns the TTL of a key in seconds, -1 if it has no expiry and -2 if it does not exist
+func (s *InMemoryStorageManager) GetExp(keyName string) (int64, error) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := db.get(s.fixKey(keyName))
+	if entry == nil {
+		return -2, nil
+	}
+
+	return entry.ttl(), nil
+}
+
+// SetKey will create (or update) a key value in the store
+func (s *InMemoryStorageManager) SetKey(keyName string, sessionState string, timeout int64) error {
+	return s.SetRawKey(s.fixKey(keyName), sessionState, timeout)
+}
+
+func (s *InMemoryStorageManager) SetRawKey(keyName string, sessionState string, timeout int64) error {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := &storageEntry{Value: sessionState}
+	if timeout > 0 {
+		entry.expireIn(timeout)
+	}
+
+	db.entries[keyName] = entry
+	return nil
+}
+
+// Decrement will decrement a key
+func (s *InMemoryStorageManager) Decrement(keyName string) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.getOrCreate(s.fixKey(keyName)).incrementBy(-1)
+}
+
+// IncrememntWithExpire will increment a raw key, setting the expiry if the key is new
+func (s *InMemoryStorageManager) IncrememntWithExpire(keyName string, expire int64) int64 {
+	return s.IncrementByWithExpire(keyName, 1, expire)
+}
+
+// IncrementByWithExpire adds n to a raw counter key, setting the expiry if the key is new
+func (s *InMemoryStorageManager) IncrementByWithExpire(keyName string, n int64, expire int64) int64 {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := db.getOrCreate(keyName)
+	val := entry.incrementBy(n)
+	if val == n {
+		entry.expireIn(expire)
+	}
+
+	return val
+}
+
+func (s *InMemoryStorageManager) filterKeys(prefix string) map[string]string {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	values := make(map[string]string)
+	for key := range db.entries {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		entry := db.get(key)
+		if entry != nil {
+			values[s.cleanKey(key)] = entry.Value
+		}
+	}
+
+	return values
+}
+
+// GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
+func (s *InMemoryStorageManager) GetKeys(filter string) []string {
+	values := s.filterKeys(s.KeyPrefix + s.hashKey(filter))
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+// GetKeysAndValuesWithFilter will return all keys and their values with a filter
+func (s *InMemoryStorageManager) GetKeysAndValuesWithFilter(filter string) map[string]string {
+	return s.filterKeys(s.KeyPrefix + s.hashKey(filter))
+}
+
+// GetKeysAndValues will return all keys and their values - not to be used lightly
+func (s *InMemoryStorageManager) GetKeysAndValues() map[string]string {
+	return s.filterKeys(s.KeyPrefix)
+}
+
+// DeleteKey will remove a key from the database
+func (s *InMemoryStorageManager) DeleteKey(keyName string) bool {
+	return s.DeleteRawKey(s.fixKey(keyName))
+}
+
+// DeleteRawKey will remove a key from the database without prefixing, assumes user knows what they are doing
+func (s *InMemoryStorageManager) DeleteRawKey(keyName string) bool {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	delete(db.entries, keyName)
+	return true
+}
+
+// DeleteKeys will remove a group of keys in bulk
+func (s *InMemoryStorageManager) DeleteKeys(keys []string) bool {
+	for _, key := range keys {
+		s.DeleteKey(key)
+	}
+
+	return true
+}
+
+// DeleteRawKeys will remove a group of keys in bulk without a prefix handler
+func (s *InMemoryStorageManager) DeleteRawKeys(keys []string, prefix string) bool {
+	for _, key := range keys {
+		s.DeleteRawKey(prefix + key)
+	}
+
+	return true
+}
+
+// StartPubSubHandler will listen for a signal and run the callback with the message, messages are
+// only delivered to subscribers in this process
+func (s *InMemoryStorageManager) StartPubSubHandler(channel string, callback func(redis.Message)) error {
+	for message := range s.store().pubSub.subscribe(channel) {
+		callback(message)
+	}
+
+	return errors.New("Connection closed.")
+}
+
+func (s *InMemoryStorageManager) Publish(channel string, message string) error {
+	s.store().pubSub.publish(channel, message)
+	return nil
+}
+
+// GetAndDeleteSet returns and removes all the values in a list
+func (s *InMemoryStorageManager) GetAndDeleteSet(keyName string) []interface{} {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	fixedKey := s.fixKey(keyName)
+	entry := db.get(fixedKey)
+	if entry == nil {
+		return []interface{}{}
+	}
+
+	delete(db.entries, fixedKey)
+	return entry.listValues()
+}
+
+func (s *InMemoryStorageManager) AppendToSet(keyName string, value string) {
+	s.AppendToSetMulti(keyName, []string{value})
+}
+
+// AppendToSetMulti pushes a batch of values onto a list
+func (s *InMemoryStorageManager) AppendToSetMulti(keyName string, values []string) error {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := db.getOrCreate(s.fixKey(keyName))
+	entry.List = append(entry.List, values...)
+	return nil
+}
+
+func (s *InMemoryStorageManager) GetSet(keyName string) (map[string]string, error) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := db.get(s.fixKey(keyName))
+	if entry == nil {
+		return map[string]string{}, nil
+	}
+
+	return entry.setMembers(), nil
+}
+
+func (s *InMemoryStorageManager) AddToSet(keyName string, value string) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	db.getOrCreate(s.fixKey(keyName)).addToSet(value)
+}
+
+func (s *InMemoryStorageManager) RemoveFromSet(keyName string, value string) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	entry := db.get(s.fixKey(keyName))
+	if entry != nil {
+		delete(entry.Set, value)
+	}
+}
+
+// SetRollingWindow will append to a sorted set and extract a timed window of values
+func (s *InMemoryStorageManager) SetRollingWindow(keyName string, per int64, value_override string) (int, []interface{}) {
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return db.getOrCreate(keyName).rollingWindow(per, value_override)
+}
+
+// SetRollingWindowPipeline is the same as SetRollingWindow, there is no round trip to save
+func (s *InMemoryStorageManager) SetRollingWindowPipeline(keyName string, per int64, value_override string) (int, []interface{}) {
+	return s.SetRollingWindow(keyName, per, value_override)
+}
+
+// SetRateLimit runs the token bucket or GCRA algorithms, these follow the redis scripts in rate_limit_algorithms.go
+func (s *InMemoryStorageManager) SetRateLimit(keyName string, algorithm string, rate float64, per float64, burst float64) (RateLimitResult, error) {
+	if err := checkRateLimitArgs(algorithm, rate, per); err != nil {
+		return RateLimitResult{}, err
+	}
+
+	db := s.store()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	now := time.Now()
+	reply := db.getOrCreate(keyName).rateLimit(algorithm, rate, per, burst, now)
+	return parseRateLimitReply(reply, nil, rateLimitBurst(rate, burst), now)
+}
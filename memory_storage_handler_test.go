@@ -0,0 +1,202 @@
+package main
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func createMemoryStorageManager(prefix string) *InMemoryStorageManager {
+	return &InMemoryStorageManager{KeyPrefix: prefix, db: newMemoryStore()}
+}
+
+// initMemorySpec sets up an API with key, health and org stores that share one in-memory database,
+// so that middleware tests don't need redis
+func initMemorySpec(spec *APISpec) {
+	store := createMemoryStorageManager("apikey-")
+	spec.Init(store, store, &InMemoryStorageManager{KeyPrefix: "apihealth.", db: store.db}, &InMemoryStorageManager{KeyPrefix: "orgKey.", db: store.db})
+}
+
+func TestMemoryStorageKeys(t *testing.T) {
+	store := createMemoryStorageManager("apikey-")
+	other := &InMemoryStorageManager{KeyPrefix: "orgkey.", db: store.db}
+
+	store.SetKey("1234", "session", 0)
+	other.SetKey("1234", "org", 0)
+
+	if val, err := store.GetKey("1234"); err != nil || val != "session" {
+		t.Error("Key not stored: ", val, err)
+	}
+
+	if val, _ := store.GetRawKey("orgkey.1234"); val != "org" {
+		t.Error("Prefixes should not collide, got: ", val)
+	}
+
+	if keys := store.GetKeys(""); len(keys) != 1 || keys[0] != "1234" {
+		t.Error("Expected prefix to be removed from keys: ", keys)
+	}
+
+	if exp, _ := store.GetExp("1234"); exp != -1 {
+		t.Error("Key without a timeout should not expire, got: ", exp)
+	}
+
+	store.DeleteKey("1234")
+	if _, err := store.GetKey("1234"); err == nil {
+		t.Error("Key should have been deleted")
+	}
+}
+
+func TestMemoryStorageExpiry(t *testing.T) {
+	store := createMemoryStorageManager("")
+	store.SetRawKey("expiring", "1", 1)
+
+	entry := store.db.entries["expiring"]
+	entry.Expires = time.Now().Add(-time.Second)
+
+	if _, err := store.GetRawKey("expiring"); err == nil {
+		t.Error("Expired key should not be returned")
+	}
+
+	store.SetRawKey("swept", "1", 1)
+	store.db.removeExpired(time.Now().Add(2 * time.Second))
+	if _, found := store.db.entries["swept"]; found {
+		t.Error("Expired key should have been swept")
+	}
+}
+
+func TestMemoryStorageCounters(t *testing.T) {
+	store := createMemoryStorageManager("")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			store.IncrememntWithExpire("counter", 60)
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if val, _ := store.GetRawKey("counter"); val != "50" {
+		t.Error("Concurrent increments were lost, got: ", val)
+	}
+
+	if exp, _ := store.GetExp("counter"); exp <= 0 || exp > 60 {
+		t.Error("Counter expiry should be set on first increment, got: ", exp)
+	}
+
+	store.Decrement("counter")
+	if val, _ := store.GetRawKey("counter"); val != "49" {
+		t.Error("Decrement failed, got: ", val)
+	}
+}
+
+func TestMemoryStorageSetsAndLists(t *testing.T) {
+	store := createMemoryStorageManager("")
+
+	store.AddToSet("set", "a")
+	store.AddToSet("set", "b")
+	store.AddToSet("set", "a")
+	store.RemoveFromSet("set", "b")
+
+	members, _ := store.GetSet("set")
+	if len(members) != 1 || members["0"] != "a" {
+		t.Error("Unexpected set members: ", members)
+	}
+
+	store.AppendToSet("list", "1")
+	store.AppendToSetMulti("list", []string{"2", "3"})
+
+	vals := store.GetAndDeleteSet("list")
+	if len(vals) != 3 || string(vals[2].([]byte)) != "3" {
+		t.Error("Unexpected list values: ", vals)
+	}
+
+	if len(store.GetAndDeleteSet("list")) != 0 {
+		t.Error("List should have been deleted")
+	}
+}
+
+func TestMemoryStorageRollingWindow(t *testing.T) {
+	store := createMemoryStorageManager("")
+
+	for i := 0; i < 5; i++ {
+		count, _ := store.SetRollingWindow("window", 60, "-1")
+		if count != i {
+			t.Error("Expected ", i, " previous hits, got: ", count)
+		}
+	}
+
+	// Age the window out
+	entry := store.db.entries["window"]
+	for i := range entry.ZSet {
+		entry.ZSet[i].Score = time.Now().Add(-2 * time.Minute).UnixNano()
+	}
+
+	count, _ := store.SetRollingWindow("window", 60, "-1")
+	if count != 0 {
+		t.Error("Old hits should have left the window, got: ", count)
+	}
+}
+
+func TestMemoryStorageRateLimit(t *testing.T) {
+	store := createMemoryStorageManager("")
+
+	for _, algorithm := range []string{RateLimitTokenBucket, RateLimitGCRA} {
+		for i := 0; i < 3; i++ {
+			result, err := store.SetRateLimit("limit."+algorithm, algorithm, 3, 60, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !result.Allowed {
+				t.Error(algorithm, " request should be allowed: ", i)
+			}
+		}
+
+		result, _ := store.SetRateLimit("limit."+algorithm, algorithm, 3, 60, 0)
+		if result.Allowed || result.RetryAfter <= 0 {
+			t.Error(algorithm, " request should be blocked with a retry time: ", result)
+		}
+	}
+
+	if _, err := store.SetRateLimit("limit", RateLimitRollingWindow, 3, 60, 0); err != ErrRateLimitAlgorithmNotSupported {
+		t.Error("Rolling window should be left to SetRollingWindow")
+	}
+
+	// The limit reported in the headers is the burst, as with redis
+	result, _ := store.SetRateLimit("burst", RateLimitTokenBucket, 3, 60, 10)
+	if result.Limit != 10 || result.Remaining != 9 {
+		t.Error("Limit should be the burst: ", result)
+	}
+}
+
+func TestMemoryStoragePubSub(t *testing.T) {
+	store := createMemoryStorageManager("")
+	received := make(chan redis.Message, 1)
+
+	go store.StartPubSubHandler("signals", func(message redis.Message) {
+		received <- message
+	})
+
+	// Wait for the subscription
+	for i := 0; i < 100; i++ {
+		if store.db.pubSub.hasSubscribers("signals") {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	store.Publish("signals", strconv.Itoa(42))
+
+	select {
+	case message := <-received:
+		if string(message.Data) != "42" || message.Channel != "signals" {
+			t.Error("Unexpected message: ", message)
+		}
+	case <-time.After(time.Second):
+		t.Error("Message was not delivered")
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Prometheus text exposition of gateway statistics, values are fed from the same points that
+// report into the API health checker so the two always agree.
+
+var defaultLatencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type metricSeries struct {
+	labels  []string
+	value   float64
+	buckets []uint64
+	count   uint64
+}
+
+type metricVec struct {
+	name       string
+	help       string
+	metricType string
+	labelNames []string
+	buckets    []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+func newMetricVec(metricType string, name string, help string, labelNames ...string) *metricVec {
+	return &metricVec{
+		name:       name,
+		help:       help,
+		metricType: metricType,
+		labelNames: labelNames,
+		series:     make(map[string]*metricSeries),
+	}
+}
+
+func newHistogramVec(name string, help string, buckets []float64, labelNames ...string) *metricVec {
+	m := newMetricVec("histogram", name, help, labelNames...)
+	m.buckets = buckets
+	return m
+}
+
+// get must be called with the lock held
+func (m *metricVec) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	thisSeries, found := m.series[key]
+	if !found {
+		thisSeries = &metricSeries{labels: labelValues}
+		if m.metricType == "histogram" {
+			thisSeries.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = thisSeries
+	}
+
+	return thisSeries
+}
+
+// Add increments a counter
+func (m *metricVec) Add(value float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value += value
+	m.mu.Unlock()
+}
+
+// Inc increments a counter by one
+func (m *metricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+// Set sets a gauge
+func (m *metricVec) Set(value float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value = value
+	m.mu.Unlock()
+}
+
+// Observe records a value in a histogram
+func (m *metricVec) Observe(value float64, labelValues ...string) {
+	m.mu.Lock()
+	thisSeries := m.get(labelValues)
+	for i, upperBound := range m.buckets {
+		if value <= upperBound {
+			thisSeries.buckets[i]++
+		}
+	}
+	thisSeries.value += value
+	thisSeries.count++
+	m.mu.Unlock()
+}
+
+func escapeLabelValue(value string) string {
+	value = strings.Replace(value, `\`, `\\`, -1)
+	value = strings.Replace(value, `"`, `\"`, -1)
+	return strings.Replace(value, "\n", `\n`, -1)
+}
+
+func (m *metricVec) formatLabels(labelValues []string, extraName string, extraValue string) string {
+	pairs := make([]string, 0, len(labelValues)+1)
+	for i, name := range m.labelNames {
+		pairs = append(pairs, name+`="`+escapeLabelValue(labelValues[i])+`"`)
+	}
+	if extraName != "" {
+		pairs = append(pairs, extraName+`="`+extraValue+`"`)
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// WriteTo renders the metric in the Prometheus text format
+func (m *metricVec) WriteTo(buf *bytes.Buffer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(buf, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(buf, "# TYPE %s %s\n", m.name, m.metricType)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		thisSeries := m.series[k]
+		if m.metricType != "histogram" {
+			fmt.Fprintf(buf, "%s%s %s\n", m.name, m.formatLabels(thisSeries.labels, "", ""), formatMetricValue(thisSeries.value))
+			continue
+		}
+
+		for i, upperBound := range m.buckets {
+			fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.formatLabels(thisSeries.labels, "le", formatMetricValue(upperBound)), thisSeries.buckets[i])
+		}
+		fmt.Fprintf(buf, "%s_bucket%s %d\n", m.name, m.formatLabels(thisSeries.labels, "le", "+Inf"), thisSeries.count)
+		fmt.Fprintf(buf, "%s_sum%s %s\n", m.name, m.formatLabels(thisSeries.labels, "", ""), formatMetricValue(thisSeries.value))
+		fmt.Fprintf(buf, "%s_count%s %d\n", m.name, m.formatLabels(thisSeries.labels, "", ""), thisSeries.count)
+	}
+}
+
+// TykMetrics holds all the metrics exposed by the gateway
+type TykMetrics struct {
+	Requests       *metricVec
+	RequestLatency *metricVec
+	Rejections     *metricVec
+	CircuitBreaker *metricVec
+	CacheHits      *metricVec
+	CacheMisses    *metricVec
+}
+
+func NewTykMetrics() *TykMetrics {
+	return &TykMetrics{
+		Requests: newMetricVec("counter", "tyk_http_requests_total",
+			"Requests handled by the gateway.", "api_id", "api_version", "response_code"),
+		RequestLatency: newHistogramVec("tyk_http_request_duration_seconds",
+			"Upstream request latency.", defaultLatencyBuckets, "api_id", "api_version"),
+		Rejections: newMetricVec("counter", "tyk_http_rejections_total",
+			"Requests rejected for rate limiting, quota or key failures.", "api_id", "reason"),
+		CircuitBreaker: newMetricVec("gauge", "tyk_circuit_breaker_open",
+			"Circuit breaker state, 1 when tripped.", "api_id", "path"),
+		CacheHits: newMetricVec("counter", "tyk_cache_hits_total",
+			"Requests served from the response cache.", "api_id"),
+		CacheMisses: newMetricVec("counter", "tyk_cache_misses_total",
+			"Cacheable requests that were not found in the response cache.", "api_id"),
+	}
+}
+
+var GatewayMetrics = NewTykMetrics()
+
+// RecordRequest counts a finished request, latency is in milliseconds and is only observed for
+// requests that reached the upstream
+func (t *TykMetrics) RecordRequest(spec *APISpec, version string, code int, latency int64, proxied bool) {
+	if version == "" {
+		version = "Non Versioned"
+	}
+
+	t.Requests.Inc(spec.APIID, version, strconv.Itoa(code))
+	if proxied {
+		t.RequestLatency.Observe(float64(latency)/1000, spec.APIID, version)
+	}
+}
+
+// RecordHealthValue maps a health check counter onto the rejection metric
+func (t *TykMetrics) RecordHealthValue(apiID string, counter HealthPrefix) {
+	var reason string
+	switch counter {
+	case Throttle:
+		reason = "rate_limit"
+	case QuotaViolation:
+		reason = "quota"
+	case KeyFailure:
+		reason = "key_failure"
+	default:
+		return
+	}
+
+	t.Rejections.Inc(apiID, reason)
+}
+
+// WriteTo renders all metrics in the Prometheus text format
+func (t *TykMetrics) WriteTo(buf *bytes.Buffer) {
+	for _, m := range []*metricVec{t.Requests, t.RequestLatency, t.Rejections, t.CircuitBreaker, t.CacheHits, t.CacheMisses} {
+		m.WriteTo(buf)
+	}
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		DoJSONWrite(w, 405, createError("Method not supported"))
+		return
+	}
+
+	var buf bytes.Buffer
+	GatewayMetrics.WriteTo(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.WriteHeader(200)
+	w.Write(buf.Bytes())
+}
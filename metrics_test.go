@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsExposition(t *testing.T) {
+	metrics := NewTykMetrics()
+	spec := &APISpec{}
+	spec.APIID = "metrics-api"
+
+	metrics.RecordRequest(spec, "v1", 200, 30, true)
+	metrics.RecordRequest(spec, "v1", 200, 3000, true)
+	metrics.RecordRequest(spec, "", 403, 0, false)
+	metrics.RecordHealthValue("metrics-api", Throttle)
+	metrics.RecordHealthValue("metrics-api", RequestLog)
+
+	var buf bytes.Buffer
+	metrics.WriteTo(&buf)
+	output := buf.String()
+
+	expected := []string{
+		`tyk_http_requests_total{api_id="metrics-api",api_version="v1",response_code="200"} 2`,
+		`tyk_http_requests_total{api_id="metrics-api",api_version="Non Versioned",response_code="403"} 1`,
+		`tyk_http_request_duration_seconds_bucket{api_id="metrics-api",api_version="v1",le="0.05"} 1`,
+		`tyk_http_request_duration_seconds_bucket{api_id="metrics-api",api_version="v1",le="+Inf"} 2`,
+		`tyk_http_request_duration_seconds_count{api_id="metrics-api",api_version="v1"} 2`,
+		`tyk_http_rejections_total{api_id="metrics-api",reason="rate_limit"} 1`,
+		`# TYPE tyk_circuit_breaker_open gauge`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(output, line) {
+			t.Error("Metrics output is missing line: ", line, "\n", output)
+		}
+	}
+
+	if strings.Contains(output, `reason=""`) {
+		t.Error("Request log values should not be counted as rejections")
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	req, err := http.NewRequest("GET", "/tyk/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metricsHandler(recorder, req)
+
+	if recorder.Code != 200 {
+		t.Error("Metrics endpoint should return 200, got: ", recorder.Code)
+	}
+
+	if !strings.Contains(recorder.Body.String(), "# TYPE tyk_http_requests_total counter") {
+		t.Error("Metrics endpoint did not return exposition format: ", recorder.Body.String())
+	}
+}
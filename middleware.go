@@ -35,19 +35,34 @@ func CreateMiddleware(mw TykMiddlewareImplementation, tykMwSuper *TykMiddleware)
 		//handler.HandleError(w, r, confErr.Error(), 403)
 	}
 
+	spanName := middlewareSpanName(mw)
+
 	aliceHandler := func(h http.Handler) http.Handler {
 		thisHandler := func(w http.ResponseWriter, r *http.Request) {
 
 			if (tykMwSuper.Spec.CORS.OptionsPassthrough) && (r.Method == "OPTIONS") {
 				h.ServeHTTP(w, r)
 			} else {
+				// The first middleware in the chain owns the request span
+				requestSpan, ownsSpan := StartRequestSpan(r, tykMwSuper.Spec)
+				if ownsSpan {
+					defer requestSpan.Finish()
+				}
+
+				mwSpan := StartChildSpan(r, spanName, SpanKindInternal)
 				reqErr, errCode := mw.ProcessRequest(w, r, thisMwConfiguration)
 				if reqErr != nil {
+					mwSpan.SetError(errCode, reqErr.Error())
+					mwSpan.Finish()
+					requestSpan.SetError(errCode, reqErr.Error())
+
 					handler := ErrorHandler{tykMwSuper}
 					handler.HandleError(w, r, reqErr.Error(), errCode)
 					return
 				}
 
+				mwSpan.Finish()
+
 				// Special code, stops execution
 				if errCode == 1666 {
 					// Stop
@@ -3,10 +3,13 @@ package main
 import (
 	"crypto/hmac"
 	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/base64"
 	"errors"
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/context"
+	"hash"
 	"math"
 	"net/http"
 	"net/url"
@@ -18,6 +21,43 @@ const DateHeaderSpec string = "Date"
 const AltHeaderSpec string = "x-aux-date"
 const HMACClockSkewLimitInMs float64 = 1000
 
+// Nonces are kept for this long if the API does not check clock skew
+const HMACNonceDefaultTTL int64 = 300
+const HMACNonceKeyPrefix string = "hmac-nonce-"
+
+const (
+	HMACSHA1   string = "hmac-sha1"
+	HMACSHA256 string = "hmac-sha256"
+	HMACSHA512 string = "hmac-sha512"
+)
+
+var hmacAlgorithms map[string]func() hash.Hash = map[string]func() hash.Hash{
+	HMACSHA1:   sha1.New,
+	HMACSHA256: sha256.New,
+	HMACSHA512: sha512.New,
+}
+
+// HMACConfig restricts the signature algorithms an API accepts, all supported algorithms are allowed if
+// the list is empty. If a nonce header is set it must be signed and can only be used once per key
+type HMACConfig struct {
+	AllowedAlgorithms []string `mapstructure:"hmac_allowed_algorithms" bson:"hmac_allowed_algorithms" json:"hmac_allowed_algorithms"`
+	NonceHeader       string   `mapstructure:"hmac_nonce_header" bson:"hmac_nonce_header" json:"hmac_nonce_header"`
+}
+
+// getHMACConfig decodes the HMAC options from the raw API definition
+func getHMACConfig(rawData map[string]interface{}) HMACConfig {
+	var thisConfig HMACConfig
+	if rawData == nil {
+		return thisConfig
+	}
+
+	if !decodeRawConfig(rawData, "HMAC options", &thisConfig) {
+		return HMACConfig{}
+	}
+
+	return thisConfig
+}
+
 // HMACMiddleware will check if the request has a signature, and if the request is allowed through
 type HMACMiddleware struct {
 	*TykMiddleware
@@ -64,6 +104,17 @@ func (hm *HMACMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request,
 		return hm.authorizationError(w, r)
 	}
 
+	// Make sure the algorithm is one we support and the API allows
+	algorithm, aErr := hm.checkAlgorithm(fieldValues.Algorthm)
+	if aErr != nil {
+		log.WithFields(logrus.Fields{
+			"prefix":    "hmac",
+			"error":     aErr,
+			"algorithm": fieldValues.Algorthm,
+		}).Error("Signature algorithm not allowed")
+		return hm.authorizationError(w, r)
+	}
+
 	// Get a session for the Key ID
 	thisSecret, thisSessionState, keyError := hm.getSecretAndSessionForKeyID(fieldValues.KeyID)
 	if keyError != nil {
@@ -76,10 +127,10 @@ func (hm *HMACMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request,
 	}
 
 	// Create a signed string with the secret
-	encodedSignature := generateEncodedSignature(signatureString, thisSecret)
+	encodedSignature := generateEncodedSignature(signatureString, thisSecret, algorithm)
 
 	// Compare
-	if encodedSignature != fieldValues.Signature {
+	if !hmac.Equal([]byte(encodedSignature), []byte(fieldValues.Signature)) {
 		log.WithFields(logrus.Fields{
 			"prefix":   "hmac",
 			"expected": encodedSignature,
@@ -97,6 +148,18 @@ func (hm *HMACMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request,
 		return hm.authorizationError(w, r)
 	}
 
+	// Check the nonce has not been seen, this is done last so unsigned requests can't use up nonces
+	if hm.Spec.HMAC.NonceHeader != "" {
+		if nErr := hm.checkNonce(r, fieldValues); nErr != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "hmac",
+				"error":  nErr,
+				"keyID":  fieldValues.KeyID,
+			}).Error("Nonce check failed")
+			return hm.authorizationError(w, r)
+		}
+	}
+
 	// Set session state on context, we will need it later
 	context.Set(r, SessionData, thisSessionState)
 	context.Set(r, AuthHeaderValue, fieldValues.KeyID)
@@ -116,6 +179,68 @@ func (hm *HMACMiddleware) authorizationError(w http.ResponseWriter, r *http.Requ
 	return errors.New("Authorization field missing, malformed or invalid"), 400
 }
 
+// checkAlgorithm returns the algorithm to use, requests without an algorithm use hmac-sha1
+func (hm *HMACMiddleware) checkAlgorithm(algorithm string) (string, error) {
+	algorithm = strings.ToLower(algorithm)
+	if algorithm == "" {
+		algorithm = HMACSHA1
+	}
+
+	if _, found := hmacAlgorithms[algorithm]; !found {
+		return "", errors.New("Algorithm is not supported")
+	}
+
+	allowed := hm.Spec.HMAC.AllowedAlgorithms
+	if len(allowed) == 0 {
+		return algorithm, nil
+	}
+
+	for _, thisAlgorithm := range allowed {
+		if strings.ToLower(thisAlgorithm) == algorithm {
+			return algorithm, nil
+		}
+	}
+
+	return "", errors.New("Algorithm is not allowed for this API")
+}
+
+// nonceTTL is long enough to cover any date that passes the clock skew check
+func (hm *HMACMiddleware) nonceTTL() int64 {
+	if hm.Spec.HmacAllowedClockSkew <= 0 {
+		return HMACNonceDefaultTTL
+	}
+
+	return int64(math.Ceil((hm.Spec.HmacAllowedClockSkew * 2) / 1000))
+}
+
+// checkNonce rejects the request if the nonce header is missing, not signed, or has been used before
+func (hm *HMACMiddleware) checkNonce(r *http.Request, fieldValues *HMACFieldValues) error {
+	nonceHeader := strings.ToLower(hm.Spec.HMAC.NonceHeader)
+	nonce := r.Header.Get(nonceHeader)
+	if nonce == "" {
+		return errors.New("Nonce header missing")
+	}
+
+	signed := false
+	for _, header := range fieldValues.Headers {
+		if strings.TrimSpace(strings.ToLower(header)) == nonceHeader {
+			signed = true
+			break
+		}
+	}
+
+	if !signed {
+		return errors.New("Nonce header must be signed")
+	}
+
+	nonceKey := HMACNonceKeyPrefix + hm.Spec.OrgID + "-" + publicHash(fieldValues.KeyID+"."+nonce)
+	if hm.Spec.SessionManager.GetStore().IncrememntWithExpire(nonceKey, hm.nonceTTL()) != 1 {
+		return errors.New("Nonce has already been used")
+	}
+
+	return nil
+}
+
 func (hm HMACMiddleware) checkClockSkew(dateHeaderValue string) bool {
 	// Reference layout for parsing time: "Mon Jan 2 15:04:05 MST 2006"
 
@@ -286,9 +411,14 @@ func generateHMACSignatureStringFromRequest(r *http.Request, fieldValues *HMACFi
 	return signatureString, nil
 }
 
-func generateEncodedSignature(signatureString string, secret string) string {
+func generateEncodedSignature(signatureString string, secret string, algorithm string) string {
+	hashFunc, found := hmacAlgorithms[algorithm]
+	if !found {
+		hashFunc = sha1.New
+	}
+
 	key := []byte(secret)
-	h := hmac.New(sha1.New, key)
+	h := hmac.New(hashFunc, key)
 	h.Write([]byte(signatureString))
 
 	encodedString := base64.StdEncoding.EncodeToString(h.Sum(nil))
@@ -296,15 +426,16 @@ func generateEncodedSignature(signatureString string, secret string) string {
 	return encodedString
 }
 
+// generateAuthHeaderValue creates a header that getFieldValues can read, this is used to sign upstream requests
 func generateAuthHeaderValue(fieldValues *HMACFieldValues) string {
 	authHeaderString := "Signature "
-	authHeaderString += "keyId=" + fieldValues.KeyID + ","
-	authHeaderString += "algorithm=" + fieldValues.Algorthm + ","
+	authHeaderString += "keyId=\"" + fieldValues.KeyID + "\","
+	authHeaderString += "algorithm=\"" + fieldValues.Algorthm + "\","
 	if len(fieldValues.Headers) > 0 {
 		headers := strings.Join(fieldValues.Headers, " ")
-		authHeaderString += "headers=" + headers
+		authHeaderString += "headers=\"" + headers + "\","
 	}
-	authHeaderString += ", signature=" + fieldValues.Signature
+	authHeaderString += "signature=\"" + fieldValues.Signature + "\""
 
 	return authHeaderString
 }
@@ -50,6 +50,15 @@ func (a *AccessRightsCheck) ProcessRequest(w http.ResponseWriter, r *http.Reques
 			// Not versioned, no point checking version access rights
 			found = true
 		} else {
+			// Keys only take part in the canary split between the versions they can use, the paths of
+			// the version the request is moved to are checked again
+			if canaryVersion := a.Spec.canaryVersionFor(r, versionList.Versions); canaryVersion != accessingVersion {
+				accessingVersion = canaryVersion
+				if requestValid, stat, _ := a.Spec.IsRequestValid(r); !requestValid {
+					return errors.New(string(stat)), 403
+				}
+			}
+
 			for _, vInfo := range versionList.Versions {
 				if vInfo == accessingVersion {
 					found = true
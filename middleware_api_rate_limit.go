@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"net/http"
+)
+
+// APILimit is a rolling window limit of Rate requests Per seconds
+type APILimit struct {
+	Rate float64 `mapstructure:"rate" bson:"rate" json:"rate"`
+	Per  float64 `mapstructure:"per" bson:"per" json:"per"`
+}
+
+// Enabled returns true if the limit has been configured
+func (l APILimit) Enabled() bool {
+	return l.Rate > 0 && l.Per > 0
+}
+
+// APIRateLimitConfig holds the rate limits that apply to an API regardless of the key being used, the
+// global limit is shared by all clients, the IP limit is applied per client IP address
+type APIRateLimitConfig struct {
+	GlobalRateLimit APILimit `mapstructure:"global_rate_limit" bson:"global_rate_limit" json:"global_rate_limit"`
+	IPRateLimit     APILimit `mapstructure:"ip_rate_limit" bson:"ip_rate_limit" json:"ip_rate_limit"`
+}
+
+// getAPIRateLimitConfig decodes the API level rate limits from the raw API definition
+func getAPIRateLimitConfig(rawData map[string]interface{}) APIRateLimitConfig {
+	var thisConfig APIRateLimitConfig
+	if rawData == nil {
+		return thisConfig
+	}
+
+	if !decodeRawConfig(rawData, "API rate limits", &thisConfig) {
+		return APIRateLimitConfig{}
+	}
+
+	return thisConfig
+}
+
+// APIRateLimit enforces the API-wide and per-IP rate limits, it does not need a session so
+// it can protect keyless APIs too
+type APIRateLimit struct {
+	*TykMiddleware
+}
+
+// New lets you do any initialisations for the object can be done here
+func (a *APIRateLimit) New() {}
+
+// GetConfig retrieves the configuration from the API config - we user mapstructure for this for simplicity
+func (a *APIRateLimit) GetConfig() (interface{}, error) {
+	return nil, nil
+}
+
+func (a *APIRateLimit) apiRateLimitKey() string {
+	return RateLimitKeyPrefix + "api-" + a.Spec.APIDefinition.APIID
+}
+
+func (a *APIRateLimit) ipRateLimitKey(ip string) string {
+	return RateLimitKeyPrefix + "api-" + a.Spec.APIDefinition.APIID + "-ip-" + ip
+}
+
+func (a *APIRateLimit) rateLimitExceeded(r *http.Request, message string) (error, int) {
+	log.WithFields(logrus.Fields{
+		"path":   r.URL.Path,
+		"origin": GetIPFromRequest(r),
+		"api_id": a.Spec.APIDefinition.APIID,
+	}).Info(message)
+
+	// Fire a rate limit exceeded event
+	go a.TykMiddleware.FireEvent(EVENT_RateLimitExceeded,
+		EVENT_RateLimitExceededMeta{
+			EventMetaDefault: EventMetaDefault{Message: message, OriginatingRequest: EncodeRequestToEvent(r)},
+			Path:             r.URL.Path,
+			Origin:           GetIPFromRequest(r),
+		})
+
+	// Report in health check
+	ReportHealthCheckValue(a.Spec.Health, Throttle, "-1")
+
+	return errors.New("Rate limit exceeded"), 429
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (a *APIRateLimit) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	limits := a.Spec.RateLimit
+	store := a.Spec.SessionManager.GetStore()
+
+	// Check the client limit first so a single noisy client doesn't use up the global allowance
+	if limits.IPRateLimit.Enabled() {
+		if isRollingWindowExceeded(a.ipRateLimitKey(GetIPFromRequest(r)), limits.IPRateLimit.Rate, limits.IPRateLimit.Per, store) {
+			return a.rateLimitExceeded(r, "Client IP rate limit exceeded.")
+		}
+	}
+
+	if limits.GlobalRateLimit.Enabled() {
+		if isRollingWindowExceeded(a.apiRateLimitKey(), limits.GlobalRateLimit.Rate, limits.GlobalRateLimit.Per, store) {
+			return a.rateLimitExceeded(r, "API rate limit exceeded.")
+		}
+	}
+
+	return nil, 200
+}
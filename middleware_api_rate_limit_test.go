@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+)
+
+var apiRateLimitDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"use_keyless": true,
+		"global_rate_limit": {
+			"rate": 1000,
+			"per": 1
+		},
+		"ip_rate_limit": {
+			"rate": 10,
+			"per": 60
+		},
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"version_data": {
+			"not_versioned": true,
+			"versions": {
+				"Default": {
+					"name": "Default",
+					"expires": "3000-01-02 15:04"
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://lonelycode.com",
+			"strip_listen_path": false
+		}
+	}
+
+`
+
+func TestAPIRateLimitConfig(t *testing.T) {
+	thisSpec := createDefinitionFromString(apiRateLimitDef)
+
+	if !thisSpec.RateLimit.GlobalRateLimit.Enabled() || thisSpec.RateLimit.GlobalRateLimit.Rate != 1000 {
+		t.Error("Global rate limit was not decoded: ", thisSpec.RateLimit.GlobalRateLimit)
+	}
+
+	if !thisSpec.RateLimit.IPRateLimit.Enabled() || thisSpec.RateLimit.IPRateLimit.Per != 60 {
+		t.Error("IP rate limit was not decoded: ", thisSpec.RateLimit.IPRateLimit)
+	}
+
+	noLimits := createDefinitionFromString(nonExpiringDef)
+	if noLimits.RateLimit.GlobalRateLimit.Enabled() || noLimits.RateLimit.IPRateLimit.Enabled() {
+		t.Error("Rate limits should be disabled when not set")
+	}
+}
+
+func TestAPIRateLimitKeys(t *testing.T) {
+	thisSpec := createDefinitionFromString(apiRateLimitDef)
+	mw := APIRateLimit{&TykMiddleware{&thisSpec, nil}}
+
+	if mw.ipRateLimitKey("127.0.0.1") == mw.ipRateLimitKey("127.0.0.2") {
+		t.Error("Each client IP should have its own rolling window")
+	}
+
+	if mw.ipRateLimitKey("127.0.0.1") == mw.apiRateLimitKey() {
+		t.Error("The IP and global limits should not share a rolling window")
+	}
+}
@@ -48,10 +48,11 @@ func CopyRequest(r *http.Request) *http.Request {
 	return tempRes
 }
 
-func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+// getAuthKeyFromRequest reads the key from the auth header, or the query param or cookie if the API allows them
+func getAuthKeyFromRequest(spec *APISpec, r *http.Request) string {
 	var tempRes *http.Request
 
-	thisConfig := k.TykMiddleware.Spec.APIDefinition.Auth
+	thisConfig := spec.APIDefinition.Auth
 
 	key := r.Header.Get(thisConfig.AuthHeaderName)
 
@@ -90,6 +91,12 @@ func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, configu
 		}
 	}
 
+	return key
+}
+
+func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	key := getAuthKeyFromRequest(k.TykMiddleware.Spec, r)
+
 	if key == "" {
 		// No header value, fail
 		log.WithFields(logrus.Fields{
@@ -116,7 +123,7 @@ func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, configu
 		AuthFailed(k.TykMiddleware, r, key)
 
 		// Report in health check
-		ReportHealthCheckValue(k.Spec.Health, KeyFailure, "1")
+		reportKeyFailure(k.Spec, r, "1")
 
 		return errors.New("Key not authorised"), 403
 	}
@@ -127,12 +134,33 @@ func (k *AuthKey) ProcessRequest(w http.ResponseWriter, r *http.Request, configu
 	return nil, 200
 }
 
+// reportKeyFailure counts a key failure in the health check, while MultiAuthMiddleware is trying each
+// auth mode it is left to report once if every mode fails
+func reportKeyFailure(spec *APISpec, r *http.Request, value string) {
+	if suppress, ok := context.Get(r, SuppressAuthFailure).(bool); ok && suppress {
+		return
+	}
+
+	ReportHealthCheckValue(spec.Health, KeyFailure, value)
+}
+
 func AuthFailed(m *TykMiddleware, r *http.Request, authHeaderValue string) {
+	AuthFailedWithReason(m, r, authHeaderValue, "")
+}
+
+// AuthFailedWithReason fires the auth failure event with the check that failed
+func AuthFailedWithReason(m *TykMiddleware, r *http.Request, authHeaderValue string, reason string) {
+	// Set while MultiAuthMiddleware is trying each auth mode
+	if suppress, ok := context.Get(r, SuppressAuthFailure).(bool); ok && suppress {
+		return
+	}
+
 	go m.FireEvent(EVENT_AuthFailure,
 		EVENT_AuthFailureMeta{
 			EventMetaDefault: EventMetaDefault{Message: "Auth Failure", OriginatingRequest: EncodeRequestToEvent(r)},
 			Path:             r.URL.Path,
 			Origin:           GetIPFromRequest(r),
 			Key:              authHeaderValue,
+			Reason:           reason,
 		})
 }
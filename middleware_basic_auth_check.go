@@ -92,7 +92,7 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 		AuthFailed(k.TykMiddleware, r, authHeaderValue)
 
 		// Report in health check
-		ReportHealthCheckValue(k.Spec.Health, KeyFailure, "-1")
+		reportKeyFailure(k.Spec, r, "-1")
 
 		return k.requestForBasicAuth(w, "User not authorised")
 	}
@@ -124,7 +124,7 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 		AuthFailed(k.TykMiddleware, r, authHeaderValue)
 
 		// Report in health check
-		ReportHealthCheckValue(k.Spec.Health, KeyFailure, "-1")
+		reportKeyFailure(k.Spec, r, "-1")
 
 		return k.requestForBasicAuth(w, "User not authorised")
 	}
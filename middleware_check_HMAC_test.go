@@ -421,3 +421,92 @@ func TestHMACAuthSessionPassWithHeaderField(t *testing.T) {
 		t.Error("Initial request failed with non-200 code, should have gone through!: \n", recorder.Code)
 	}
 }
+
+var HMACNonceAuthDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"enable_signature_checking": true,
+		"hmac_allowed_clock_skew": 1000,
+		"hmac_allowed_algorithms": ["hmac-sha256", "hmac-sha512"],
+		"hmac_nonce_header": "X-Nonce",
+		"auth": {
+			"auth_header_name": "authorization"
+		},
+		"version_data": {
+			"not_versioned": true,
+			"versions": {
+				"Default": {
+					"name": "Default",
+					"expires": "3000-01-02 15:04"
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://example.com/",
+			"strip_listen_path": true
+		}
+	}
+
+`
+
+func createHMACNonceMiddleware() *HMACMiddleware {
+	spec := createDefinitionFromString(HMACNonceAuthDef)
+	initMemorySpec(&spec)
+	spec.SessionManager.UpdateSession("9876", createHMACAuthSession(), 60)
+
+	return &HMACMiddleware{&TykMiddleware{&spec, nil}}
+}
+
+func createSignedHMACRequest(algorithm, nonce string) *http.Request {
+	req, _ := http.NewRequest("GET", "/", nil)
+
+	tim := time.Now().Format("Mon, 02 Jan 2006 15:04:05 MST")
+	req.Header.Add("Date", tim)
+	req.Header.Add("X-Nonce", nonce)
+
+	signatureString := "date: " + tim + "\nx-nonce: " + nonce
+	encodedString := generateEncodedSignature(signatureString, createHMACAuthSession().HmacSecret, algorithm)
+	req.Header.Add("Authorization", fmt.Sprintf("Signature keyId=\"9876\",algorithm=\"%s\",headers=\"date x-nonce\",signature=\"%s\"", algorithm, encodedString))
+
+	return req
+}
+
+func TestHMACAuthAlgorithms(t *testing.T) {
+	hm := createHMACNonceMiddleware()
+
+	for i, algorithm := range []string{HMACSHA256, HMACSHA512} {
+		req := createSignedHMACRequest(algorithm, fmt.Sprintf("nonce-%d", i))
+		if err, code := hm.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+			t.Error(algorithm, " signature should be accepted, got: ", code, err)
+		}
+	}
+
+	req := createSignedHMACRequest(HMACSHA1, "nonce-sha1")
+	if _, code := hm.ProcessRequest(httptest.NewRecorder(), req, nil); code == 200 {
+		t.Error("Algorithms not in the allow list should be rejected")
+	}
+}
+
+func TestHMACAuthNonceReplay(t *testing.T) {
+	hm := createHMACNonceMiddleware()
+
+	if _, code := hm.ProcessRequest(httptest.NewRecorder(), createSignedHMACRequest(HMACSHA256, "abc"), nil); code != 200 {
+		t.Fatal("First use of a nonce should be accepted, got: ", code)
+	}
+
+	if _, code := hm.ProcessRequest(httptest.NewRecorder(), createSignedHMACRequest(HMACSHA256, "abc"), nil); code == 200 {
+		t.Error("Replayed nonce should be rejected")
+	}
+
+	if ttl := hm.nonceTTL(); ttl != 2 {
+		t.Error("Nonce should be kept for the whole clock skew window, got: ", ttl)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+const (
+	ClientCertIdentityFingerprint string = "fingerprint"
+	ClientCertIdentitySubject     string = "subject"
+)
+
+// ClientCertificateConfig enables mutual TLS for an API, the CA bundle can be a path to a PEM file or
+// the PEM data itself. The identity selects what the session is stored under: the SHA256 fingerprint
+// of the certificate (the default) or the subject common name
+type ClientCertificateConfig struct {
+	UseClientCertificates bool   `mapstructure:"use_client_certificates" bson:"use_client_certificates" json:"use_client_certificates"`
+	ClientCABundle        string `mapstructure:"client_ca_bundle" bson:"client_ca_bundle" json:"client_ca_bundle"`
+	ClientCertIdentity    string `mapstructure:"client_certificate_identity" bson:"client_certificate_identity" json:"client_certificate_identity"`
+}
+
+// getClientCertificateConfig decodes the mutual TLS options from the raw API definition
+func getClientCertificateConfig(rawData map[string]interface{}) ClientCertificateConfig {
+	var thisConfig ClientCertificateConfig
+	if rawData == nil {
+		return thisConfig
+	}
+
+	if !decodeRawConfig(rawData, "client certificate options", &thisConfig) {
+		return ClientCertificateConfig{}
+	}
+
+	return thisConfig
+}
+
+func loadClientCAPool(bundle string) (*x509.CertPool, error) {
+	pemData := []byte(bundle)
+	if !strings.HasPrefix(strings.TrimSpace(bundle), "-----BEGIN") {
+		var err error
+		pemData, err = ioutil.ReadFile(bundle)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, errors.New("No certificates found in CA bundle")
+	}
+
+	return pool, nil
+}
+
+// clientCertificateFingerprint is the hex encoded SHA256 of the DER certificate
+func clientCertificateFingerprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(hash[:])
+}
+
+// ClientCertificateCheck authenticates clients by the certificate presented in the TLS handshake,
+// the certificate must be signed by the API CA bundle and map to a session in the key store
+type ClientCertificateCheck struct {
+	*TykMiddleware
+	caPool *x509.CertPool
+}
+
+// New loads the CA bundle, if this fails all requests are rejected
+func (k *ClientCertificateCheck) New() {
+	pool, err := loadClientCAPool(k.Spec.ClientCertificates.ClientCABundle)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+			"api_id": k.Spec.APIID,
+		}).Error("Couldn't load client CA bundle, all requests will be rejected: ", err)
+		return
+	}
+
+	k.caPool = pool
+
+	if !config.HttpServerOptions.UseSSL || !config.HttpServerOptions.RequestClientCertificates {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+			"api_id": k.Spec.APIID,
+		}).Warning("API uses client certificates but the gateway is not requesting them, enable use_ssl and request_client_certificates")
+	}
+}
+
+// GetConfig retrieves the configuration from the API config - we user mapstructure for this for simplicity
+func (k *ClientCertificateCheck) GetConfig() (interface{}, error) {
+	return nil, nil
+}
+
+func (k *ClientCertificateCheck) identity(cert *x509.Certificate) string {
+	if k.Spec.ClientCertificates.ClientCertIdentity == ClientCertIdentitySubject {
+		return cert.Subject.CommonName
+	}
+
+	return clientCertificateFingerprint(cert)
+}
+
+func (k *ClientCertificateCheck) verify(r *http.Request) (*x509.Certificate, error) {
+	if k.caPool == nil {
+		return nil, errors.New("No client CA bundle loaded")
+	}
+
+	certs := r.TLS.PeerCertificates
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         k.caPool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	return certs[0], err
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (k *ClientCertificateCheck) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		log.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"origin": GetIPFromRequest(r),
+		}).Info("Attempted access without a client certificate.")
+
+		return errors.New("Client certificate required"), 401
+	}
+
+	cert, err := k.verify(r)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"origin": GetIPFromRequest(r),
+		}).Info("Attempted access with invalid client certificate: ", err)
+
+		// Fire Authfailed Event
+		AuthFailed(k.TykMiddleware, r, clientCertificateFingerprint(r.TLS.PeerCertificates[0]))
+
+		// Report in health check
+		reportKeyFailure(k.Spec, r, "1")
+
+		return errors.New("Client certificate not authorised"), 403
+	}
+
+	// Certificate sessions are stored as {org-id}{identity}, the same as basic auth users
+	keyName := k.Spec.OrgID + k.identity(cert)
+	thisSessionState, keyExists := k.TykMiddleware.CheckSessionAndIdentityForValidKey(keyName)
+	if !keyExists {
+		log.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"origin": GetIPFromRequest(r),
+			"key":    keyName,
+		}).Info("Attempted access with certificate that has no session.")
+
+		// Fire Authfailed Event
+		AuthFailed(k.TykMiddleware, r, keyName)
+
+		// Report in health check
+		reportKeyFailure(k.Spec, r, "1")
+
+		return errors.New("Client certificate not authorised"), 403
+	}
+
+	// Set session state on context, we will need it later
+	context.Set(r, SessionData, thisSessionState)
+	context.Set(r, AuthHeaderValue, keyName)
+	return nil, 200
+}
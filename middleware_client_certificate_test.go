@@ -0,0 +1,148 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var clientCertificateDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"use_client_certificates": true,
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"auth": {
+			"auth_header_name": "authorization"
+		},
+		"version_data": {
+			"not_versioned": true,
+			"versions": {
+				"Default": {
+					"name": "Default",
+					"expires": "3000-01-02 15:04"
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://lonelycode.com",
+			"strip_listen_path": false
+		}
+	}
+
+`
+
+func createTestCertificate(t *testing.T, commonName string, parent *x509.Certificate, parentKey *rsa.PrivateKey) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	} else {
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func createClientCertificateCheck(t *testing.T, ca *x509.Certificate, identity string) *ClientCertificateCheck {
+	spec := createDefinitionFromString(clientCertificateDef)
+	initMemorySpec(&spec)
+
+	spec.ClientCertificates.ClientCABundle = string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}))
+	spec.ClientCertificates.ClientCertIdentity = identity
+
+	check := &ClientCertificateCheck{TykMiddleware: &TykMiddleware{&spec, nil}}
+	check.New()
+	return check
+}
+
+func clientCertificateRequest(certs ...*x509.Certificate) *http.Request {
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: certs}
+	return req
+}
+
+func TestClientCertificateConfigDecoded(t *testing.T) {
+	spec := createDefinitionFromString(clientCertificateDef)
+	if !spec.ClientCertificates.UseClientCertificates {
+		t.Error("Client certificate option was not decoded")
+	}
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	ca, _ := createTestCertificate(t, "Test CA", nil, nil)
+	check := createClientCertificateCheck(t, ca, "")
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	if _, code := check.ProcessRequest(nil, req, nil); code != 401 {
+		t.Error("Requests without a certificate should be rejected, got: ", code)
+	}
+}
+
+func TestClientCertificateWrongCA(t *testing.T) {
+	ca, _ := createTestCertificate(t, "Test CA", nil, nil)
+	otherCA, otherKey := createTestCertificate(t, "Other CA", nil, nil)
+	cert, _ := createTestCertificate(t, "client", otherCA, otherKey)
+
+	check := createClientCertificateCheck(t, ca, "")
+	if _, code := check.ProcessRequest(nil, clientCertificateRequest(cert), nil); code != 403 {
+		t.Error("Certificates from another CA should be rejected, got: ", code)
+	}
+}
+
+func TestClientCertificateSession(t *testing.T) {
+	ca, caKey := createTestCertificate(t, "Test CA", nil, nil)
+	cert, _ := createTestCertificate(t, "client", ca, caKey)
+
+	for _, identity := range []string{ClientCertIdentityFingerprint, ClientCertIdentitySubject} {
+		check := createClientCertificateCheck(t, ca, identity)
+
+		req := clientCertificateRequest(cert)
+		if _, code := check.ProcessRequest(nil, req, nil); code != 403 {
+			t.Error(identity, ": certificates without a session should be rejected, got: ", code)
+		}
+
+		keyName := "default" + clientCertificateFingerprint(cert)
+		if identity == ClientCertIdentitySubject {
+			keyName = "defaultclient"
+		}
+		check.Spec.SessionManager.UpdateSession(keyName, createStandardSession(), 60)
+
+		req = clientCertificateRequest(cert)
+		if err, code := check.ProcessRequest(nil, req, nil); code != 200 {
+			t.Error(identity, ": valid certificate should be allowed, got: ", code, err)
+		}
+	}
+}
@@ -50,6 +50,13 @@ func (m *MiddlewareContextVars) ProcessRequest(w http.ResponseWriter, r *http.Re
 
 		// IP:Port
 		contextDataObject["remote_addr"] = copiedRequest.RemoteAddr
+
+		// Mapped JWT / OpenID claims
+		if claimData, found := context.Get(r, ClaimData).(map[string]string); found {
+			for key, value := range claimData {
+				contextDataObject[ClaimContextPrefix+key] = value
+			}
+		}
 	}
 
 	log.Debug("Context Data Object: ", contextDataObject)
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"net/http"
+)
+
+// EndpointRateLimit enforces the per-endpoint rate limits set in the extended paths, the rolling window
+// is keyed on the key (or client IP for open APIs) and the path pattern, so each endpoint has its own counter
+type EndpointRateLimit struct {
+	*TykMiddleware
+}
+
+// New lets you do any initialisations for the object can be done here
+func (e *EndpointRateLimit) New() {}
+
+// GetConfig retrieves the configuration from the API config - we user mapstructure for this for simplicity
+func (e *EndpointRateLimit) GetConfig() (interface{}, error) {
+	return nil, nil
+}
+
+// endpointRateLimitKey generates the rolling window key for an identity on a specific endpoint
+func (e *EndpointRateLimit) endpointRateLimitKey(identity string, meta *RateLimitMeta) string {
+	return RateLimitKeyPrefix + "endpoint-" + publicHash(identity) + "-" + e.Spec.APIDefinition.APIID + ":" + meta.Method + ":" + meta.Path
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (e *EndpointRateLimit) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	_, versionPaths, _, _ := e.Spec.GetVersionData(r)
+	found, meta := e.Spec.CheckSpecMatchesStatus(r.URL.Path, r.Method, versionPaths, RateLimited)
+	if !found {
+		return nil, 200
+	}
+
+	thisMeta := meta.(*RateLimitMeta)
+
+	// Open APIs have no key, so limit by client IP
+	identity := GetIPFromRequest(r)
+	if authHeaderValue, ok := context.Get(r, AuthHeaderValue).(string); ok && authHeaderValue != "" {
+		identity = authHeaderValue
+	}
+
+	rateLimiterKey := e.endpointRateLimitKey(identity, thisMeta)
+	if !isRollingWindowExceeded(rateLimiterKey, thisMeta.Rate, thisMeta.Per, e.Spec.SessionManager.GetStore()) {
+		return nil, 200
+	}
+
+	log.WithFields(logrus.Fields{
+		"path":     r.URL.Path,
+		"origin":   GetIPFromRequest(r),
+		"key":      identity,
+		"endpoint": thisMeta.Method + " " + thisMeta.Path,
+	}).Info("Endpoint rate limit exceeded.")
+
+	// Fire a rate limit exceeded event
+	go e.TykMiddleware.FireEvent(EVENT_RateLimitExceeded,
+		EVENT_RateLimitExceededMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Endpoint Rate Limit Exceeded", OriginatingRequest: EncodeRequestToEvent(r)},
+			Path:             r.URL.Path,
+			Origin:           GetIPFromRequest(r),
+			Key:              identity,
+			Endpoint:         thisMeta.Path,
+		})
+
+	// Report in health check
+	ReportHealthCheckValue(e.Spec.Health, Throttle, "-1")
+
+	return errors.New("Rate limit exceeded"), 429
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+var endpointRateLimitDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"auth": {
+			"auth_header_name": "authorization"
+		},
+		"version_data": {
+			"not_versioned": true,
+			"versions": {
+				"Default": {
+					"name": "Default",
+					"expires": "3000-01-02 15:04",
+					"use_extended_paths": true,
+					"extended_paths": {
+						"rate_limit": [
+							{
+								"path": "/search",
+								"method": "GET",
+								"rate": 2,
+								"per": 60
+							},
+							{
+								"path": "/upload",
+								"rate": 5,
+								"per": 60
+							},
+							{
+								"path": "/broken",
+								"method": "GET",
+								"rate": 0,
+								"per": 60
+							}
+						]
+					}
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://lonelycode.com",
+			"strip_listen_path": false
+		}
+	}
+
+`
+
+func TestEndpointRateLimitPathSpec(t *testing.T) {
+	thisSpec := createDefinitionFromString(endpointRateLimitDef)
+
+	req, _ := http.NewRequest("GET", "/search", nil)
+	_, versionPaths, _, _ := thisSpec.GetVersionData(req)
+
+	found, meta := thisSpec.CheckSpecMatchesStatus("/search", "GET", versionPaths, RateLimited)
+	if !found {
+		t.Fatal("Rate limited endpoint should have been found")
+	}
+
+	thisMeta := meta.(*RateLimitMeta)
+	if thisMeta.Rate != 2 || thisMeta.Per != 60 {
+		t.Error("Rate limit meta was not decoded: ", thisMeta)
+	}
+
+	if found, _ := thisSpec.CheckSpecMatchesStatus("/search", "POST", versionPaths, RateLimited); found {
+		t.Error("Rate limit should only apply to the configured method")
+	}
+
+	for _, method := range []string{"GET", "PUT"} {
+		if found, _ := thisSpec.CheckSpecMatchesStatus("/upload", method, versionPaths, RateLimited); !found {
+			t.Error("Rate limit without a method should apply to all methods: ", method)
+		}
+	}
+
+	if found, _ := thisSpec.CheckSpecMatchesStatus("/broken", "GET", versionPaths, RateLimited); found {
+		t.Error("Rate limits with no rate should be skipped")
+	}
+}
+
+func TestEndpointRateLimitKey(t *testing.T) {
+	thisSpec := createDefinitionFromString(endpointRateLimitDef)
+	mw := EndpointRateLimit{&TykMiddleware{&thisSpec, nil}}
+
+	search := &RateLimitMeta{Path: "/search", Method: "GET"}
+	other := &RateLimitMeta{Path: "/other", Method: "GET"}
+
+	if mw.endpointRateLimitKey("1234", search) == mw.endpointRateLimitKey("1234", other) {
+		t.Error("Each endpoint should have its own rolling window")
+	}
+
+	if mw.endpointRateLimitKey("1234", search) == mw.endpointRateLimitKey("5678", search) {
+		t.Error("Each key should have its own rolling window")
+	}
+}
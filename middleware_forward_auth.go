@@ -0,0 +1,325 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"github.com/pmylund/go-cache"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	ForwardAuthDefaultTimeout        int    = 5
+	ForwardAuthDefaultKeyIDHeader    string = "X-Auth-Key-Id"
+	ForwardAuthDefaultKeyIDField     string = "key_id"
+	ForwardAuthDefaultPolicyIDHeader string = "X-Auth-Policy-Id"
+	ForwardAuthDefaultPolicyIDField  string = "policy_id"
+	ForwardAuthMethodHeader          string = "X-Forwarded-Method"
+	ForwardAuthURIHeader             string = "X-Forwarded-Uri"
+	ForwardAuthForHeader             string = "X-Forwarded-For"
+	ForwardAuthSessionLifetime       int64  = 3600
+)
+
+// ForwardAuthConfig delegates authentication to an external service. The method, path and the listed
+// headers of the inbound request are sent to the auth URL, a 2xx response is a success and the key ID
+// and / or policy ID for the session are read from the response headers or JSON body. Successful
+// checks are cached for the TTL (in seconds) if it is set
+type ForwardAuthConfig struct {
+	UseForwardAuth bool     `mapstructure:"-" bson:"-" json:"-"`
+	URL            string   `mapstructure:"url" bson:"url" json:"url"`
+	Headers        []string `mapstructure:"headers" bson:"headers" json:"headers"`
+	Timeout        int      `mapstructure:"timeout" bson:"timeout" json:"timeout"`
+	CacheTTL       int64    `mapstructure:"cache_ttl" bson:"cache_ttl" json:"cache_ttl"`
+	KeyIDHeader    string   `mapstructure:"key_id_header" bson:"key_id_header" json:"key_id_header"`
+	KeyIDField     string   `mapstructure:"key_id_field" bson:"key_id_field" json:"key_id_field"`
+	PolicyIDHeader string   `mapstructure:"policy_id_header" bson:"policy_id_header" json:"policy_id_header"`
+	PolicyIDField  string   `mapstructure:"policy_id_field" bson:"policy_id_field" json:"policy_id_field"`
+}
+
+type forwardAuthRawData struct {
+	UseForwardAuth bool              `mapstructure:"use_forward_auth"`
+	ForwardAuth    ForwardAuthConfig `mapstructure:"forward_auth"`
+}
+
+// getForwardAuthConfig decodes the forward auth options from the raw API definition and sets the defaults
+func getForwardAuthConfig(rawData map[string]interface{}) ForwardAuthConfig {
+	var thisConfig forwardAuthRawData
+	if !decodeRawConfig(rawData, "forward auth options", &thisConfig) {
+		return ForwardAuthConfig{}
+	}
+
+	forwardAuth := thisConfig.ForwardAuth
+	forwardAuth.UseForwardAuth = thisConfig.UseForwardAuth
+
+	if len(forwardAuth.Headers) == 0 {
+		forwardAuth.Headers = []string{"Authorization"}
+	}
+	if forwardAuth.Timeout == 0 {
+		forwardAuth.Timeout = ForwardAuthDefaultTimeout
+	}
+	if forwardAuth.KeyIDHeader == "" {
+		forwardAuth.KeyIDHeader = ForwardAuthDefaultKeyIDHeader
+	}
+	if forwardAuth.KeyIDField == "" {
+		forwardAuth.KeyIDField = ForwardAuthDefaultKeyIDField
+	}
+	if forwardAuth.PolicyIDHeader == "" {
+		forwardAuth.PolicyIDHeader = ForwardAuthDefaultPolicyIDHeader
+	}
+	if forwardAuth.PolicyIDField == "" {
+		forwardAuth.PolicyIDField = ForwardAuthDefaultPolicyIDField
+	}
+
+	return forwardAuth
+}
+
+// ForwardAuth authenticates requests with an external auth service and then uses a session from the key
+// store, or one generated from a policy, so the standard quota and rate limit checks still apply
+type ForwardAuth struct {
+	*TykMiddleware
+	client *http.Client
+	cache  *cache.Cache
+}
+
+// New sets up the client and the cache for positive results
+func (k *ForwardAuth) New() {
+	k.client = &http.Client{Timeout: time.Duration(k.Spec.ForwardAuth.Timeout) * time.Second}
+
+	if k.Spec.ForwardAuth.CacheTTL > 0 {
+		ttl := time.Duration(k.Spec.ForwardAuth.CacheTTL) * time.Second
+		k.cache = cache.New(ttl, ttl)
+	}
+}
+
+// GetConfig retrieves the configuration from the API config - we user mapstructure for this for simplicity
+func (k *ForwardAuth) GetConfig() (interface{}, error) {
+	return nil, nil
+}
+
+// authRequest copies the method, path and the configured headers of the inbound request
+func (k *ForwardAuth) authRequest(r *http.Request) (*http.Request, error) {
+	authReq, err := http.NewRequest("GET", k.Spec.ForwardAuth.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, header := range k.Spec.ForwardAuth.Headers {
+		for _, value := range r.Header[http.CanonicalHeaderKey(header)] {
+			authReq.Header.Add(header, value)
+		}
+	}
+
+	authReq.Header.Set(ForwardAuthMethodHeader, r.Method)
+	authReq.Header.Set(ForwardAuthURIHeader, r.URL.RequestURI())
+	authReq.Header.Set(ForwardAuthForHeader, GetIPFromRequest(r))
+
+	return authReq, nil
+}
+
+// sentHeaders lists every header of the auth request in a stable order
+func sentHeaders(authReq *http.Request) []string {
+	headers := make([]string, 0, len(authReq.Header))
+	for header := range authReq.Header {
+		headers = append(headers, header)
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+// hasCredentials checks whether any of the configured headers were sent to the auth service
+func (k *ForwardAuth) hasCredentials(authReq *http.Request) bool {
+	for _, header := range k.Spec.ForwardAuth.Headers {
+		if authReq.Header.Get(header) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// hashHeaders hashes the values of headers of the auth request so that credentials are not held in memory
+func hashHeaders(authReq *http.Request, headers ...string) string {
+	h := sha256.New()
+	for _, header := range headers {
+		for _, value := range authReq.Header[http.CanonicalHeaderKey(header)] {
+			fmt.Fprintf(h, "%s:%s\n", header, value)
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// forwardAuthResult is what the auth service told us about the request
+type forwardAuthResult struct {
+	KeyID    string
+	PolicyID string
+}
+
+func (k *ForwardAuth) readResult(response *http.Response) forwardAuthResult {
+	result := forwardAuthResult{
+		KeyID:    response.Header.Get(k.Spec.ForwardAuth.KeyIDHeader),
+		PolicyID: response.Header.Get(k.Spec.ForwardAuth.PolicyIDHeader),
+	}
+
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil || len(body) == 0 {
+		return result
+	}
+
+	bodyData := make(map[string]interface{})
+	if json.Unmarshal(body, &bodyData) != nil {
+		return result
+	}
+
+	if keyID, ok := bodyData[k.Spec.ForwardAuth.KeyIDField].(string); ok && result.KeyID == "" {
+		result.KeyID = keyID
+	}
+	if policyID, ok := bodyData[k.Spec.ForwardAuth.PolicyIDField].(string); ok && result.PolicyID == "" {
+		result.PolicyID = policyID
+	}
+
+	return result
+}
+
+// sessionFor finds the session for an authenticated request. A key ID that is in the key store is used as
+// is, otherwise a session is generated from the policy and stored under the org ID and a hash of the key
+// ID, or of the credentials (or client IP without credentials) if the auth service didn't return one.
+// Generated sessions are replaced when the policy changes and always have a TTL
+func (k *ForwardAuth) sessionFor(result forwardAuthResult, identity string) (string, SessionState, error) {
+	if result.KeyID != "" {
+		if thisSessionState, keyExists := k.TykMiddleware.CheckSessionAndIdentityForValidKey(result.KeyID); keyExists {
+			return result.KeyID, thisSessionState, nil
+		}
+		identity = result.KeyID
+	}
+
+	if result.PolicyID == "" {
+		return "", SessionState{}, errors.New("Key not authorized: no key or policy returned by auth service")
+	}
+
+	sessionID := k.Spec.OrgID + fmt.Sprintf("%x", md5.Sum([]byte(identity)))
+	if thisSessionState, keyExists := k.TykMiddleware.CheckSessionAndIdentityForValidKey(sessionID); keyExists {
+		// A different policy from the auth service replaces the session
+		if thisSessionState.ApplyPolicyID == result.PolicyID {
+			return sessionID, thisSessionState, nil
+		}
+		SessionCache.Delete(sessionID)
+	}
+
+	thisSessionState, err := generateSessionFromPolicy(result.PolicyID, k.Spec.APIDefinition.OrgID, true)
+	if err != nil {
+		return "", SessionState{}, errors.New("Key not authorized: no matching policy")
+	}
+
+	thisSessionState.MetaData = map[string]interface{}{"ForwardAuthKeyID": result.KeyID}
+	k.Spec.SessionManager.UpdateSession(sessionID, thisSessionState, k.sessionLifetime())
+	return sessionID, thisSessionState, nil
+}
+
+// sessionLifetime is how long generated sessions are kept, they always expire as the auth service is
+// asked again once they are gone
+func (k *ForwardAuth) sessionLifetime() int64 {
+	if k.Spec.APIDefinition.SessionLifetime > 0 {
+		return k.Spec.APIDefinition.SessionLifetime
+	}
+	if k.Spec.ForwardAuth.CacheTTL > 0 {
+		return k.Spec.ForwardAuth.CacheTTL
+	}
+
+	return ForwardAuthSessionLifetime
+}
+
+func (k *ForwardAuth) reportLoginFailure(tykId string, r *http.Request) {
+	// Fire Authfailed Event
+	AuthFailed(k.TykMiddleware, r, tykId)
+
+	// Report in health check
+	reportKeyFailure(k.Spec, r, "1")
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (k *ForwardAuth) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	authReq, err := k.authRequest(r)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "main",
+			"api_id": k.Spec.APIID,
+		}).Error("Couldn't create forward auth request: ", err)
+		return errors.New("Authentication service unavailable"), 500
+	}
+
+	// The auth service may decide by anything it is sent, so results are cached against all of it. Requests
+	// without credentials are not cached and get a session per client so that nothing is shared
+	cacheKey := hashHeaders(authReq, sentHeaders(authReq)...)
+	useCache := k.cache != nil && k.hasCredentials(authReq)
+
+	identity := hashHeaders(authReq, k.Spec.ForwardAuth.Headers...)
+	if !k.hasCredentials(authReq) {
+		identity = hashHeaders(authReq, ForwardAuthForHeader)
+	}
+
+	if useCache {
+		if cachedKey, found := k.cache.Get(cacheKey); found {
+			if thisSessionState, keyExists := k.TykMiddleware.CheckSessionAndIdentityForValidKey(cachedKey.(string)); keyExists {
+				context.Set(r, SessionData, thisSessionState)
+				context.Set(r, AuthHeaderValue, cachedKey.(string))
+				return nil, 200
+			}
+			k.cache.Delete(cacheKey)
+		}
+	}
+
+	response, err := k.client.Do(authReq)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"origin": GetIPFromRequest(r),
+		}).Error("Forward auth request failed: ", err)
+		return errors.New("Authentication service unavailable"), 500
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		log.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"origin": GetIPFromRequest(r),
+		}).Info("Attempted access rejected by auth service: ", response.StatusCode)
+
+		k.reportLoginFailure("[FORWARD AUTH]", r)
+
+		if challenge := response.Header.Get("WWW-Authenticate"); challenge != "" {
+			w.Header().Set("WWW-Authenticate", challenge)
+		}
+
+		if response.StatusCode == 401 {
+			return errors.New("Authorization failed"), 401
+		}
+		return errors.New("Key not authorized"), 403
+	}
+
+	sessionID, thisSessionState, err := k.sessionFor(k.readResult(response), identity)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"path":   r.URL.Path,
+			"origin": GetIPFromRequest(r),
+		}).Error("Couldn't find a session for forward auth: ", err)
+
+		k.reportLoginFailure("[FORWARD AUTH]", r)
+		return err, 403
+	}
+
+	if useCache {
+		k.cache.Set(cacheKey, sessionID, cache.DefaultExpiration)
+	}
+
+	context.Set(r, SessionData, thisSessionState)
+	context.Set(r, AuthHeaderValue, sessionID)
+	return nil, 200
+}
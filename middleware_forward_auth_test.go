@@ -0,0 +1,241 @@
+package main
+
+import (
+	"github.com/gorilla/context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+var forwardAuthDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"use_forward_auth": true,
+		"forward_auth": {
+			"headers": ["Authorization", "X-Tenant"],
+			"cache_ttl": 60
+		},
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"auth": {
+			"auth_header_name": "authorization"
+		},
+		"version_data": {
+			"not_versioned": true,
+			"versions": {
+				"Default": {
+					"name": "Default",
+					"expires": "3000-01-02 15:04"
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://lonelycode.com",
+			"strip_listen_path": false
+		}
+	}
+
+`
+
+func createForwardAuth(t *testing.T, authURL string) *ForwardAuth {
+	spec := createDefinitionFromString(forwardAuthDef)
+	spec.ForwardAuth.URL = authURL
+	initMemorySpec(&spec)
+
+	Policies["forward-auth-policy"] = Policy{
+		ID:               "forward-auth-policy",
+		OrgID:            "default",
+		Rate:             1000.0,
+		Per:              1.0,
+		QuotaMax:         -1,
+		QuotaRenewalRate: -1,
+		AccessRights:     map[string]AccessDefinition{},
+		Active:           true,
+	}
+
+	mw := &ForwardAuth{TykMiddleware: &TykMiddleware{&spec, nil}}
+	mw.New()
+	return mw
+}
+
+func TestForwardAuthConfigDecoded(t *testing.T) {
+	spec := createDefinitionFromString(forwardAuthDef)
+	if !spec.ForwardAuth.UseForwardAuth || len(spec.ForwardAuth.Headers) != 2 || spec.ForwardAuth.CacheTTL != 60 {
+		t.Error("Forward auth options were not decoded: ", spec.ForwardAuth)
+	}
+
+	if spec.ForwardAuth.PolicyIDHeader != ForwardAuthDefaultPolicyIDHeader {
+		t.Error("Defaults were not set: ", spec.ForwardAuth)
+	}
+}
+
+func TestForwardAuthRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		w.WriteHeader(401)
+	}))
+	defer server.Close()
+
+	mw := createForwardAuth(t, server.URL)
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	if _, code := mw.ProcessRequest(recorder, req, nil); code != 401 {
+		t.Error("Expected the auth service status, got: ", code)
+	}
+
+	if recorder.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Error("Auth service challenge should be passed on")
+	}
+}
+
+func TestForwardAuthPolicySession(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.Header.Get("Authorization") != "Bearer user-token" || r.Header.Get("X-Tenant") != "acme" ||
+			r.Header.Get(ForwardAuthMethodHeader) != "POST" || r.Header.Get(ForwardAuthURIHeader) != "/v1/test?a=b" {
+			t.Error("Inbound request was not forwarded: ", r.Header)
+		}
+		if r.Header.Get("X-Not-Forwarded") != "" {
+			t.Error("Only the configured headers should be forwarded")
+		}
+		w.Write([]byte(`{"key_id": "user-1", "policy_id": "forward-auth-policy"}`))
+	}))
+	defer server.Close()
+
+	mw := createForwardAuth(t, server.URL)
+
+	var sessionID string
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest("POST", "/v1/test?a=b", nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+		req.Header.Set("X-Tenant", "acme")
+		req.Header.Set("X-Not-Forwarded", "secret")
+
+		if err, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+			t.Fatal("Request should be authorised, got: ", code, err)
+		}
+
+		thisSessionState := context.Get(req, SessionData).(SessionState)
+		if thisSessionState.ApplyPolicyID != "forward-auth-policy" {
+			t.Error("Session should be generated from the policy: ", thisSessionState)
+		}
+
+		if i == 0 {
+			sessionID = context.Get(req, AuthHeaderValue).(string)
+		} else if context.Get(req, AuthHeaderValue).(string) != sessionID {
+			t.Error("The same session should be used for the same key ID")
+		}
+		context.Clear(req)
+	}
+
+	if atomic.LoadInt32(&requests) != 1 {
+		t.Error("Successful auth should be cached, auth service was called: ", requests)
+	}
+}
+
+func TestForwardAuthExistingKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(ForwardAuthDefaultKeyIDHeader, "forward-auth-key")
+	}))
+	defer server.Close()
+
+	mw := createForwardAuth(t, server.URL)
+	mw.Spec.SessionManager.UpdateSession("forward-auth-key", createStandardSession(), 60)
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	defer context.Clear(req)
+	if err, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+		t.Fatal("Existing key should be used, got: ", code, err)
+	}
+
+	if context.Get(req, AuthHeaderValue).(string) != "forward-auth-key" {
+		t.Error("Session should be stored under the returned key ID")
+	}
+}
+
+func TestForwardAuthCachePerClient(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Write([]byte(`{"policy_id": "forward-auth-policy"}`))
+	}))
+	defer server.Close()
+
+	mw := createForwardAuth(t, server.URL)
+
+	sessions := make(map[string]bool)
+	for _, clientIP := range []string{"10.0.0.1:1234", "10.0.0.2:1234"} {
+		req, _ := http.NewRequest("GET", "/v1/test", nil)
+		req.RemoteAddr = clientIP
+		req.Header.Set("Authorization", "Bearer user-token")
+
+		if err, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+			t.Fatal("Request should be authorised, got: ", code, err)
+		}
+		context.Clear(req)
+	}
+
+	if atomic.LoadInt32(&requests) != 2 {
+		t.Error("Results for one client IP should not be reused for another, auth service was called: ", requests)
+	}
+
+	// Without credentials nothing is cached and clients don't share a session
+	for _, clientIP := range []string{"10.0.0.1:1234", "10.0.0.1:1234", "10.0.0.2:1234"} {
+		req, _ := http.NewRequest("GET", "/v1/test", nil)
+		req.RemoteAddr = clientIP
+
+		if err, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+			t.Fatal("Request should be authorised, got: ", code, err)
+		}
+		sessions[context.Get(req, AuthHeaderValue).(string)] = true
+		context.Clear(req)
+	}
+
+	if atomic.LoadInt32(&requests) != 5 {
+		t.Error("Requests without credentials should not be cached, auth service was called: ", requests)
+	}
+	if len(sessions) != 2 {
+		t.Error("Each client should get its own session without credentials: ", sessions)
+	}
+}
+
+func TestForwardAuthPolicyChange(t *testing.T) {
+	policyID := "forward-auth-policy"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"key_id": "user-1", "policy_id": "` + policyID + `"}`))
+	}))
+	defer server.Close()
+
+	mw := createForwardAuth(t, server.URL)
+	mw.cache = nil
+	Policies["forward-auth-policy-2"] = Policy{ID: "forward-auth-policy-2", OrgID: "default", Rate: 10.0, Per: 1.0, QuotaMax: -1, Active: true}
+
+	for _, expected := range []string{"forward-auth-policy", "forward-auth-policy-2"} {
+		policyID = expected
+		req, _ := http.NewRequest("GET", "/v1/test", nil)
+		req.Header.Set("Authorization", "Bearer user-token")
+
+		if err, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 200 {
+			t.Fatal("Request should be authorised, got: ", code, err)
+		}
+
+		if thisSessionState := context.Get(req, SessionData).(SessionState); thisSessionState.ApplyPolicyID != expected {
+			t.Error("Session should follow the policy from the auth service: ", thisSessionState.ApplyPolicyID)
+		}
+
+		// Generated sessions always expire, the definition has no session lifetime so the cache TTL is used
+		sessionID := context.Get(req, AuthHeaderValue).(string)
+		if ttl, _ := mw.Spec.SessionManager.GetStore().GetExp(sessionID); ttl <= 0 || ttl > 60 {
+			t.Error("Session should be stored with the cache TTL, got: ", ttl)
+		}
+		context.Clear(req)
+	}
+}
@@ -5,15 +5,12 @@ import "net/http"
 import (
 	"crypto/md5"
 	b64 "encoding/base64"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Sirupsen/logrus"
 	"github.com/dgrijalva/jwt-go"
 	"github.com/gorilla/context"
-	"github.com/pmylund/go-cache"
 	"io"
-	"io/ioutil"
 	"strings"
 	"time"
 )
@@ -24,8 +21,6 @@ type JWTMiddleware struct {
 	*TykMiddleware
 }
 
-var JWKCache *cache.Cache
-
 type JWK struct {
 	Alg string   `json:"alg"`
 	Kty string   `json:"kty"`
@@ -33,6 +28,9 @@ type JWK struct {
 	X5c []string `json:"x5c"`
 	N   string   `json:"n"`
 	E   string   `json:"e"`
+	Crv string   `json:"crv"`
+	X   string   `json:"x"`
+	Y   string   `json:"y"`
 	KID string   `json:"kid"`
 	X5t string   `json:"x5t"`
 }
@@ -52,65 +50,14 @@ func (k *JWTMiddleware) copyResponse(dst io.Writer, src io.Reader) {
 	io.Copy(dst, src)
 }
 
-func (k *JWTMiddleware) getSecretFromURL(url string, kid string, keyType string) ([]byte, error) {
-	// Implement a cache
-	if JWKCache == nil {
-		log.Debug("Creating JWK Cache")
-		JWKCache = cache.New(240*time.Second, 30*time.Second)
-	}
-
-	var thisJWKSet JWKs
-	cachedJWK, found := JWKCache.Get(k.TykMiddleware.Spec.APIID)
-	if !found {
-		// Get the JWK
-		log.Debug("Pulling JWK")
-		response, err := http.Get(url)
-		if err != nil {
-			log.Error("Failed to get resource URL: ", err)
-			return nil, err
-		}
-
-		// Decode it
-		defer response.Body.Close()
-		contents, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			log.Error("Failed to read body data: ", err)
-			return nil, err
-		}
-
-		decErr := json.Unmarshal(contents, &thisJWKSet)
-		if decErr != nil {
-			log.Error("Failed to decode body JWK: ", decErr)
-			return nil, err
-		}
-
-		// Cache it
-		log.Debug("Caching JWK")
-		JWKCache.Set(k.TykMiddleware.Spec.APIID, thisJWKSet, cache.DefaultExpiration)
-	} else {
-		thisJWKSet = cachedJWK.(JWKs)
-	}
-
-	log.Debug("Checking JWKs...")
-	for _, val := range thisJWKSet.Keys {
-		if val.KID == kid {
-			if strings.ToLower(val.Kty) == strings.ToLower(keyType) {
-				if len(val.X5c) > 0 {
-					// Use the first cert only
-					decodedCert, decErr := b64.StdEncoding.DecodeString(val.X5c[0])
-					if decErr != nil {
-						return nil, decErr
-					}
-					log.Debug("Found cert! Replying...")
-					log.Debug("Cert was: ", string(decodedCert))
-					return decodedCert, nil
-				}
-				return nil, errors.New("No certificates in JWK!")
-			}
-		}
+// getSecretFromURL looks up the key for a kid in the JWKS cache, keys are either PEM bytes or a parsed public key
+func (k *JWTMiddleware) getSecretFromURL(url string, kid string, keyType string) (interface{}, error) {
+	// JWKs use EC rather than the signing method name
+	if keyType == "ecdsa" {
+		keyType = "EC"
 	}
 
-	return nil, errors.New("No matching KID could be found")
+	return jwksCache.GetKey(url, kid, keyType)
 }
 
 func (k *JWTMiddleware) getIdentityFomToken(token *jwt.Token) (string, bool) {
@@ -133,14 +80,15 @@ func (k *JWTMiddleware) getIdentityFomToken(token *jwt.Token) (string, bool) {
 	return tykId, idFound
 }
 
-func (k *JWTMiddleware) getSecret(token *jwt.Token) ([]byte, error) {
+func (k *JWTMiddleware) getSecret(token *jwt.Token) (interface{}, error) {
 	thisConfig := k.TykMiddleware.Spec.APIDefinition
 	// Check for central JWT source
 	if thisConfig.JWTSource != "" {
 
 		// Is it a URL?
 		if strings.HasPrefix(strings.ToLower(thisConfig.JWTSource), "http://") || strings.HasPrefix(strings.ToLower(thisConfig.JWTSource), "https://") {
-			secret, urlErr := k.getSecretFromURL(thisConfig.JWTSource, token.Header["kid"].(string), k.TykMiddleware.Spec.JWTSigningMethod)
+			kid, _ := token.Header["kid"].(string)
+			secret, urlErr := k.getSecretFromURL(thisConfig.JWTSource, kid, k.TykMiddleware.Spec.JWTSigningMethod)
 			if urlErr != nil {
 				return nil, urlErr
 			}
@@ -282,7 +230,7 @@ func (k *JWTMiddleware) reportLoginFailure(tykId string, r *http.Request) {
 	AuthFailed(k.TykMiddleware, r, tykId)
 
 	// Report in health check
-	ReportHealthCheckValue(k.Spec.Health, KeyFailure, "1")
+	reportKeyFailure(k.Spec, r, "1")
 }
 
 func (k *JWTMiddleware) processOneToOneTokenMap(w http.ResponseWriter, r *http.Request, token *jwt.Token) (error, int) {
@@ -347,8 +295,9 @@ func (k *JWTMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, c
 	// enable bearer token format
 	rawJWT = stripBearer(rawJWT)
 
-	// Verify the token
-	token, err := jwt.Parse(rawJWT, func(token *jwt.Token) (interface{}, error) {
+	// Verify the token, claims are validated afterwards with the API's rules
+	parser := &jwt.Parser{SkipClaimsValidation: true}
+	token, err := parser.Parse(rawJWT, func(token *jwt.Token) (interface{}, error) {
 		// Don't forget to validate the alg is what you expect:
 		if k.TykMiddleware.Spec.JWTSigningMethod == "hmac" {
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -369,36 +318,64 @@ func (k *JWTMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, c
 			}
 		}
 
-		var val []byte
-		var secretErr error
-
-		val, secretErr = k.getSecret(token)
+		val, secretErr := k.getSecret(token)
 		if secretErr != nil {
 			log.Error("Couldn't get token: ", secretErr)
+			return nil, secretErr
 		}
 
-		if k.TykMiddleware.Spec.JWTSigningMethod == "rsa" {
-			asRSA, err := jwt.ParseRSAPublicKeyFromPEM(val)
+		// Keys from a JWKS may already be parsed
+		pemKey, isPEM := val.([]byte)
+		if k.TykMiddleware.Spec.JWTSigningMethod == "rsa" && isPEM {
+			asRSA, err := jwt.ParseRSAPublicKeyFromPEM(pemKey)
 			if err != nil {
 				log.Error("Failed to deccode JWT to RSA type")
 				return nil, err
 			}
-			return asRSA, secretErr
+			return asRSA, nil
+		}
+
+		if k.TykMiddleware.Spec.JWTSigningMethod == "ecdsa" && isPEM {
+			asECDSA, err := jwt.ParseECPublicKeyFromPEM(pemKey)
+			if err != nil {
+				log.Error("Failed to decode JWT to ECDSA type")
+				return nil, err
+			}
+			return asECDSA, nil
 		}
 
-		return val, secretErr
+		return val, nil
 	})
 
 	if err == nil && token.Valid {
 		// Token is valid - let's move on
+		if claimErr := validateJWTClaims(token.Claims.(jwt.MapClaims), k.TykMiddleware.Spec.JWTValidation, time.Now()); claimErr != nil {
+			log.WithFields(logrus.Fields{
+				"path":   r.URL.Path,
+				"origin": GetIPFromRequest(r),
+			}).Info("Attempted JWT access with invalid claims: ", claimErr)
+
+			tykId, _ = k.getIdentityFomToken(token)
+			AuthFailedWithReason(k.TykMiddleware, r, tykId, claimErr.Error())
+			reportKeyFailure(k.Spec, r, "1")
+			return errors.New("Key not authorized: " + claimErr.Error()), 401
+		}
 
 		// Are we mapping to a central JWT Secret?
+		var processErr error
+		var code int
 		if k.TykMiddleware.Spec.APIDefinition.JWTSource != "" {
-			return k.processCentralisedJWT(w, r, token)
+			processErr, code = k.processCentralisedJWT(w, r, token)
+		} else {
+			// No, let's try one-to-one mapping
+			processErr, code = k.processOneToOneTokenMap(w, r, token)
+		}
+
+		if processErr == nil {
+			applyClaimMappings(r, k.Spec.ClaimMappings, token.Claims.(jwt.MapClaims))
 		}
 
-		// No, let's try one-to-one mapping
-		return k.processOneToOneTokenMap(w, r, token)
+		return processErr, code
 
 	} else {
 		log.WithFields(logrus.Fields{
@@ -445,6 +422,8 @@ func generateSessionFromPolicy(policyID string, OrgID string, enforceOrg bool) (
 		thisSessionState.Allowance = policy.Rate // This is a legacy thing, merely to make sure output is consistent. Needs to be purged
 		thisSessionState.Rate = policy.Rate
 		thisSessionState.Per = policy.Per
+		thisSessionState.RateLimitAlgorithm = policy.RateLimitAlgorithm
+		thisSessionState.RateLimitBurst = policy.RateLimitBurst
 		thisSessionState.QuotaMax = policy.QuotaMax
 		thisSessionState.QuotaRenewalRate = policy.QuotaRenewalRate
 		thisSessionState.AccessRights = policy.AccessRights
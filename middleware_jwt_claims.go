@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Sirupsen/logrus"
+	"github.com/dgrijalva/jwt-go"
+	"regexp"
+	"time"
+)
+
+// Match types for required JWT claims
+const (
+	JWTClaimMatchExact string = "exact"
+	JWTClaimMatchRegex string = "regex"
+)
+
+// JWTClaimRule requires a claim to be present, and if a value is set, to match it. Array claims match
+// if any of their values match
+type JWTClaimRule struct {
+	Claim string `mapstructure:"claim" bson:"claim" json:"claim"`
+	Value string `mapstructure:"value" bson:"value" json:"value"`
+	Match string `mapstructure:"match" bson:"match" json:"match"`
+
+	valueRegex *regexp.Regexp
+}
+
+// JWTValidationConfig holds the claim rules checked after a JWT signature has been verified, leeway
+// is the clock skew in seconds allowed for the exp, nbf and iat claims
+type JWTValidationConfig struct {
+	AllowedIssuers   []string       `mapstructure:"jwt_allowed_issuers" bson:"jwt_allowed_issuers" json:"jwt_allowed_issuers"`
+	AllowedAudiences []string       `mapstructure:"jwt_allowed_audiences" bson:"jwt_allowed_audiences" json:"jwt_allowed_audiences"`
+	Leeway           int64          `mapstructure:"jwt_leeway" bson:"jwt_leeway" json:"jwt_leeway"`
+	RequiredClaims   []JWTClaimRule `mapstructure:"jwt_required_claims" bson:"jwt_required_claims" json:"jwt_required_claims"`
+}
+
+// getJWTValidationConfig decodes the JWT claim rules from the raw API definition, regex rules that
+// don't compile are kept so that they always fail
+func getJWTValidationConfig(rawData map[string]interface{}) JWTValidationConfig {
+	var thisConfig JWTValidationConfig
+	if rawData == nil {
+		return thisConfig
+	}
+
+	if !decodeRawConfig(rawData, "JWT validation rules", &thisConfig) {
+		return JWTValidationConfig{}
+	}
+
+	for i, rule := range thisConfig.RequiredClaims {
+		if rule.Match != JWTClaimMatchRegex {
+			continue
+		}
+
+		valueRegex, err := regexp.Compile(rule.Value)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "main",
+			}).Error("Couldn't compile JWT claim rule for ", rule.Claim, ": ", err)
+			continue
+		}
+		thisConfig.RequiredClaims[i].valueRegex = valueRegex
+	}
+
+	return thisConfig
+}
+
+// claimStrings returns the string form of a claim, array claims return each of their values
+func claimStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case nil:
+		return nil
+	case string:
+		return []string{value}
+	case []interface{}:
+		values := make([]string, 0, len(value))
+		for _, item := range value {
+			values = append(values, claimStrings(item)...)
+		}
+		return values
+	}
+
+	return []string{fmt.Sprint(claim)}
+}
+
+func claimIn(claim interface{}, allowed []string) bool {
+	for _, value := range claimStrings(claim) {
+		for _, allowedValue := range allowed {
+			if value == allowedValue {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func claimTime(claims jwt.MapClaims, name string) (int64, bool) {
+	switch value := claims[name].(type) {
+	case float64:
+		return int64(value), true
+	case int64:
+		return value, true
+	}
+
+	return 0, false
+}
+
+// matches checks a claim value against the rule
+func (c JWTClaimRule) matches(claim interface{}) bool {
+	if c.Value == "" && c.Match != JWTClaimMatchRegex {
+		return true
+	}
+
+	for _, value := range claimStrings(claim) {
+		if c.Match == JWTClaimMatchRegex {
+			if c.valueRegex != nil && c.valueRegex.MatchString(value) {
+				return true
+			}
+		} else if value == c.Value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validateJWTClaims checks the time claims and the API's claim rules, the error is the failing rule
+func validateJWTClaims(claims jwt.MapClaims, rules JWTValidationConfig, now time.Time) error {
+	nowUnix := now.Unix()
+
+	if exp, found := claimTime(claims, "exp"); found && nowUnix-rules.Leeway > exp {
+		return errors.New("token has expired")
+	}
+
+	if nbf, found := claimTime(claims, "nbf"); found && nowUnix+rules.Leeway < nbf {
+		return errors.New("token is not valid yet")
+	}
+
+	if iat, found := claimTime(claims, "iat"); found && nowUnix+rules.Leeway < iat {
+		return errors.New("token was issued in the future")
+	}
+
+	if len(rules.AllowedIssuers) > 0 && !claimIn(claims["iss"], rules.AllowedIssuers) {
+		return errors.New("issuer is not allowed")
+	}
+
+	if len(rules.AllowedAudiences) > 0 && !claimIn(claims["aud"], rules.AllowedAudiences) {
+		return errors.New("audience is not allowed")
+	}
+
+	for _, rule := range rules.RequiredClaims {
+		claim, found := claims[rule.Claim]
+		if !found {
+			return errors.New("required claim " + rule.Claim + " is missing")
+		}
+
+		if !rule.matches(claim) {
+			return errors.New("claim " + rule.Claim + " does not match the required value")
+		}
+	}
+
+	return nil
+}
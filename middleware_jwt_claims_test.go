@@ -0,0 +1,103 @@
+package main
+
+import (
+	"github.com/dgrijalva/jwt-go"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func createJWTClaimRules() JWTValidationConfig {
+	return getJWTValidationConfig(map[string]interface{}{
+		"jwt_allowed_issuers":   []interface{}{"https://issuer.example.com"},
+		"jwt_allowed_audiences": []interface{}{"tyk", "other"},
+		"jwt_leeway":            30,
+		"jwt_required_claims": []interface{}{
+			map[string]interface{}{"claim": "scope", "value": "^read", "match": "regex"},
+			map[string]interface{}{"claim": "tenant", "value": "acme"},
+		},
+	})
+}
+
+func createValidClaims(now time.Time) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":    "https://issuer.example.com",
+		"aud":    []interface{}{"another", "tyk"},
+		"exp":    float64(now.Add(time.Hour).Unix()),
+		"nbf":    float64(now.Unix()),
+		"scope":  "read:widgets",
+		"tenant": "acme",
+	}
+}
+
+func TestJWTClaimRulesDecoded(t *testing.T) {
+	rules := createJWTClaimRules()
+	if len(rules.AllowedIssuers) != 1 || len(rules.AllowedAudiences) != 2 || rules.Leeway != 30 {
+		t.Fatal("JWT validation rules were not decoded: ", rules)
+	}
+
+	if len(rules.RequiredClaims) != 2 || rules.RequiredClaims[0].valueRegex == nil {
+		t.Error("Regex claim rule was not compiled: ", rules.RequiredClaims)
+	}
+}
+
+func TestJWTClaimRules(t *testing.T) {
+	now := time.Now()
+	rules := createJWTClaimRules()
+
+	if err := validateJWTClaims(createValidClaims(now), rules, now); err != nil {
+		t.Fatal("Valid claims should pass: ", err)
+	}
+
+	failures := map[string]func(jwt.MapClaims){
+		"token has expired":                             func(c jwt.MapClaims) { c["exp"] = float64(now.Unix() - 60) },
+		"token is not valid yet":                        func(c jwt.MapClaims) { c["nbf"] = float64(now.Unix() + 60) },
+		"issuer is not allowed":                         func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" },
+		"audience is not allowed":                       func(c jwt.MapClaims) { delete(c, "aud") },
+		"required claim tenant is missing":              func(c jwt.MapClaims) { delete(c, "tenant") },
+		"claim scope does not match the required value": func(c jwt.MapClaims) { c["scope"] = "write:widgets" },
+	}
+
+	for reason, change := range failures {
+		claims := createValidClaims(now)
+		change(claims)
+
+		err := validateJWTClaims(claims, rules, now)
+		if err == nil || err.Error() != reason {
+			t.Error("Expected failure: ", reason, ", got: ", err)
+		}
+	}
+
+	// Within the leeway
+	claims := createValidClaims(now)
+	claims["exp"] = float64(now.Unix() - 10)
+	if err := validateJWTClaims(claims, rules, now); err != nil {
+		t.Error("Clock skew within the leeway should be allowed: ", err)
+	}
+}
+
+func TestJWTClaimRulesRejectRequest(t *testing.T) {
+	spec := createDefinitionFromString(jwtDef)
+	spec.JWTSigningMethod = "hmac"
+	spec.JWTValidation = createJWTClaimRules()
+	initMemorySpec(&spec)
+	spec.SessionManager.UpdateSession("jwt-claims-kid", createJWTSession(), 60)
+
+	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["kid"] = "jwt-claims-kid"
+	token.Claims = createValidClaims(time.Now())
+	token.Claims.(jwt.MapClaims)["tenant"] = "someone-else"
+	tokenString, err := token.SignedString([]byte(JWTSECRET))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, _ := http.NewRequest("GET", "/jwt_test/", nil)
+	req.Header.Set("authorization", tokenString)
+
+	mw := &JWTMiddleware{&TykMiddleware{&spec, nil}}
+	err, code := mw.ProcessRequest(nil, req, nil)
+	if code != 401 || err.Error() != "Key not authorized: claim tenant does not match the required value" {
+		t.Error("Expected the failing claim rule, got: ", code, err)
+	}
+}
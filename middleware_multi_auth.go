@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"net/http"
+)
+
+// Auth modes that can be listed in auth_modes
+const (
+	AuthModeToken             string = "auth_token"
+	AuthModeBasic             string = "basic"
+	AuthModeHMAC              string = "hmac"
+	AuthModeJWT               string = "jwt"
+	AuthModeOpenID            string = "openid"
+	AuthModeOAuth             string = "oauth"
+	AuthModeClientCertificate string = "client_certificate"
+	AuthModeForwardAuth       string = "forward_auth"
+)
+
+// AuthModesConfig lists the auth modes an API accepts in priority order, if it is set it replaces the
+// single mode selected by the API definition flags
+type AuthModesConfig struct {
+	AuthModes []string `mapstructure:"auth_modes" bson:"auth_modes" json:"auth_modes"`
+}
+
+// getAuthModes decodes the auth mode list from the raw API definition
+func getAuthModes(rawData map[string]interface{}) []string {
+	var thisConfig AuthModesConfig
+	if rawData == nil {
+		return thisConfig.AuthModes
+	}
+
+	if !decodeRawConfig(rawData, "auth modes", &thisConfig) {
+		return nil
+	}
+
+	return thisConfig.AuthModes
+}
+
+// authModeMiddleware returns the key check for an auth mode, or nil if the mode is not known
+func authModeMiddleware(mode string, tykMiddleware *TykMiddleware) TykMiddlewareImplementation {
+	switch mode {
+	case AuthModeToken:
+		return &AuthKey{tykMiddleware}
+	case AuthModeBasic:
+		return &BasicAuthKeyIsValid{tykMiddleware}
+	case AuthModeHMAC:
+		return &HMACMiddleware{tykMiddleware}
+	case AuthModeJWT:
+		return &JWTMiddleware{tykMiddleware}
+	case AuthModeOpenID:
+		return &OpenIDMW{TykMiddleware: tykMiddleware}
+	case AuthModeOAuth:
+		return &Oauth2KeyExists{tykMiddleware}
+	case AuthModeClientCertificate:
+		return &ClientCertificateCheck{TykMiddleware: tykMiddleware}
+	case AuthModeForwardAuth:
+		return &ForwardAuth{TykMiddleware: tykMiddleware}
+	}
+
+	return nil
+}
+
+// authAttemptWriter stops a failed auth mode from writing to the response, headers are kept so
+// challenges (e.g. WWW-Authenticate) can be sent if every mode fails
+type authAttemptWriter struct {
+	header http.Header
+}
+
+func (a *authAttemptWriter) Header() http.Header {
+	return a.header
+}
+
+func (a *authAttemptWriter) Write(b []byte) (int, error) {
+	return len(b), nil
+}
+
+func (a *authAttemptWriter) WriteHeader(int) {}
+
+type authModeCheck struct {
+	name          string
+	mw            TykMiddlewareImplementation
+	configuration interface{}
+}
+
+// MultiAuthMiddleware tries each of the API auth modes in order and uses the session from the first
+// one that succeeds, this lets consumers move between auth modes without a cut over
+type MultiAuthMiddleware struct {
+	*TykMiddleware
+	checks []authModeCheck
+}
+
+// New sets up the key check for each auth mode
+func (m *MultiAuthMiddleware) New() {
+	for _, mode := range m.Spec.AuthModes {
+		mw := authModeMiddleware(mode, m.TykMiddleware)
+		if mw == nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "main",
+				"api_id": m.Spec.APIID,
+			}).Error("Unknown auth mode, skipping: ", mode)
+			continue
+		}
+
+		mw.New()
+		configuration, err := mw.GetConfig()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "main",
+				"api_id": m.Spec.APIID,
+			}).Error("Couldn't load auth mode configuration, skipping: ", mode)
+			continue
+		}
+
+		m.checks = append(m.checks, authModeCheck{name: mode, mw: mw, configuration: configuration})
+	}
+}
+
+// GetConfig retrieves the configuration from the API config - we user mapstructure for this for simplicity
+func (m *MultiAuthMiddleware) GetConfig() (interface{}, error) {
+	return nil, nil
+}
+
+// ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
+func (m *MultiAuthMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	if len(m.checks) == 0 {
+		return errors.New("No auth modes available"), 403
+	}
+
+	// Individual failures are expected, only fire the event and report to the health check if every mode fails
+	context.Set(r, SuppressAuthFailure, true)
+	defer context.Delete(r, SuppressAuthFailure)
+
+	var failErr error
+	var failCode int
+	challenge := http.Header{}
+
+	for _, check := range m.checks {
+		attempt := &authAttemptWriter{header: http.Header{}}
+		err, code := check.mw.ProcessRequest(attempt, r, check.configuration)
+		if err == nil {
+			log.WithFields(logrus.Fields{
+				"path":   r.URL.Path,
+				"origin": GetIPFromRequest(r),
+			}).Debug("Authenticated with auth mode: ", check.name)
+
+			return nil, code
+		}
+
+		for name, values := range attempt.header {
+			for _, value := range values {
+				challenge.Add(name, value)
+			}
+		}
+
+		// Keep the first rejection of credentials that were present over missing credentials
+		if failErr == nil || (code == 403 && failCode != 403) {
+			failErr, failCode = err, code
+		}
+	}
+
+	context.Delete(r, SuppressAuthFailure)
+
+	log.WithFields(logrus.Fields{
+		"path":   r.URL.Path,
+		"origin": GetIPFromRequest(r),
+	}).Info("Attempted access failed for all auth modes.")
+
+	// Fire Authfailed Event
+	AuthFailed(m.TykMiddleware, r, r.Header.Get(m.Spec.Auth.AuthHeaderName))
+
+	// Report in health check
+	ReportHealthCheckValue(m.Spec.Health, KeyFailure, "1")
+
+	for name, values := range challenge {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+
+	return failErr, failCode
+}
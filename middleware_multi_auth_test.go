@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var authModesDef string = `
+
+	{
+		"name": "Tyk Test API",
+		"api_id": "1",
+		"org_id": "default",
+		"auth_modes": ["basic", "auth_token", "not_a_mode"],
+		"definition": {
+			"location": "header",
+			"key": "version"
+		},
+		"auth": {
+			"auth_header_name": "authorization"
+		},
+		"version_data": {
+			"not_versioned": true,
+			"versions": {
+				"Default": {
+					"name": "Default",
+					"expires": "3000-01-02 15:04"
+				}
+			}
+		},
+		"proxy": {
+			"listen_path": "/v1",
+			"target_url": "http://lonelycode.com",
+			"strip_listen_path": false
+		}
+	}
+
+`
+
+func createMultiAuthMiddleware() *MultiAuthMiddleware {
+	spec := createDefinitionFromString(authModesDef)
+	initMemorySpec(&spec)
+	spec.SessionManager.UpdateSession("multi-auth-token", createStandardSession(), 60)
+
+	mw := &MultiAuthMiddleware{TykMiddleware: &TykMiddleware{&spec, nil}}
+	mw.New()
+	return mw
+}
+
+func TestMultiAuthSkipsUnknownModes(t *testing.T) {
+	mw := createMultiAuthMiddleware()
+	if len(mw.checks) != 2 || mw.checks[0].name != AuthModeBasic || mw.checks[1].name != AuthModeToken {
+		t.Error("Expected basic then token auth modes: ", mw.checks)
+	}
+}
+
+func TestMultiAuthFallsBack(t *testing.T) {
+	mw := createMultiAuthMiddleware()
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("authorization", "multi-auth-token")
+
+	if err, code := mw.ProcessRequest(recorder, req, nil); code != 200 {
+		t.Fatal("Token should be accepted after basic auth fails, got: ", code, err)
+	}
+
+	if recorder.Header().Get("WWW-Authenticate") != "" {
+		t.Error("Failed auth modes should not write to the response")
+	}
+}
+
+func TestMultiAuthAllModesFail(t *testing.T) {
+	mw := createMultiAuthMiddleware()
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	if _, code := mw.ProcessRequest(recorder, req, nil); code != 401 {
+		t.Error("Expected the first failure when no credentials are sent, got: ", code)
+	}
+
+	if recorder.Header().Get("WWW-Authenticate") == "" {
+		t.Error("Basic auth challenge should be sent when all modes fail")
+	}
+
+	req, _ = http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("authorization", "unknown-token")
+	if _, code := mw.ProcessRequest(httptest.NewRecorder(), req, nil); code != 403 {
+		t.Error("Expected the rejected token to be reported, got: ", code)
+	}
+}
+
+type countingHealthChecker struct {
+	values chan string
+}
+
+func (c *countingHealthChecker) Init(StorageHandler) {}
+
+func (c *countingHealthChecker) GetApiHealthValues() (HealthCheckValues, error) {
+	return HealthCheckValues{}, nil
+}
+
+func (c *countingHealthChecker) StoreCounterVal(counterType HealthPrefix, value string) {
+	if counterType == KeyFailure {
+		c.values <- value
+	}
+}
+
+func TestMultiAuthReportsHealthOnce(t *testing.T) {
+	mw := createMultiAuthMiddleware()
+	checker := &countingHealthChecker{values: make(chan string, 10)}
+	mw.Spec.Health = checker
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("authorization", "unknown-token")
+	mw.ProcessRequest(httptest.NewRecorder(), req, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	if len(checker.values) != 1 {
+		t.Error("Key failure should be reported once when every mode fails, got: ", len(checker.values))
+	}
+
+	req, _ = http.NewRequest("GET", "/v1/test", nil)
+	req.Header.Set("authorization", "multi-auth-token")
+	mw.ProcessRequest(httptest.NewRecorder(), req, nil)
+
+	time.Sleep(100 * time.Millisecond)
+	if len(checker.values) != 1 {
+		t.Error("Failed modes should not be reported when another mode succeeds")
+	}
+}
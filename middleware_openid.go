@@ -186,6 +186,7 @@ func (k *OpenIDMW) ProcessRequest(w http.ResponseWriter, r *http.Request, config
 	// 4. Set session state on context, we will need it later
 	context.Set(r, SessionData, thisSessionState)
 	context.Set(r, AuthHeaderValue, SessionID)
+	applyClaimMappings(r, k.Spec.ClaimMappings, token.Claims.(jwt.MapClaims))
 
 	return nil, 200
 }
@@ -200,5 +201,5 @@ func (k *OpenIDMW) reportLoginFailure(tykId string, r *http.Request) {
 	AuthFailed(k.TykMiddleware, r, tykId)
 
 	// Report in health check
-	ReportHealthCheckValue(k.Spec.Health, KeyFailure, "1")
+	reportKeyFailure(k.Spec, r, "1")
 }
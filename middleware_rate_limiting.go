@@ -6,6 +6,7 @@ import (
 	"errors"
 	"github.com/Sirupsen/logrus"
 	"github.com/gorilla/context"
+	"strconv"
 )
 
 var sessionLimiter = SessionLimiter{}
@@ -65,6 +66,15 @@ func (k *RateLimitAndQuotaCheck) ProcessRequest(w http.ResponseWriter, r *http.R
 			// Report in health check
 			ReportHealthCheckValue(k.Spec.Health, Throttle, "-1")
 
+			// Token bucket and GCRA limiters know when the next request will be allowed
+			if thisSessionState.rateLimitResult != nil {
+				limit, remaining, reset := thisSessionState.RateLimitHeaderValues()
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(limit)))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+				w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(reset)))
+				w.Header().Set("Retry-After", strconv.Itoa(int(thisSessionState.rateLimitResult.RetryAfter)))
+			}
+
 			return errors.New("Rate limit exceeded"), 429
 
 		} else if reason == 2 {
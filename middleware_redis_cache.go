@@ -115,6 +115,7 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 			retBlob, found := m.CacheStore.GetKey(thisKey)
 			if found != nil {
 				log.Debug("Cache enabled, but record not found")
+				GatewayMetrics.CacheMisses.Inc(m.Spec.APIID)
 				// Pass through to proxy AND CACHE RESULT
 
 				reqVal := new(http.Response)
@@ -182,6 +183,7 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 
 			}
 
+			GatewayMetrics.CacheHits.Inc(m.Spec.APIID)
 			retObj := bytes.NewReader([]byte(retBlob))
 			log.Debug("Cache got: ", retBlob)
 
@@ -203,9 +205,10 @@ func (m *RedisCacheMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 			// Only add ratelimit data to keyed sessions
 			if sessObj != nil {
 				thisSessionState = sessObj.(SessionState)
-				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(thisSessionState.QuotaMax)))
-				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(thisSessionState.QuotaRemaining)))
-				w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(thisSessionState.QuotaRenews)))
+				limit, remaining, reset := thisSessionState.RateLimitHeaderValues()
+				w.Header().Set("X-RateLimit-Limit", strconv.Itoa(int(limit)))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+				w.Header().Set("X-RateLimit-Reset", strconv.Itoa(int(reset)))
 			}
 			w.Header().Add("x-tyk-cached-response", "1")
 			w.WriteHeader(newRes.StatusCode)
@@ -38,6 +38,9 @@ func (v *VersionCheck) DoMockReply(w http.ResponseWriter, meta interface{}) {
 
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
 func (v *VersionCheck) ProcessRequest(w http.ResponseWriter, r *http.Request, configuration interface{}) (error, int) {
+	// Canary releases pick the version for requests that don't ask for one
+	v.TykMiddleware.Spec.setCanaryVersion(w, r)
+
 	// Check versioning, blacklist, whitelist and ignored status
 	requestValid, stat, meta := v.TykMiddleware.Spec.IsRequestValid(r)
 	if requestValid == false {
@@ -286,9 +286,10 @@ func (d *VirtualEndpoint) HandleResponse(rw http.ResponseWriter, res *http.Respo
 	// Add resource headers
 	if ses != nil {
 		// We have found a session, lets report back
-		res.Header.Add("X-RateLimit-Limit", strconv.Itoa(int(ses.QuotaMax)))
-		res.Header.Add("X-RateLimit-Remaining", strconv.Itoa(int(ses.QuotaRemaining)))
-		res.Header.Add("X-RateLimit-Reset", strconv.Itoa(int(ses.QuotaRenews)))
+		limit, remaining, reset := ses.RateLimitHeaderValues()
+		res.Header.Add("X-RateLimit-Limit", strconv.Itoa(int(limit)))
+		res.Header.Add("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		res.Header.Add("X-RateLimit-Reset", strconv.Itoa(int(reset)))
 	}
 
 	copyHeader(rw.Header(), res.Header)
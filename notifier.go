@@ -12,6 +12,7 @@ const (
 	NoticeApiAdded      NotificationCommand = "ApiAdded"
 	NoticeGroupReload   NotificationCommand = "GroupReload"
 	NoticePolicyChanged NotificationCommand = "PolicyChanged"
+	NoticeCanaryUpdated NotificationCommand = "CanaryUpdated"
 )
 
 // Notification is a type that encodes a message published to a pub sub channel
@@ -27,7 +28,7 @@ type Notifier interface {
 
 // RedisNotifier implements Notifier and will use redis pub/sub channles to send notifications
 type RedisNotifier struct {
-	store   *RedisClusterStorageManager
+	store   ClusterStorageHandler
 	channel string
 }
 
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -180,6 +181,13 @@ func (o *OAuthHandlers) HandleAuthorizePassthrough(w http.ResponseWriter, r *htt
 			buffer.WriteString(r.FormValue("redirect_uri"))
 			buffer.WriteString("&response_type=")
 			buffer.WriteString(r.FormValue("response_type"))
+			if r.FormValue("code_challenge") != "" {
+				// The resource provider has to send these on when it approves the request
+				buffer.WriteString("&code_challenge=")
+				buffer.WriteString(r.FormValue("code_challenge"))
+				buffer.WriteString("&code_challenge_method=")
+				buffer.WriteString(r.FormValue("code_challenge_method"))
+			}
 			w.Header().Add("Location", buffer.String())
 		} else {
 			w.Header().Add("Location", o.Manager.API.Oauth2Meta.AuthorizeLoginRedirect)
@@ -255,6 +263,60 @@ func (o *OAuthHandlers) HandleAccessRequest(w http.ResponseWriter, r *http.Reque
 	fmt.Fprintf(w, string(responseMessage))
 }
 
+// HandleIntrospection handles an RFC 7662 token introspection request from a registered client,
+// this lets resource servers check tokens that were issued by the gateway
+func (o *OAuthHandlers) HandleIntrospection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		fmt.Fprintf(w, string(createError("Method not supported")))
+		return
+	}
+
+	if _, ok := o.Manager.authenticateClient(r); !ok {
+		writeOAuthError(w, 401, "invalid_client")
+		return
+	}
+
+	responseMessage, _ := json.Marshal(o.Manager.IntrospectToken(r.FormValue("token"), r.FormValue("token_type_hint")))
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(200)
+	fmt.Fprintf(w, string(responseMessage))
+}
+
+// HandleRevocation handles an RFC 7009 token revocation request, unknown tokens are not an error
+func (o *OAuthHandlers) HandleRevocation(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.WriteHeader(405)
+		fmt.Fprintf(w, string(createError("Method not supported")))
+		return
+	}
+
+	clientID, ok := o.Manager.authenticateClient(r)
+	if !ok {
+		writeOAuthError(w, 401, "invalid_client")
+		return
+	}
+
+	token := r.FormValue("token")
+	if token == "" {
+		writeOAuthError(w, 400, "invalid_request")
+		return
+	}
+
+	o.Manager.RevokeToken(clientID, token, r.FormValue("token_type_hint"))
+	w.WriteHeader(200)
+}
+
+func writeOAuthError(w http.ResponseWriter, code int, oauthError string) {
+	responseMessage, _ := json.Marshal(map[string]string{"error": oauthError})
+	if code == 401 {
+		w.Header().Set("WWW-Authenticate", "Basic realm=\"oauth\"")
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(code)
+	fmt.Fprintf(w, string(responseMessage))
+}
+
 // OAuthManager handles and wraps osin OAuth2 functions to handle authorise and access requests
 type OAuthManager struct {
 	API        *APISpec
@@ -266,12 +328,28 @@ func (o *OAuthManager) HandleAuthorisation(r *http.Request, complete bool, sessi
 	resp := o.OsinServer.NewResponse()
 
 	if ar := o.OsinServer.HandleAuthorizeRequest(resp, r); ar != nil {
+		challenge, err := o.checkCodeChallenge(r, ar)
+		if err != nil {
+			log.Warning("[OAuth] Rejected authorize request: ", err)
+			resp.SetError(osin.E_INVALID_REQUEST, err.Error())
+			return resp
+		}
+
 		// Since this is called by the Reource provider (proxied API), we assume it has been approved
 		ar.Authorized = true
 
 		if complete {
 			ar.UserData = sessionState
 			o.OsinServer.FinishAuthorizeRequest(resp, r, ar)
+
+			authCode, codeIssued := resp.Output["code"].(string)
+			if challenge != nil && codeIssued && !resp.IsError {
+				if err := o.OsinServer.Storage.SavePKCE(authCode, challenge, int64(ar.Expiration)); err != nil {
+					log.Error("[OAuth] Couldn't store PKCE challenge: ", err)
+					resp.SetError(osin.E_SERVER_ERROR, "")
+					return resp
+				}
+			}
 		}
 	}
 	if resp.IsError && resp.InternalError != nil {
@@ -281,6 +359,50 @@ func (o *OAuthManager) HandleAuthorisation(r *http.Request, complete bool, sessi
 	return resp
 }
 
+// checkCodeChallenge validates the PKCE parameters of an authorize request, public clients must send
+// a challenge for the code flow if the API requires PKCE
+func (o *OAuthManager) checkCodeChallenge(r *http.Request, ar *osin.AuthorizeRequest) (*PKCEChallenge, error) {
+	if ar.Type != osin.CODE {
+		return nil, nil
+	}
+
+	challenge, err := newPKCEChallenge(r.FormValue("code_challenge"), r.FormValue("code_challenge_method"))
+	if err != nil {
+		return nil, err
+	}
+
+	if challenge == nil && o.API.PKCE.RequirePKCE && ar.Client.GetSecret() == "" {
+		return nil, errors.New("code_challenge is required for public clients")
+	}
+
+	return challenge, nil
+}
+
+// checkCodeVerifier verifies the code_verifier of an auth code exchange if a challenge was sent when
+// the code was issued
+func (o *OAuthManager) checkCodeVerifier(r *http.Request, code string) bool {
+	challenge, err := o.OsinServer.Storage.LoadPKCE(code)
+	if _, notFound := err.(KeyError); notFound {
+		// No challenge was stored, public clients on APIs that require PKCE can't get a code without one
+		return r.FormValue("code_verifier") == ""
+	}
+
+	if err != nil {
+		// The challenge can't be checked, so the exchange is refused rather than letting it through without one
+		log.Error("Couldn't load PKCE challenge: ", err)
+		return false
+	}
+
+	// The challenge is left to expire with the code, so the code can never be exchanged without a verifier
+	if !challenge.Verify(r.FormValue("code_verifier")) {
+		// osin keeps the code when the exchange fails, so it is burned here or it could be retried
+		o.OsinServer.Storage.RemoveAuthorize(code)
+		return false
+	}
+
+	return true
+}
+
 // HandleAccess wraps an access request with osin's primitives
 func (o *OAuthManager) HandleAccess(r *http.Request) *osin.Response {
 	resp := o.OsinServer.NewResponse()
@@ -337,6 +459,23 @@ func (o *OAuthManager) HandleAccess(r *http.Request) *osin.Response {
 					//log.Warning("Old Keys: ", thisSessionState.OauthKeys)
 				}
 			}
+		} else if ar.Type == osin.AUTHORIZATION_CODE {
+			if o.checkCodeVerifier(r, ar.Code) {
+				ar.Authorized = true
+			} else {
+				log.Warning("[OAuth] Attempted auth code exchange with an invalid code_verifier")
+			}
+		} else if ar.Type == osin.CLIENT_CREDENTIALS {
+			// Machine to machine, the session is generated from the client's policy
+			policyClient, ok := ar.Client.(interface {
+				GetPolicyID() string
+			})
+			if ok && policyClient.GetPolicyID() != "" {
+				ar.Authorized = true
+				ar.GenerateRefresh = false
+			} else {
+				log.Warning("Attempted client credentials access by a client without a policy: ", ar.Client.GetId())
+			}
 		} else {
 			// Using a manual flow
 			ar.Authorized = true
@@ -387,6 +526,130 @@ func (o *OAuthManager) HandleAccess(r *http.Request) *osin.Response {
 	return resp
 }
 
+// Token type hints for introspection and revocation requests
+const (
+	AccessTokenTypeHint  string = "access_token"
+	RefreshTokenTypeHint string = "refresh_token"
+)
+
+// authenticateClient checks the client credentials sent with basic auth or in the form body
+func (o *OAuthManager) authenticateClient(r *http.Request) (string, bool) {
+	clientID, clientSecret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.FormValue("client_id")
+		clientSecret = r.FormValue("client_secret")
+	}
+
+	if clientID == "" {
+		return "", false
+	}
+
+	client, err := o.OsinServer.Storage.GetClient(clientID)
+	if err != nil || client.GetId() != clientID {
+		log.Warning("[OAuth] Attempted token management with unknown client: ", clientID)
+		return "", false
+	}
+
+	if subtle.ConstantTimeCompare([]byte(client.GetSecret()), []byte(clientSecret)) != 1 {
+		log.Warning("[OAuth] Attempted token management with invalid client secret: ", clientID)
+		return "", false
+	}
+
+	return clientID, true
+}
+
+// loadToken finds the access data for an access or refresh token, the hint is only used to decide
+// which one is tried first. The second return value is true for refresh tokens
+func (o *OAuthManager) loadToken(token, hint string) (*osin.AccessData, bool) {
+	if token == "" {
+		return nil, false
+	}
+
+	if hint != RefreshTokenTypeHint {
+		if accessData, err := o.OsinServer.Storage.LoadAccess(token); err == nil {
+			return accessData, false
+		}
+	}
+
+	if accessData, err := o.OsinServer.Storage.LoadRefresh(token); err == nil {
+		return accessData, true
+	}
+
+	if hint == RefreshTokenTypeHint {
+		if accessData, err := o.OsinServer.Storage.LoadAccess(token); err == nil {
+			return accessData, false
+		}
+	}
+
+	return nil, false
+}
+
+// IntrospectToken returns the RFC 7662 introspection response for a token
+func (o *OAuthManager) IntrospectToken(token, hint string) map[string]interface{} {
+	inactive := map[string]interface{}{"active": false}
+
+	accessData, isRefresh := o.loadToken(token, hint)
+	if accessData == nil {
+		return inactive
+	}
+
+	introspection := map[string]interface{}{
+		"active":    true,
+		"client_id": accessData.Client.GetId(),
+		"iat":       accessData.CreatedAt.Unix(),
+	}
+
+	if accessData.Scope != "" {
+		introspection["scope"] = accessData.Scope
+	}
+
+	if isRefresh {
+		return introspection
+	}
+
+	// The session is the source of truth for access tokens, it may have been removed or changed since the token was issued
+	thisSession, found := o.API.SessionManager.GetSessionDetail(token)
+	if !found || thisSession.IsInactive {
+		return inactive
+	}
+
+	if thisSession.Expires > 0 {
+		if thisSession.Expires < time.Now().Unix() {
+			return inactive
+		}
+		introspection["exp"] = thisSession.Expires
+	}
+
+	introspection["token_type"] = "bearer"
+	if thisSession.Alias != "" {
+		introspection["username"] = thisSession.Alias
+	}
+
+	return introspection
+}
+
+// RevokeToken removes the access and refresh entries for a token and its session, tokens that were
+// issued to other clients are left alone
+func (o *OAuthManager) RevokeToken(clientID, token, hint string) {
+	accessData, _ := o.loadToken(token, hint)
+	if accessData == nil {
+		log.Debug("[OAuth] Revocation requested for unknown token")
+		return
+	}
+
+	if accessData.Client.GetId() != clientID {
+		log.Warning("[OAuth] Client attempted to revoke a token issued to another client: ", clientID)
+		return
+	}
+
+	if accessData.RefreshToken != "" {
+		o.OsinServer.Storage.RemoveRefresh(accessData.RefreshToken)
+	}
+
+	// RemoveAccess also removes the session
+	o.OsinServer.Storage.RemoveAccess(accessData.AccessToken)
+}
+
 // These enums fix the prefix to use when storing various OAuth keys and data, since we
 // delegate everything to the osin framework
 const (
@@ -456,6 +719,15 @@ type ExtendedOsinStorageInterface interface {
 
 	// SetUser updates a Basic Access user token type in the key store
 	SetUser(string, *SessionState, int64) error
+
+	// SavePKCE stores the PKCE challenge sent with an authorize request against its auth code
+	SavePKCE(code string, challenge *PKCEChallenge, expiresIn int64) error
+
+	// LoadPKCE retrieves the PKCE challenge for an auth code
+	LoadPKCE(code string) (*PKCEChallenge, error)
+
+	// RemovePKCE deletes the PKCE challenge for an auth code
+	RemovePKCE(code string) error
 }
 
 // TykOsinServer subclasses osin.Server so we can add the SetClient method without wrecking the lbrary
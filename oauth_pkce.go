@@ -0,0 +1,131 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+// PKCE (RFC 7636) challenge methods
+const (
+	PKCEMethodPlain string = "plain"
+	PKCEMethodS256  string = "S256"
+
+	PKCE_PREFIX string = "oauth-pkce."
+)
+
+// PKCEConfig sets whether public clients (clients without a secret) must use PKCE on this API
+type PKCEConfig struct {
+	RequirePKCE bool `mapstructure:"oauth_require_pkce" bson:"oauth_require_pkce" json:"oauth_require_pkce"`
+}
+
+// getPKCEConfig decodes the PKCE options from the raw API definition
+func getPKCEConfig(rawData map[string]interface{}) PKCEConfig {
+	var thisConfig PKCEConfig
+	if rawData == nil {
+		return thisConfig
+	}
+
+	if !decodeRawConfig(rawData, "PKCE options", &thisConfig) {
+		return PKCEConfig{}
+	}
+
+	return thisConfig
+}
+
+// PKCEChallenge is stored against an auth code until it is exchanged for a token
+type PKCEChallenge struct {
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// newPKCEChallenge validates the challenge sent with an authorize request, the method defaults to plain
+func newPKCEChallenge(challenge, method string) (*PKCEChallenge, error) {
+	if challenge == "" {
+		if method != "" {
+			return nil, errors.New("code_challenge_method sent without a code_challenge")
+		}
+		return nil, nil
+	}
+
+	if method == "" {
+		method = PKCEMethodPlain
+	}
+
+	if method != PKCEMethodPlain && method != PKCEMethodS256 {
+		return nil, errors.New("Unsupported code_challenge_method: " + method)
+	}
+
+	if !validPKCEString(challenge) {
+		return nil, errors.New("Invalid code_challenge")
+	}
+
+	return &PKCEChallenge{CodeChallenge: challenge, CodeChallengeMethod: method}, nil
+}
+
+// validPKCEString checks the length and character set the RFC requires for verifiers and challenges
+func validPKCEString(value string) bool {
+	if len(value) < 43 || len(value) > 128 {
+		return false
+	}
+
+	for _, b := range []byte(value) {
+		if !((b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '.' || b == '_' || b == '~') {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Verify checks a code_verifier against the stored challenge
+func (p *PKCEChallenge) Verify(verifier string) bool {
+	if !validPKCEString(verifier) {
+		return false
+	}
+
+	expected := verifier
+	if p.CodeChallengeMethod == PKCEMethodS256 {
+		hashed := sha256.Sum256([]byte(verifier))
+		expected = base64.RawURLEncoding.EncodeToString(hashed[:])
+	}
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(p.CodeChallenge)) == 1
+}
+
+// SavePKCE stores the PKCE challenge for an auth code, it should expire with the code
+func (r RedisOsinStorageInterface) SavePKCE(code string, challenge *PKCEChallenge, expiresIn int64) error {
+	challengeJSON, marshalErr := json.Marshal(challenge)
+	if marshalErr != nil {
+		return marshalErr
+	}
+
+	key := PKCE_PREFIX + code
+	log.Debug("Saving PKCE challenge: ", key)
+	return r.store.SetKey(key, string(challengeJSON), expiresIn)
+}
+
+// LoadPKCE loads the PKCE challenge for an auth code
+func (r RedisOsinStorageInterface) LoadPKCE(code string) (*PKCEChallenge, error) {
+	challengeJSON, storeErr := r.store.GetKey(PKCE_PREFIX + code)
+	if storeErr != nil {
+		return nil, storeErr
+	}
+
+	challenge := PKCEChallenge{}
+	if marshalErr := json.Unmarshal([]byte(challengeJSON), &challenge); marshalErr != nil {
+		log.Error("Couldn't unmarshal PKCE challenge: ", marshalErr)
+		return nil, marshalErr
+	}
+
+	return &challenge, nil
+}
+
+// RemovePKCE removes the PKCE challenge once the auth code has been used
+func (r RedisOsinStorageInterface) RemovePKCE(code string) error {
+	r.store.DeleteKey(PKCE_PREFIX + code)
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const (
+	T_CODE_VERIFIER  string = "dBjftJeZ4CVP-mJ0OUPnEb1j8ZZRaa5yfjQw6bGc0Ao"
+	T_CODE_CHALLENGE string = "Awn30cZ7sM2hx6FoW3PckqsJ7YG1yRYx1ix4n25yHH4"
+)
+
+func TestPKCEConfigDecoded(t *testing.T) {
+	thisConfig := getPKCEConfig(map[string]interface{}{"oauth_require_pkce": true})
+	if !thisConfig.RequirePKCE {
+		t.Error("PKCE option was not decoded")
+	}
+}
+
+func TestPKCEChallengeValidation(t *testing.T) {
+	if challenge, err := newPKCEChallenge("", ""); challenge != nil || err != nil {
+		t.Error("Requests without PKCE should be allowed through")
+	}
+
+	if _, err := newPKCEChallenge("", PKCEMethodS256); err == nil {
+		t.Error("A method without a challenge should be rejected")
+	}
+
+	if _, err := newPKCEChallenge(T_CODE_CHALLENGE, "S512"); err == nil {
+		t.Error("Unknown methods should be rejected")
+	}
+
+	if _, err := newPKCEChallenge("too-short", PKCEMethodPlain); err == nil {
+		t.Error("Short challenges should be rejected")
+	}
+
+	challenge, err := newPKCEChallenge(T_CODE_VERIFIER, "")
+	if err != nil || challenge.CodeChallengeMethod != PKCEMethodPlain {
+		t.Error("Method should default to plain: ", challenge, err)
+	}
+}
+
+func TestPKCEVerify(t *testing.T) {
+	plain, _ := newPKCEChallenge(T_CODE_VERIFIER, PKCEMethodPlain)
+	if !plain.Verify(T_CODE_VERIFIER) {
+		t.Error("Plain verifier should match")
+	}
+
+	s256, _ := newPKCEChallenge(T_CODE_CHALLENGE, PKCEMethodS256)
+	if !s256.Verify(T_CODE_VERIFIER) {
+		t.Error("S256 verifier should match")
+	}
+
+	if s256.Verify(T_CODE_CHALLENGE) || s256.Verify("") {
+		t.Error("Wrong verifiers should not match")
+	}
+}
+
+func TestPKCEStorage(t *testing.T) {
+	store := &InMemoryStorageManager{KeyPrefix: "oauth-test.", db: newMemoryStore()}
+	osinStorage := RedisOsinStorageInterface{store, nil}
+
+	challenge, _ := newPKCEChallenge(T_CODE_CHALLENGE, PKCEMethodS256)
+	if err := osinStorage.SavePKCE("auth-code", challenge, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := osinStorage.LoadPKCE("auth-code")
+	if err != nil || *loaded != *challenge {
+		t.Fatal("Challenge was not stored: ", loaded, err)
+	}
+
+	osinStorage.RemovePKCE("auth-code")
+	if _, err := osinStorage.LoadPKCE("auth-code"); err == nil {
+		t.Error("Challenge should be removed")
+	}
+}
+
+func TestPKCEWrongVerifierBurnsCode(t *testing.T) {
+	store := &InMemoryStorageManager{KeyPrefix: "oauth-test.", db: newMemoryStore()}
+	osinStorage := RedisOsinStorageInterface{store, nil}
+	manager := OAuthManager{OsinServer: &TykOsinServer{Storage: osinStorage}}
+
+	challenge, _ := newPKCEChallenge(T_CODE_CHALLENGE, PKCEMethodS256)
+	osinStorage.SavePKCE("auth-code", challenge, 60)
+	store.SetKey(AUTH_PREFIX+"auth-code", "{}", 60)
+
+	wrongVerifier, _ := http.NewRequest("POST", "/oauth/token/", strings.NewReader("code_verifier="+T_CODE_CHALLENGE))
+	wrongVerifier.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if manager.checkCodeVerifier(wrongVerifier, "auth-code") {
+		t.Fatal("Wrong verifier should be rejected")
+	}
+
+	if _, err := store.GetKey(AUTH_PREFIX + "auth-code"); err == nil {
+		t.Error("Auth code should be removed after a failed verification")
+	}
+
+	noVerifier, _ := http.NewRequest("POST", "/oauth/token/", nil)
+	if manager.checkCodeVerifier(noVerifier, "auth-code") {
+		t.Error("Code with a challenge should never be exchanged without a verifier")
+	}
+}
+
+func TestPKCELoadErrorRejectsExchange(t *testing.T) {
+	store := &InMemoryStorageManager{KeyPrefix: "oauth-test.", db: newMemoryStore()}
+	osinStorage := RedisOsinStorageInterface{store, nil}
+	manager := OAuthManager{OsinServer: &TykOsinServer{Storage: osinStorage}}
+
+	noVerifier, _ := http.NewRequest("POST", "/oauth/token/", nil)
+	if !manager.checkCodeVerifier(noVerifier, "auth-code") {
+		t.Error("Codes issued without a challenge should be exchanged without a verifier")
+	}
+
+	// A challenge that can't be read must not be treated as no challenge
+	store.SetKey(PKCE_PREFIX+"auth-code", "not-a-challenge", 60)
+	if manager.checkCodeVerifier(noVerifier, "auth-code") {
+		t.Error("Exchange should be rejected when the challenge can't be loaded")
+	}
+}
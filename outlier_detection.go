@@ -0,0 +1,231 @@
+package main
+
+import (
+	"github.com/Sirupsen/logrus"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	OutlierDefaultConsecutiveFailures int = 5
+	OutlierDefaultBaseEjectionTime    int = 30
+	OutlierDefaultMaxEjectionTime     int = 300
+	OutlierDefaultMaxEjectionPercent  int = 50
+)
+
+// OutlierDetectionConfig ejects upstream targets from load balancing after a number of consecutive 5xx
+// responses or connection errors seen by the proxy. The ejection time (in seconds) doubles every time
+// a target is ejected again and is reset once the target has stayed healthy for the maximum ejection time
+type OutlierDetectionConfig struct {
+	Enabled             bool `mapstructure:"enabled" bson:"enabled" json:"enabled"`
+	ConsecutiveFailures int  `mapstructure:"consecutive_failures" bson:"consecutive_failures" json:"consecutive_failures"`
+	BaseEjectionTime    int  `mapstructure:"base_ejection_time" bson:"base_ejection_time" json:"base_ejection_time"`
+	MaxEjectionTime     int  `mapstructure:"max_ejection_time" bson:"max_ejection_time" json:"max_ejection_time"`
+	MaxEjectionPercent  int  `mapstructure:"max_ejection_percent" bson:"max_ejection_percent" json:"max_ejection_percent"`
+}
+
+type outlierDetectionRawData struct {
+	OutlierDetection OutlierDetectionConfig `mapstructure:"outlier_detection"`
+}
+
+// getOutlierDetectionConfig decodes the passive health check options from the raw API definition
+func getOutlierDetectionConfig(rawData map[string]interface{}) OutlierDetectionConfig {
+	var thisConfig outlierDetectionRawData
+	if !decodeRawConfig(rawData, "outlier detection options", &thisConfig) {
+		return OutlierDetectionConfig{}
+	}
+
+	detection := thisConfig.OutlierDetection
+	if detection.ConsecutiveFailures == 0 {
+		detection.ConsecutiveFailures = OutlierDefaultConsecutiveFailures
+	}
+	if detection.BaseEjectionTime == 0 {
+		detection.BaseEjectionTime = OutlierDefaultBaseEjectionTime
+	}
+	if detection.MaxEjectionTime == 0 {
+		detection.MaxEjectionTime = OutlierDefaultMaxEjectionTime
+	}
+	if detection.MaxEjectionPercent == 0 {
+		detection.MaxEjectionPercent = OutlierDefaultMaxEjectionPercent
+	}
+
+	return detection
+}
+
+// outlierHost is the passive health state of one upstream target
+type outlierHost struct {
+	failures     int
+	ejections    int
+	ejected      bool
+	ejectedUntil time.Time
+}
+
+// OutlierDetector tracks proxy results per target, it is local to this gateway and complements the
+// uptime tests which are shared through Redis. A nil detector never ejects anything
+type OutlierDetector struct {
+	mu      sync.Mutex
+	spec    *APISpec
+	config  OutlierDetectionConfig
+	hosts   map[string]*outlierHost
+	targets []string
+	now     func() time.Time
+}
+
+// NewOutlierDetector returns the detector for the API, or nil if outlier detection is off
+func NewOutlierDetector(spec *APISpec) *OutlierDetector {
+	if !spec.OutlierDetection.Enabled {
+		return nil
+	}
+
+	return &OutlierDetector{
+		spec:   spec,
+		config: spec.OutlierDetection,
+		hosts:  make(map[string]*outlierHost),
+		now:    time.Now,
+	}
+}
+
+func (o *OutlierDetector) host(target string) *outlierHost {
+	thisHost, found := o.hosts[target]
+	if !found {
+		thisHost = &outlierHost{}
+		o.hosts[target] = thisHost
+	}
+	return thisHost
+}
+
+// SetTargets records the targets the load balancer is picking from, the share of targets that may be
+// ejected is worked out from these and not just from the targets that have had requests
+func (o *OutlierDetector) SetTargets(targets []string) {
+	if o == nil {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.targets = targets
+}
+
+// canEject stops the detector from taking out more than the allowed share of targets
+func (o *OutlierDetector) canEject() bool {
+	var ejected int
+	for _, target := range o.targets {
+		if thisHost, found := o.hosts[target]; found && thisHost.ejected {
+			ejected++
+		}
+	}
+
+	return (ejected+1)*100 <= o.config.MaxEjectionPercent*len(o.targets)
+}
+
+// Report records the result of a proxied request to a target
+func (o *OutlierDetector) Report(target string, res *http.Response, err error) {
+	if o == nil || target == "" {
+		return
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	thisHost := o.host(target)
+	if err == nil && res.StatusCode < 500 {
+		thisHost.failures = 0
+		return
+	}
+
+	thisHost.failures++
+	if thisHost.ejected || thisHost.failures < o.config.ConsecutiveFailures || !o.canEject() {
+		return
+	}
+
+	// Targets that stayed healthy for long enough start again with the base ejection time
+	now := o.now()
+	maxEjection := time.Duration(o.config.MaxEjectionTime) * time.Second
+	if now.Sub(thisHost.ejectedUntil) > maxEjection {
+		thisHost.ejections = 0
+	}
+
+	ejectFor := time.Duration(o.config.BaseEjectionTime) * time.Second << uint(thisHost.ejections)
+	if ejectFor > maxEjection || ejectFor <= 0 {
+		ejectFor = maxEjection
+	}
+
+	thisHost.ejections++
+	thisHost.ejected = true
+	thisHost.ejectedUntil = now.Add(ejectFor)
+	thisHost.failures = 0
+
+	var responseCode int
+	if res != nil {
+		responseCode = res.StatusCode
+	}
+
+	log.WithFields(logrus.Fields{
+		"prefix": "host-check-mgr",
+		"api_id": o.spec.APIID,
+	}).Warning("[OUTLIER DETECTION] Ejecting host for ", ejectFor, ": ", target)
+
+	go o.spec.FireEvent(EVENT_HOSTDOWN,
+		EVENT_HostStatusMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Passive health check failed"},
+			HostInfo:         o.report(target, responseCode, err != nil),
+		})
+}
+
+// IsEjected checks whether a target is out of rotation, targets are re-admitted once their ejection expires
+func (o *OutlierDetector) IsEjected(target string) bool {
+	if o == nil {
+		return false
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	thisHost, found := o.hosts[target]
+	if !found || !thisHost.ejected {
+		return false
+	}
+
+	if o.now().Before(thisHost.ejectedUntil) {
+		return true
+	}
+
+	thisHost.ejected = false
+
+	log.WithFields(logrus.Fields{
+		"prefix": "host-check-mgr",
+		"api_id": o.spec.APIID,
+	}).Info("[OUTLIER DETECTION] Re-admitting host: ", target)
+
+	go o.spec.FireEvent(EVENT_HOSTUP,
+		EVENT_HostStatusMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Host re-admitted after ejection"},
+			HostInfo:         o.report(target, 0, false),
+		})
+
+	return false
+}
+
+// report describes a target in the same way as the uptime tests do
+func (o *OutlierDetector) report(target string, responseCode int, isTCPError bool) HostHealthReport {
+	var hostName string
+	if u, err := url.Parse(EnsureTransport(target)); err == nil {
+		hostName = u.Host
+	}
+
+	return HostHealthReport{
+		HostData: HostData{
+			CheckURL: target,
+			MetaData: map[string]string{
+				UnHealthyHostMetaDataTargetKey: target,
+				UnHealthyHostMetaDataAPIKey:    o.spec.APIID,
+				UnHealthyHostMetaDataHostKey:   hostName,
+			},
+		},
+		ResponseCode: responseCode,
+		IsTCPError:   isTCPError,
+	}
+}
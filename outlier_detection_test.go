@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func createOutlierDetector(now *time.Time) *OutlierDetector {
+	spec := &APISpec{}
+	spec.APIID = "1"
+	spec.OutlierDetection = getOutlierDetectionConfig(map[string]interface{}{
+		"outlier_detection": map[string]interface{}{
+			"enabled":              true,
+			"consecutive_failures": 2,
+			"base_ejection_time":   10,
+			"max_ejection_time":    30,
+		},
+	})
+
+	detector := NewOutlierDetector(spec)
+	detector.now = func() time.Time { return *now }
+	detector.SetTargets([]string{"http://upstream-1", "http://upstream-2"})
+	return detector
+}
+
+func TestOutlierDetectionDisabled(t *testing.T) {
+	detector := NewOutlierDetector(&APISpec{})
+	if detector != nil {
+		t.Fatal("Detector should be off by default")
+	}
+
+	// A nil detector is safe to use
+	detector.Report("http://upstream-1", nil, errors.New("connection refused"))
+	if detector.IsEjected("http://upstream-1") {
+		t.Error("Nothing should be ejected when detection is off")
+	}
+}
+
+func TestOutlierDetectionEjectsAndReadmits(t *testing.T) {
+	now := time.Now()
+	detector := createOutlierDetector(&now)
+
+	ok := &http.Response{StatusCode: 200}
+	bad := &http.Response{StatusCode: 502}
+
+	detector.Report("http://upstream-1", bad, nil)
+	detector.Report("http://upstream-1", ok, nil)
+	detector.Report("http://upstream-1", nil, errors.New("connection refused"))
+	if detector.IsEjected("http://upstream-1") {
+		t.Fatal("Only consecutive failures should eject a host")
+	}
+
+	detector.Report("http://upstream-1", bad, nil)
+	if !detector.IsEjected("http://upstream-1") {
+		t.Fatal("Host should be ejected")
+	}
+
+	now = now.Add(11 * time.Second)
+	if detector.IsEjected("http://upstream-1") {
+		t.Fatal("Host should be re-admitted after the base ejection time")
+	}
+
+	// Failing again straight away doubles the ejection time
+	detector.Report("http://upstream-1", bad, nil)
+	detector.Report("http://upstream-1", bad, nil)
+	now = now.Add(11 * time.Second)
+	if !detector.IsEjected("http://upstream-1") {
+		t.Error("Ejection time should back off")
+	}
+
+	now = now.Add(10 * time.Second)
+	if detector.IsEjected("http://upstream-1") {
+		t.Error("Host should be re-admitted after the longer ejection")
+	}
+}
+
+func TestOutlierDetectionMaxEjectionPercent(t *testing.T) {
+	now := time.Now()
+	detector := createOutlierDetector(&now)
+
+	bad := &http.Response{StatusCode: 503}
+	for i := 0; i < 2; i++ {
+		detector.Report("http://upstream-1", bad, nil)
+		detector.Report("http://upstream-2", bad, nil)
+	}
+
+	if !detector.IsEjected("http://upstream-1") || detector.IsEjected("http://upstream-2") {
+		t.Error("No more than half of the hosts should be ejected")
+	}
+}
+
+func TestOutlierDetectionMaxEjectionPercentOfTargets(t *testing.T) {
+	now := time.Now()
+	detector := createOutlierDetector(&now)
+	detector.SetTargets([]string{"http://upstream-1", "http://upstream-2", "http://upstream-3", "http://upstream-4"})
+
+	// Only the failing hosts have had requests, the share is still taken from all the targets
+	bad := &http.Response{StatusCode: 503}
+	for _, target := range []string{"http://upstream-1", "http://upstream-2", "http://upstream-3"} {
+		detector.Report(target, bad, nil)
+		detector.Report(target, bad, nil)
+	}
+
+	if !detector.IsEjected("http://upstream-1") || !detector.IsEjected("http://upstream-2") || detector.IsEjected("http://upstream-3") {
+		t.Error("Half of the targets should be ejected")
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	RetryOnConnectionRefused string = "connection_refused"
+	RetryOnConnectionReset   string = "connection_reset"
+	RetryOnTimeout           string = "timeout"
+
+	RetryDefaultBackoff    int   = 50
+	RetryDefaultMaxBackoff int   = 1000
+	RetryDefaultMaxBody    int64 = 65536
+)
+
+// idempotentMethods are the only methods that are retried, anything else may have had side effects upstream
+var idempotentMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"TRACE":   true,
+	"PUT":     true,
+	"DELETE":  true,
+}
+
+// RetryPolicyConfig retries failed upstream requests on another target. MaxAttempts includes the first
+// attempt, backoff (in milliseconds) doubles with every retry up to MaxBackoff. Request bodies larger
+// than MaxBodySize are not buffered and those requests are only tried once
+type RetryPolicyConfig struct {
+	MaxAttempts   int      `mapstructure:"max_attempts" bson:"max_attempts" json:"max_attempts"`
+	Backoff       int      `mapstructure:"backoff" bson:"backoff" json:"backoff"`
+	MaxBackoff    int      `mapstructure:"max_backoff" bson:"max_backoff" json:"max_backoff"`
+	RetryOnStatus []int    `mapstructure:"retry_on_status" bson:"retry_on_status" json:"retry_on_status"`
+	RetryOnErrors []string `mapstructure:"retry_on_errors" bson:"retry_on_errors" json:"retry_on_errors"`
+	MaxBodySize   int64    `mapstructure:"max_body_size" bson:"max_body_size" json:"max_body_size"`
+}
+
+type retryPolicyRawData struct {
+	RetryPolicy RetryPolicyConfig `mapstructure:"retry_policy"`
+}
+
+// getRetryPolicyConfig decodes the retry policy from the raw API definition and sets the defaults
+func getRetryPolicyConfig(rawData map[string]interface{}) RetryPolicyConfig {
+	var thisConfig retryPolicyRawData
+	if !decodeRawConfig(rawData, "retry policy", &thisConfig) {
+		return RetryPolicyConfig{}
+	}
+
+	policy := thisConfig.RetryPolicy
+	if policy.Backoff == 0 {
+		policy.Backoff = RetryDefaultBackoff
+	}
+	if policy.MaxBackoff == 0 {
+		policy.MaxBackoff = RetryDefaultMaxBackoff
+	}
+	if policy.MaxBodySize == 0 {
+		policy.MaxBodySize = RetryDefaultMaxBody
+	}
+	if len(policy.RetryOnErrors) == 0 {
+		policy.RetryOnErrors = []string{RetryOnConnectionRefused}
+	}
+
+	return policy
+}
+
+// upstreamErrorType classifies a transport error for the retry policy
+func upstreamErrorType(err error) string {
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return RetryOnTimeout
+	}
+
+	errMsg := err.Error()
+	switch {
+	case strings.Contains(errMsg, "connection refused"):
+		return RetryOnConnectionRefused
+	case strings.Contains(errMsg, "connection reset"):
+		return RetryOnConnectionReset
+	case strings.Contains(errMsg, "timeout"):
+		return RetryOnTimeout
+	}
+
+	return ""
+}
+
+// upstreamRetry tracks the attempts for one proxied request
+type upstreamRetry struct {
+	policy   RetryPolicyConfig
+	enabled  bool
+	body     []byte
+	Attempts int
+}
+
+// newUpstreamRetry checks whether the request can be retried and buffers its body so it can be sent again
+func newUpstreamRetry(policy RetryPolicyConfig, req *http.Request) *upstreamRetry {
+	retry := &upstreamRetry{policy: policy, Attempts: 1}
+	if policy.MaxAttempts < 2 || !idempotentMethods[req.Method] || IsWebsocket(req) {
+		return retry
+	}
+
+	if req.Body == nil || req.ContentLength == 0 {
+		retry.enabled = true
+		return retry
+	}
+
+	if req.ContentLength > policy.MaxBodySize {
+		return retry
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, policy.MaxBodySize+1))
+	if err != nil || int64(len(body)) > policy.MaxBodySize {
+		// Too large to buffer, the request is sent once with what we have read put back
+		req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+		return retry
+	}
+
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	retry.body = body
+	retry.enabled = true
+	return retry
+}
+
+// resetBody gives the outbound request a fresh copy of the buffered body
+func (u *upstreamRetry) resetBody(outreq *http.Request) {
+	if u.body != nil {
+		outreq.Body = ioutil.NopCloser(bytes.NewReader(u.body))
+	}
+}
+
+// shouldRetry checks the result of the last attempt against the policy
+func (u *upstreamRetry) shouldRetry(res *http.Response, err error) bool {
+	if !u.enabled || u.Attempts >= u.policy.MaxAttempts {
+		return false
+	}
+
+	if err != nil {
+		errType := upstreamErrorType(err)
+		for _, retryOn := range u.policy.RetryOnErrors {
+			if retryOn == errType {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, status := range u.policy.RetryOnStatus {
+		if status == res.StatusCode {
+			return true
+		}
+	}
+
+	return false
+}
+
+// backoff is how long to wait before the next attempt
+func (u *upstreamRetry) backoff() time.Duration {
+	delay := u.policy.Backoff << uint(u.Attempts-1)
+	if delay > u.policy.MaxBackoff || delay <= 0 {
+		delay = u.policy.MaxBackoff
+	}
+
+	return time.Duration(delay) * time.Millisecond
+}
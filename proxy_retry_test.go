@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"github.com/gorilla/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyDecoded(t *testing.T) {
+	policy := getRetryPolicyConfig(map[string]interface{}{
+		"retry_policy": map[string]interface{}{
+			"max_attempts":    3,
+			"retry_on_status": []interface{}{502, 503},
+		},
+	})
+
+	if policy.MaxAttempts != 3 || len(policy.RetryOnStatus) != 2 {
+		t.Error("Retry policy was not decoded: ", policy)
+	}
+
+	if policy.Backoff != RetryDefaultBackoff || policy.RetryOnErrors[0] != RetryOnConnectionRefused {
+		t.Error("Defaults were not set: ", policy)
+	}
+}
+
+func TestUpstreamRetryEligibility(t *testing.T) {
+	policy := getRetryPolicyConfig(map[string]interface{}{
+		"retry_policy": map[string]interface{}{"max_attempts": 3, "max_body_size": 8},
+	})
+
+	post, _ := http.NewRequest("POST", "/v1/test", nil)
+	if newUpstreamRetry(policy, post).enabled {
+		t.Error("Non idempotent requests should not be retried")
+	}
+
+	put, _ := http.NewRequest("PUT", "/v1/test", strings.NewReader("body"))
+	retry := newUpstreamRetry(policy, put)
+	if !retry.enabled {
+		t.Fatal("Small idempotent requests should be retried")
+	}
+
+	ioutil.ReadAll(put.Body)
+	retry.resetBody(put)
+	if body, _ := ioutil.ReadAll(put.Body); string(body) != "body" {
+		t.Error("Body should be sent again on retry, got: ", string(body))
+	}
+
+	// Unknown length bodies are read up to the limit and passed on untouched if they are larger
+	large, _ := http.NewRequest("PUT", "/v1/test", nil)
+	large.Body = ioutil.NopCloser(bytes.NewBufferString("larger than the limit"))
+	large.ContentLength = -1
+	if newUpstreamRetry(policy, large).enabled {
+		t.Error("Bodies over the limit should not be retried")
+	}
+	if body, _ := ioutil.ReadAll(large.Body); string(body) != "larger than the limit" {
+		t.Error("Body should be passed on in full, got: ", string(body))
+	}
+}
+
+func TestUpstreamRetryConditions(t *testing.T) {
+	policy := getRetryPolicyConfig(map[string]interface{}{
+		"retry_policy": map[string]interface{}{
+			"max_attempts":    2,
+			"retry_on_status": []interface{}{503},
+		},
+	})
+
+	req, _ := http.NewRequest("GET", "/v1/test", nil)
+	retry := newUpstreamRetry(policy, req)
+
+	if !retry.shouldRetry(nil, errors.New("dial tcp 127.0.0.1:80: connection refused")) {
+		t.Error("Refused connections should be retried")
+	}
+	if retry.shouldRetry(nil, errors.New("dial tcp: lookup nowhere: no such host")) {
+		t.Error("Only the configured errors should be retried")
+	}
+	if !retry.shouldRetry(&http.Response{StatusCode: 503}, nil) || retry.shouldRetry(&http.Response{StatusCode: 500}, nil) {
+		t.Error("Only the configured status codes should be retried")
+	}
+
+	retry.Attempts = 2
+	if retry.shouldRetry(&http.Response{StatusCode: 503}, nil) {
+		t.Error("Attempts should be limited")
+	}
+}
+
+func TestUpstreamRetryBackoff(t *testing.T) {
+	retry := &upstreamRetry{policy: RetryPolicyConfig{Backoff: 100, MaxBackoff: 300}, Attempts: 1}
+
+	expected := []time.Duration{100, 200, 300, 300}
+	for _, delay := range expected {
+		if retry.backoff() != delay*time.Millisecond {
+			t.Error("Unexpected backoff for attempt ", retry.Attempts, ": ", retry.backoff())
+		}
+		retry.Attempts++
+	}
+}
+
+// recordingBalancer keeps the targets that were excluded and handed back
+type recordingBalancer struct {
+	LoadBalancer
+	excluded []map[string]bool
+	done     []string
+}
+
+func (b *recordingBalancer) Next(targets []string, r *http.Request, excluded map[string]bool) string {
+	thisExcluded := make(map[string]bool)
+	for target := range excluded {
+		thisExcluded[target] = true
+	}
+	b.excluded = append(b.excluded, thisExcluded)
+	return b.LoadBalancer.Next(targets, r, excluded)
+}
+
+func (b *recordingBalancer) Done(target string) {
+	b.done = append(b.done, target)
+	b.LoadBalancer.Done(target)
+}
+
+func TestProxyRetriesOnHealthyTarget(t *testing.T) {
+	received := make(chan *http.Request, 2)
+	receivedBody := make(chan string, 2)
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		receivedBody <- string(body)
+		received <- r
+	}))
+	defer healthy.Close()
+
+	// A closed server refuses connections
+	refusing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	refusing.Close()
+
+	spec := createDefinitionFromString(apiTestDef)
+	spec.Proxy.EnableLoadBalancing = true
+	// The least connections balancer starts with the second target, so the refusing one is tried first
+	spec.Proxy.TargetList = []string{healthy.URL, refusing.URL}
+	spec.LoadBalancing = LoadBalancingConfig{Strategy: LBLeastConnections}
+	spec.RetryPolicy = getRetryPolicyConfig(map[string]interface{}{
+		"retry_policy": map[string]interface{}{"max_attempts": 2, "backoff": 1},
+	})
+
+	remote, _ := url.Parse(spec.Proxy.TargetURL)
+	proxy := TykNewSingleHostReverseProxy(remote, &spec)
+	balancer := &recordingBalancer{LoadBalancer: spec.LoadBalancer}
+	spec.LoadBalancer = balancer
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("PUT", "http://gateway/v1/test", strings.NewReader("payload"))
+	req.Header.Set("version", "Default")
+	req.RemoteAddr = "127.0.0.1:1234"
+	defer context.Clear(req)
+
+	proxy.WrappedServeHTTP(recorder, req, false)
+	if recorder.Code != 200 {
+		t.Fatal("Request should succeed on the healthy target, got: ", recorder.Code)
+	}
+
+	select {
+	case upstreamReq := <-received:
+		healthyURL, _ := url.Parse(healthy.URL)
+		if upstreamReq.Host != healthyURL.Host || upstreamReq.URL.Path != "/v1/test" {
+			t.Error("Director should be run again on the original URL: ", upstreamReq.Host, upstreamReq.URL)
+		}
+		if body := <-receivedBody; body != "payload" {
+			t.Error("Body should be sent again on retry, got: ", body)
+		}
+	default:
+		t.Fatal("Healthy target was not called")
+	}
+
+	if len(received) != 0 {
+		t.Error("Healthy target should be called once")
+	}
+
+	if attempts := context.Get(req, UpstreamAttempts); attempts != 2 {
+		t.Error("Expected 2 attempts, got: ", attempts)
+	}
+
+	if len(balancer.excluded) != 2 || !balancer.excluded[1][refusing.URL] {
+		t.Error("Failed target should be excluded on retry: ", balancer.excluded)
+	}
+
+	// Every target that was picked has been handed back to the balancer
+	if len(balancer.done) != 2 || balancer.done[0] != refusing.URL || balancer.done[1] != healthy.URL {
+		t.Error("Balancer should be told each attempt is done: ", balancer.done)
+	}
+}
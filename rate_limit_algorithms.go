@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"github.com/garyburd/redigo/redis"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Rate limit algorithms that can be set on a session or policy, the rolling window is
+// the default and is handled directly by SessionLimiter
+const (
+	RateLimitRollingWindow string = "rolling_window"
+	RateLimitTokenBucket   string = "token_bucket"
+	RateLimitGCRA          string = "gcra"
+)
+
+var ErrRateLimitAlgorithmNotSupported = errors.New("Rate limit algorithm not supported by this storage handler")
+
+// RateLimitResult is the outcome of a single rate limit check
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int64
+	Remaining  int64
+	Reset      int64 // Unix time at which the limiter is fully replenished
+	RetryAfter int64 // Seconds until the next request would be allowed
+}
+
+// tokenBucketScriptSource keeps the bucket level and last refill time in a hash, the bucket
+// refills continuously at ARGV[2] tokens per ms up to a capacity of ARGV[1].
+// Returns {allowed, remaining, ms until full, ms until next token}
+const tokenBucketScriptSource = `
+local capacity = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = capacity
+	ts = now
+end
+
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * refill)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / refill)
+end
+
+local full = math.ceil((capacity - tokens) / refill)
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], full + 1000)
+
+return {allowed, math.floor(tokens), full, retry}
+`
+
+// gcraScriptSource stores the theoretical arrival time (TAT) of the next request, ARGV[1] is the
+// emission interval in ms and ARGV[2] the burst tolerance in ms.
+// Returns {allowed, remaining, ms until full, ms until next request is allowed}
+const gcraScriptSource = `
+local emission = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local newTat = tat + emission
+local allowAt = newTat - tolerance
+if now < allowAt then
+	return {0, 0, math.ceil(tat - now), math.ceil(allowAt - now)}
+end
+
+redis.call("SET", KEYS[1], tostring(newTat), "PX", math.ceil(newTat - now))
+return {1, math.floor((tolerance - (newTat - now)) / emission), math.ceil(newTat - now), 0}
+`
+
+var tokenBucketScript = redis.NewScript(1, tokenBucketScriptSource)
+var gcraScript = redis.NewScript(1, gcraScriptSource)
+
+// scriptSHA is used to call scripts with EVALSHA where we can't use redis.Script directly
+func scriptSHA(source string) string {
+	h := sha1.Sum([]byte(source))
+	return hex.EncodeToString(h[:])
+}
+
+// rateLimitBurst defaults the burst to the rate, which behaves the same as a rolling window of
+// Rate requests Per seconds
+func rateLimitBurst(rate float64, burst float64) float64 {
+	if burst <= 0 {
+		return rate
+	}
+
+	return burst
+}
+
+// checkRateLimitArgs makes sure the algorithm can be run with the given rate and per
+func checkRateLimitArgs(algorithm string, rate float64, per float64) error {
+	if rate <= 0 || per <= 0 {
+		return errors.New("Rate and per must be set for rate limiting")
+	}
+
+	if algorithm != RateLimitTokenBucket && algorithm != RateLimitGCRA {
+		return ErrRateLimitAlgorithmNotSupported
+	}
+
+	return nil
+}
+
+// getRateLimitScript returns the script, source and arguments for a rate limit algorithm
+func getRateLimitScript(algorithm string, rate float64, per float64, burst float64, now time.Time) (*redis.Script, string, []interface{}, error) {
+	if err := checkRateLimitArgs(algorithm, rate, per); err != nil {
+		return nil, "", nil, err
+	}
+
+	burst = rateLimitBurst(rate, burst)
+	nowMS := now.UnixNano() / int64(time.Millisecond)
+
+	switch algorithm {
+	case RateLimitTokenBucket:
+		refillPerMS := rate / (per * 1000)
+		return tokenBucketScript, tokenBucketScriptSource, []interface{}{
+			strconv.FormatFloat(burst, 'f', -1, 64),
+			strconv.FormatFloat(refillPerMS, 'f', -1, 64),
+			nowMS,
+		}, nil
+	case RateLimitGCRA:
+		emissionMS := (per * 1000) / rate
+		return gcraScript, gcraScriptSource, []interface{}{
+			strconv.FormatFloat(emissionMS, 'f', -1, 64),
+			strconv.FormatFloat(emissionMS*burst, 'f', -1, 64),
+			nowMS,
+		}, nil
+	}
+
+	return nil, "", nil, ErrRateLimitAlgorithmNotSupported
+}
+
+// parseRateLimitReply converts the script output into a RateLimitResult
+func parseRateLimitReply(reply interface{}, err error, limit float64, now time.Time) (RateLimitResult, error) {
+	replyValues, err := redis.Values(reply, err)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	if len(replyValues) < 4 {
+		return RateLimitResult{}, errors.New("Unexpected rate limit script reply")
+	}
+
+	values := make([]int64, len(replyValues))
+	for i, v := range replyValues {
+		if values[i], err = redis.Int64(v, nil); err != nil {
+			return RateLimitResult{}, err
+		}
+	}
+
+	return RateLimitResult{
+		Allowed:    values[0] == 1,
+		Limit:      int64(limit),
+		Remaining:  values[1],
+		Reset:      now.Add(time.Duration(values[2]) * time.Millisecond).Unix(),
+		RetryAfter: int64(math.Ceil(float64(values[3]) / 1000)),
+	}, nil
+}
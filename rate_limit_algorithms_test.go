@@ -0,0 +1,156 @@
+package main
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestGetRateLimitScriptArgs(t *testing.T) {
+	now := time.Unix(1000, 0)
+
+	_, _, args, err := getRateLimitScript(RateLimitTokenBucket, 10, 1, 20, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != "20" || args[1] != "0.01" || args[2] != int64(1000000) {
+		t.Error("Unexpected token bucket arguments: ", args)
+	}
+
+	_, _, args, err = getRateLimitScript(RateLimitGCRA, 10, 1, 0, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != "100" || args[1] != "1000" {
+		t.Error("GCRA burst should default to the rate, got: ", args)
+	}
+
+	if _, _, _, err := getRateLimitScript(RateLimitRollingWindow, 10, 1, 0, now); err != ErrRateLimitAlgorithmNotSupported {
+		t.Error("Rolling window should not have a script")
+	}
+
+	if _, _, _, err := getRateLimitScript(RateLimitGCRA, 0, 1, 0, now); err == nil {
+		t.Error("A zero rate should be rejected")
+	}
+}
+
+func TestParseRateLimitReply(t *testing.T) {
+	now := time.Unix(1000, 0)
+	reply := []interface{}{int64(0), int64(0), int64(5000), int64(1500)}
+
+	result, err := parseRateLimitReply(reply, nil, 10, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Allowed {
+		t.Error("Request should have been blocked")
+	}
+
+	if result.Reset != 1005 || result.RetryAfter != 2 || result.Limit != 10 {
+		t.Error("Unexpected result: ", result)
+	}
+
+	if _, err := parseRateLimitReply([]interface{}{int64(1)}, nil, 10, now); err == nil {
+		t.Error("Short reply should fail")
+	}
+}
+
+// checkRateLimitRun runs the in-memory version of the limiter scripts a number of times at the same
+// instant and checks how many requests get through
+func checkRateLimitRun(t *testing.T, entry *storageEntry, algorithm string, now time.Time, allowed int) RateLimitResult {
+	var result RateLimitResult
+	for i := 0; i <= allowed; i++ {
+		result, _ = parseRateLimitReply(entry.rateLimit(algorithm, 10, 1, 5, now), nil, 5, now)
+		if i < allowed && !result.Allowed {
+			t.Fatal(algorithm, " request should be allowed: ", i, result)
+		}
+	}
+
+	if result.Allowed {
+		t.Fatal(algorithm, " request should be blocked after ", allowed, " requests")
+	}
+
+	return result
+}
+
+func TestRateLimitAlgorithmsBurstAndRefill(t *testing.T) {
+	for _, algorithm := range []string{RateLimitTokenBucket, RateLimitGCRA} {
+		entry := &storageEntry{}
+		now := time.Unix(1000, 0)
+
+		// 10 requests a second with a burst of 5, the burst is allowed straight away
+		result := checkRateLimitRun(t, entry, algorithm, now, 5)
+		if result.RetryAfter != 1 || result.Remaining != 0 {
+			t.Error(algorithm, " blocked request should wait for the next slot: ", result)
+		}
+
+		// One request is earned back every 100ms
+		checkRateLimitRun(t, entry, algorithm, now.Add(250*time.Millisecond), 2)
+
+		// Waiting for the burst to refill allows a full burst again, but no more
+		checkRateLimitRun(t, entry, algorithm, now.Add(5*time.Second), 5)
+	}
+}
+
+// TestRateLimitScriptsMatchStorageEntry runs the same requests through the redis scripts and the
+// in-memory version used by the embedded storage handlers, set TYK_TEST_REDIS to the redis address
+// to use if it is not running locally
+func TestRateLimitScriptsMatchStorageEntry(t *testing.T) {
+	address := os.Getenv("TYK_TEST_REDIS")
+	if address == "" {
+		address = "localhost:6379"
+	}
+
+	conn, err := redis.DialTimeout("tcp", address, time.Second, time.Second, time.Second)
+	if err != nil {
+		t.Skip("Redis is not available: ", err)
+	}
+	defer conn.Close()
+
+	start := time.Unix(1000, 0)
+	offsets := []time.Duration{0, 0, 0, 0, 0, 0, 250, 250, 250, 320, 5000, 5000, 5000, 5000, 5000, 5000, 5050, 5100}
+
+	for _, algorithm := range []string{RateLimitTokenBucket, RateLimitGCRA} {
+		key := "tyk-test-rate-limit-" + algorithm
+		conn.Do("DEL", key)
+
+		entry := &storageEntry{}
+		for i, offset := range offsets {
+			now := start.Add(offset * time.Millisecond)
+			script, _, args, err := getRateLimitScript(algorithm, 10, 1, 5, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			fromRedis, err := redis.Int64s(script.Do(conn, append([]interface{}{key}, args...)...))
+			if err != nil {
+				t.Fatal(algorithm, " script failed: ", err)
+			}
+
+			fromEntry, _ := redis.Int64s(entry.rateLimit(algorithm, 10, 1, 5, now), nil)
+			for j := range fromRedis {
+				if fromRedis[j] != fromEntry[j] {
+					t.Fatal(algorithm, " request ", i, " differs, redis: ", fromRedis, " in-memory: ", fromEntry)
+				}
+			}
+		}
+		conn.Do("DEL", key)
+	}
+}
+
+func TestRateLimitHeaderValues(t *testing.T) {
+	thisSession := createSampleSession()
+
+	limit, remaining, reset := thisSession.RateLimitHeaderValues()
+	if limit != thisSession.QuotaMax || remaining != thisSession.QuotaRemaining || reset != thisSession.QuotaRenews {
+		t.Error("Headers should reflect the quota when no limiter result is set")
+	}
+
+	thisSession.rateLimitResult = &RateLimitResult{Limit: 20, Remaining: 19, Reset: 1234}
+	limit, remaining, reset = thisSession.RateLimitHeaderValues()
+	if limit != 20 || remaining != 19 || reset != 1234 {
+		t.Error("Headers should reflect the limiter result")
+	}
+}
@@ -6,12 +6,24 @@ import (
 	"github.com/lonelycode/redigocluster/rediscluster"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 // ------------------- REDIS CLUSTER STORAGE MANAGER -------------------------------
 
-var redisClusterSingleton *rediscluster.RedisCluster
+var (
+	redisClusterSingleton *rediscluster.RedisCluster
+	redisClusterMu        sync.RWMutex
+)
+
+// currentRedisCluster returns the pool in use, it is replaced when the pool is rebuilt (e.g. after a sentinel failover)
+func currentRedisCluster() *rediscluster.RedisCluster {
+	redisClusterMu.RLock()
+	defer redisClusterMu.RUnlock()
+
+	return redisClusterSingleton
+}
 
 // RedisClusterStorageManager is a storage manager that uses the redis database.
 type RedisClusterStorageManager struct {
@@ -22,13 +34,9 @@ type RedisClusterStorageManager struct {
 
 func NewRedisClusterPool(forceReconnect bool) *rediscluster.RedisCluster {
 	if !forceReconnect {
-		if redisClusterSingleton != nil {
+		if current := currentRedisCluster(); current != nil {
 			log.Debug("Redis pool already INITIALISED")
-			return redisClusterSingleton
-		}
-	} else {
-		if redisClusterSingleton != nil {
-			redisClusterSingleton.CloseConnection()
+			return current
 		}
 	}
 
@@ -48,6 +56,10 @@ func NewRedisClusterPool(forceReconnect bool) *rediscluster.RedisCluster {
 		log.Info("--> Using clustered mode")
 	}
 
+	if config.Storage.EnableSentinel {
+		log.Info("--> Using sentinel mode, master is: ", redisServerAddress())
+	}
+
 	thisPoolConf := rediscluster.PoolConfig{
 		MaxIdle:     maxIdle,
 		MaxActive:   maxActive,
@@ -59,7 +71,9 @@ func NewRedisClusterPool(forceReconnect bool) *rediscluster.RedisCluster {
 
 	seed_redii := []map[string]string{}
 
-	if len(config.Storage.Hosts) > 0 {
+	if config.Storage.EnableSentinel {
+		seed_redii = append(seed_redii, redisHostPort(redisServerAddress()))
+	} else if len(config.Storage.Hosts) > 0 {
 		for h, p := range config.Storage.Hosts {
 			seed_redii = append(seed_redii, map[string]string{h: p})
 		}
@@ -69,7 +83,16 @@ func NewRedisClusterPool(forceReconnect bool) *rediscluster.RedisCluster {
 
 	thisInstance := rediscluster.NewRedisCluster(seed_redii, thisPoolConf, false)
 
+	// Storage managers read the pool through currentRedisCluster, so the old one can be closed once the
+	// new one is published
+	redisClusterMu.Lock()
+	oldInstance := redisClusterSingleton
 	redisClusterSingleton = &thisInstance
+	redisClusterMu.Unlock()
+
+	if forceReconnect && oldInstance != nil {
+		oldInstance.CloseConnection()
+	}
 
 	return &thisInstance
 }
@@ -83,13 +106,21 @@ func (r *RedisClusterStorageManager) Connect() bool {
 	}
 
 	log.Debug("Storage Engine already initialised...")
-	log.Debug("Redis handles: ", len(r.db.Handles))
+	log.Debug("Redis handles: ", len(r.cluster().Handles))
 
 	// Reset it just in case
-	r.db = redisClusterSingleton
+	r.db = currentRedisCluster()
 	return true
 }
 
+// cluster returns the pool to use, storage managers always follow the current pool
+func (r *RedisClusterStorageManager) cluster() *rediscluster.RedisCluster {
+	if current := currentRedisCluster(); current != nil {
+		return current
+	}
+	return r.db
+}
+
 func (r *RedisClusterStorageManager) hashKey(in string) string {
 	if !r.HashKeys {
 		// Not hashing? Return the raw key
@@ -120,11 +151,16 @@ func (r *RedisClusterStorageManager) GetKey(keyName string) (string, error) {
 	}
 	log.Debug("[STORE] Getting WAS: ", keyName)
 	log.Debug("[STORE] Getting: ", r.fixKey(keyName))
-	value, err := redis.String(r.db.Do("GET", r.fixKey(keyName)))
-	if err != nil {
+	value, err := redis.String(r.cluster().Do("GET", r.fixKey(keyName)))
+	if err == redis.ErrNil {
 		log.Debug("Error trying to get value:", err)
 		return "", KeyError{}
 	}
+	if err != nil {
+		// Anything other than a missing key is passed on so that callers can tell an outage from no value
+		log.Error("Error trying to get value: ", err)
+		return "", err
+	}
 
 	return value, nil
 }
@@ -135,7 +171,7 @@ func (r *RedisClusterStorageManager) GetRawKey(keyName string) (string, error) {
 		r.Connect()
 		return r.GetRawKey(keyName)
 	}
-	value, err := redis.String(r.db.Do("GET", keyName))
+	value, err := redis.String(r.cluster().Do("GET", keyName))
 	if err != nil {
 		log.Debug("Error trying to get value:", err)
 		return "", KeyError{}
@@ -152,7 +188,7 @@ func (r *RedisClusterStorageManager) GetExp(keyName string) (int64, error) {
 		return r.GetExp(keyName)
 	}
 
-	value, err := redis.Int64(r.db.Do("TTL", r.fixKey(keyName)))
+	value, err := redis.Int64(r.cluster().Do("TTL", r.fixKey(keyName)))
 	if err != nil {
 		log.Error("Error trying to get TTL: ", err)
 	} else {
@@ -172,9 +208,9 @@ func (r *RedisClusterStorageManager) SetKey(keyName string, sessionState string,
 		r.Connect()
 		return r.SetKey(keyName, sessionState, timeout)
 	} else {
-		_, err := r.db.Do("SET", r.fixKey(keyName), sessionState)
+		_, err := r.cluster().Do("SET", r.fixKey(keyName), sessionState)
 		if timeout > 0 {
-			_, expErr := r.db.Do("EXPIRE", r.fixKey(keyName), timeout)
+			_, expErr := r.cluster().Do("EXPIRE", r.fixKey(keyName), timeout)
 			if expErr != nil {
 				log.Error("Could not EXPIRE key: ", expErr)
 				return expErr
@@ -196,9 +232,9 @@ func (r *RedisClusterStorageManager) SetRawKey(keyName string, sessionState stri
 		r.Connect()
 		return r.SetRawKey(keyName, sessionState, timeout)
 	} else {
-		_, err := r.db.Do("SET", keyName, sessionState)
+		_, err := r.cluster().Do("SET", keyName, sessionState)
 		if timeout > 0 {
-			_, expErr := r.db.Do("EXPIRE", keyName, timeout)
+			_, expErr := r.cluster().Do("EXPIRE", keyName, timeout)
 			if expErr != nil {
 				log.Error("Could not EXPIRE key: ", expErr)
 				return expErr
@@ -223,7 +259,7 @@ func (r *RedisClusterStorageManager) Decrement(keyName string) {
 		r.Connect()
 		r.Decrement(keyName)
 	} else {
-		err := r.db.Send("DECR", keyName)
+		err := r.cluster().Send("DECR", keyName)
 
 		if err != nil {
 			log.Error("Error trying to decrement value:", err)
@@ -242,11 +278,11 @@ func (r *RedisClusterStorageManager) IncrememntWithExpire(keyName string, expire
 	} else {
 		// This function uses a raw key, so we shouldn't call fixKey
 		fixedKey := keyName
-		val, err := redis.Int64(r.db.Do("INCR", fixedKey))
+		val, err := redis.Int64(r.cluster().Do("INCR", fixedKey))
 		log.Debug("Incremented key: ", fixedKey, ", val is: ", val)
 		if val == 1 {
 			log.Debug("--> Setting Expire")
-			r.db.Do("EXPIRE", fixedKey, expire)
+			r.cluster().Do("EXPIRE", fixedKey, expire)
 		}
 		if err != nil {
 			log.Error("Error trying to increment value:", err)
@@ -256,6 +292,30 @@ func (r *RedisClusterStorageManager) IncrememntWithExpire(keyName string, expire
 	return 0
 }
 
+// IncrementByWithExpire adds n to a raw counter key, setting the expiry if the key is new
+func (r *RedisClusterStorageManager) IncrementByWithExpire(keyName string, n int64, expire int64) int64 {
+	if r.db == nil {
+		log.Info("Connection dropped, connecting..")
+		r.Connect()
+		return r.IncrementByWithExpire(keyName, n, expire)
+	}
+
+	// This function uses a raw key, so we shouldn't call fixKey
+	log.Debug("Incrementing raw key: ", keyName, " by: ", n)
+	val, err := redis.Int64(r.cluster().Do("INCRBY", keyName, n))
+	if err != nil {
+		log.Error("Error trying to increment value:", err)
+		return 0
+	}
+
+	if val == n {
+		log.Debug("--> Setting Expire")
+		r.cluster().Do("EXPIRE", keyName, expire)
+	}
+
+	return val
+}
+
 // GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
 func (r *RedisClusterStorageManager) GetKeys(filter string) []string {
 	if r.db == nil {
@@ -265,7 +325,7 @@ func (r *RedisClusterStorageManager) GetKeys(filter string) []string {
 	}
 
 	searchStr := r.KeyPrefix + r.hashKey(filter) + "*"
-	sessionsInterface, err := r.db.Do("KEYS", searchStr)
+	sessionsInterface, err := r.cluster().Do("KEYS", searchStr)
 	if err != nil {
 		log.Error("Error trying to get all keys:")
 		log.Error(err)
@@ -293,14 +353,14 @@ func (r *RedisClusterStorageManager) GetKeysAndValuesWithFilter(filter string) m
 
 	searchStr := r.KeyPrefix + r.hashKey(filter) + "*"
 	log.Debug("[STORE] Getting list by: ", searchStr)
-	sessionsInterface, err := r.db.Do("KEYS", searchStr)
+	sessionsInterface, err := r.cluster().Do("KEYS", searchStr)
 	if err != nil {
 		log.Error("Error trying to get filtered client keys:")
 		log.Error(err)
 
 	} else {
 		keys, _ := redis.Strings(sessionsInterface, err)
-		valueObj, err := r.db.Do("MGET", sessionsInterface.([]interface{})...)
+		valueObj, err := r.cluster().Do("MGET", sessionsInterface.([]interface{})...)
 		values, err := redis.Strings(valueObj, err)
 
 		returnValues := make(map[string]string)
@@ -324,14 +384,14 @@ func (r *RedisClusterStorageManager) GetKeysAndValues() map[string]string {
 	}
 
 	searchStr := r.KeyPrefix + "*"
-	sessionsInterface, err := r.db.Do("KEYS", searchStr)
+	sessionsInterface, err := r.cluster().Do("KEYS", searchStr)
 	if err != nil {
 		log.Error("Error trying to get all keys:")
 		log.Error(err)
 
 	} else {
 		keys, _ := redis.Strings(sessionsInterface, err)
-		valueObj, err := r.db.Do("MGET", sessionsInterface.([]interface{})...)
+		valueObj, err := r.cluster().Do("MGET", sessionsInterface.([]interface{})...)
 		values, err := redis.Strings(valueObj, err)
 
 		returnValues := make(map[string]string)
@@ -356,7 +416,7 @@ func (r *RedisClusterStorageManager) DeleteKey(keyName string) bool {
 
 	log.Debug("DEL Key was: ", keyName)
 	log.Debug("DEL Key became: ", r.fixKey(keyName))
-	_, err := r.db.Do("DEL", r.fixKey(keyName))
+	_, err := r.cluster().Do("DEL", r.fixKey(keyName))
 	if err != nil {
 		log.Error("Error trying to delete key:")
 		log.Error(err)
@@ -374,7 +434,7 @@ func (r *RedisClusterStorageManager) DeleteRawKey(keyName string) bool {
 		return r.DeleteRawKey(keyName)
 	}
 
-	_, err := r.db.Do("DEL", keyName)
+	_, err := r.cluster().Do("DEL", keyName)
 	if err != nil {
 		log.Error("Error trying to delete key:")
 		log.Error(err)
@@ -399,7 +459,7 @@ func (r *RedisClusterStorageManager) DeleteKeys(keys []string) bool {
 		}
 
 		log.Debug("Deleting: ", asInterface)
-		_, err := r.db.Do("DEL", asInterface...)
+		_, err := r.cluster().Do("DEL", asInterface...)
 		if err != nil {
 			log.Error("Error trying to delete keys:")
 			log.Error(err)
@@ -427,7 +487,7 @@ func (r *RedisClusterStorageManager) DeleteRawKeys(keys []string, prefix string)
 		}
 
 		log.Debug("Deleting: ", asInterface)
-		_, err := r.db.Do("DEL", asInterface...)
+		_, err := r.cluster().Do("DEL", asInterface...)
 		if err != nil {
 			log.Error("Error trying to delete keys:")
 			log.Error(err)
@@ -445,12 +505,31 @@ func (r *RedisClusterStorageManager) StartPubSubHandler(channel string, callback
 		return errors.New("Redis connection failed")
 	}
 
-	handle := r.db.RandomRedisHandle()
+	handle := r.cluster().RandomRedisHandle()
 	if handle == nil {
 		return errors.New("Redis connection failed")
 	}
 
-	psc := redis.PubSubConn{r.db.RandomRedisHandle().Pool.Get()}
+	// The pool is rebuilt when sentinel moves the master, but this connection is held open so it
+	// has to be closed to make the caller subscribe again
+	masterChanged := redisMasterChangedNotify()
+
+	psc := redis.PubSubConn{r.cluster().RandomRedisHandle().Pool.Get()}
+	defer psc.Close()
+
+	if config.Storage.EnableSentinel {
+		done := make(chan struct{})
+		defer close(done)
+
+		go func() {
+			select {
+			case <-masterChanged:
+				psc.Close()
+			case <-done:
+			}
+		}()
+	}
+
 	psc.Subscribe(channel)
 	for {
 		switch v := psc.Receive().(type) {
@@ -461,6 +540,12 @@ func (r *RedisClusterStorageManager) StartPubSubHandler(channel string, callback
 			log.Debug("Subscription started: ", v.Channel)
 
 		case error:
+			select {
+			case <-masterChanged:
+				return ErrRedisMasterChanged
+			default:
+			}
+
 			log.Error("Redis disconnected or error received, attempting to reconnect: ", v)
 			return v
 		}
@@ -475,7 +560,7 @@ func (r *RedisClusterStorageManager) Publish(channel string, message string) err
 		r.Connect()
 		r.Publish(channel, message)
 	} else {
-		_, err := r.db.Do("PUBLISH", channel, message)
+		_, err := r.cluster().Do("PUBLISH", channel, message)
 		if err != nil {
 			log.Error("Error trying to set value:")
 			log.Error(err)
@@ -505,7 +590,7 @@ func (r *RedisClusterStorageManager) GetAndDeleteSet(keyName string) []interface
 		delCmd.Cmd = "DEL"
 		delCmd.Args = []interface{}{fixedKey}
 
-		redVal, err := redis.Values(r.db.DoTransaction([]rediscluster.ClusterTransaction{lrange, delCmd}))
+		redVal, err := redis.Values(r.cluster().DoTransaction([]rediscluster.ClusterTransaction{lrange, delCmd}))
 		if err != nil {
 			log.Error("Multi command failed: ", err)
 			r.Connect()
@@ -533,7 +618,7 @@ func (r *RedisClusterStorageManager) AppendToSet(keyName string, value string) {
 		r.Connect()
 		r.AppendToSet(keyName, value)
 	} else {
-		_, err := r.db.Do("RPUSH", r.fixKey(keyName), value)
+		_, err := r.cluster().Do("RPUSH", r.fixKey(keyName), value)
 
 		if err != nil {
 			log.Error("Error trying to delete keys:")
@@ -544,6 +629,32 @@ func (r *RedisClusterStorageManager) AppendToSet(keyName string, value string) {
 	}
 }
 
+// AppendToSetMulti pushes a batch of values onto a list in a single round trip
+func (r *RedisClusterStorageManager) AppendToSetMulti(keyName string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	log.Debug("Pushing batch to raw key list: ", keyName)
+	if r.db == nil {
+		log.Warning("Connection dropped, connecting..")
+		r.Connect()
+		return r.AppendToSetMulti(keyName, values)
+	}
+
+	args := make([]interface{}, len(values)+1)
+	args[0] = r.fixKey(keyName)
+	for i, v := range values {
+		args[i+1] = v
+	}
+
+	_, err := r.cluster().Do("RPUSH", args...)
+	if err != nil {
+		log.Error("Error trying to append to list: ", err)
+	}
+	return err
+}
+
 func (r *RedisClusterStorageManager) GetSet(keyName string) (map[string]string, error) {
 	log.Debug("Getting from key set: ", keyName)
 	log.Debug("Getting from fixed key set: ", r.fixKey(keyName))
@@ -552,7 +663,7 @@ func (r *RedisClusterStorageManager) GetSet(keyName string) (map[string]string,
 		r.Connect()
 		r.GetSet(keyName)
 	} else {
-		val, err := r.db.Do("SMEMBERS", r.fixKey(keyName))
+		val, err := r.cluster().Do("SMEMBERS", r.fixKey(keyName))
 		if err != nil {
 			log.Error("Error trying to get key set:", err)
 			return map[string]string{}, err
@@ -578,7 +689,7 @@ func (r *RedisClusterStorageManager) AddToSet(keyName string, value string) {
 		r.Connect()
 		r.AddToSet(keyName, value)
 	} else {
-		_, err := r.db.Do("SADD", r.fixKey(keyName), value)
+		_, err := r.cluster().Do("SADD", r.fixKey(keyName), value)
 
 		if err != nil {
 			log.Error("Error trying to append keys:")
@@ -597,7 +708,7 @@ func (r *RedisClusterStorageManager) RemoveFromSet(keyName string, value string)
 		r.Connect()
 		r.RemoveFromSet(keyName, value)
 	} else {
-		_, err := r.db.Do("SREM", r.fixKey(keyName), value)
+		_, err := r.cluster().Do("SREM", r.fixKey(keyName), value)
 
 		if err != nil {
 			log.Error("Error trying to remove keys:")
@@ -644,7 +755,7 @@ func (r *RedisClusterStorageManager) SetRollingWindow(keyName string, per int64,
 		EXPIRE.Cmd = "EXPIRE"
 		EXPIRE.Args = []interface{}{keyName, per}
 
-		redVal, err := redis.Values(r.db.DoTransaction([]rediscluster.ClusterTransaction{ZREMRANGEBYSCORE, ZRANGE, ZADD, EXPIRE}))
+		redVal, err := redis.Values(r.cluster().DoTransaction([]rediscluster.ClusterTransaction{ZREMRANGEBYSCORE, ZRANGE, ZADD, EXPIRE}))
 
 		if len(redVal) < 2 {
 			log.Error("Multi command failed: return index is out of range")
@@ -699,7 +810,7 @@ func (r *RedisClusterStorageManager) SetRollingWindowPipeline(keyName string, pe
 		EXPIRE.Cmd = "EXPIRE"
 		EXPIRE.Args = []interface{}{keyName, per}
 
-		redVal, err := redis.Values(r.db.DoPipeline([]rediscluster.ClusterTransaction{ZREMRANGEBYSCORE, ZRANGE, ZADD, EXPIRE}))
+		redVal, err := redis.Values(r.cluster().DoPipeline([]rediscluster.ClusterTransaction{ZREMRANGEBYSCORE, ZRANGE, ZADD, EXPIRE}))
 
 		intVal := len(redVal[1].([]interface{}))
 
@@ -713,3 +824,27 @@ func (r *RedisClusterStorageManager) SetRollingWindowPipeline(keyName string, pe
 	}
 	return 0, []interface{}{}
 }
+
+// SetRateLimit runs one of the atomic rate limit scripts (token bucket or GCRA) against a key
+func (r *RedisClusterStorageManager) SetRateLimit(keyName string, algorithm string, rate float64, per float64, burst float64) (RateLimitResult, error) {
+	if r.db == nil {
+		log.Info("Connection dropped, connecting..")
+		r.Connect()
+		return r.SetRateLimit(keyName, algorithm, rate, per, burst)
+	}
+
+	now := time.Now()
+	_, source, args, err := getRateLimitScript(algorithm, rate, per, burst, now)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	evalArgs := append([]interface{}{scriptSHA(source), 1, keyName}, args...)
+	reply, err := r.cluster().Do("EVALSHA", evalArgs...)
+	if err != nil && strings.HasPrefix(err.Error(), "NOSCRIPT") {
+		evalArgs[0] = source
+		reply, err = r.cluster().Do("EVAL", evalArgs...)
+	}
+
+	return parseRateLimitReply(reply, err, rateLimitBurst(rate, burst), now)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/garyburd/redigo/redis"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	SentinelSwitchMasterChannel string = "+switch-master"
+)
+
+var ErrRedisMasterChanged = errors.New("Redis master changed")
+
+// The current master as reported by sentinel, redisMasterChanged is closed and replaced whenever it
+// moves so anything holding a long lived connection (e.g. pub/sub) can reconnect
+var redisMasterAddr string
+var redisMasterChanged = make(chan struct{})
+var redisMasterLock sync.RWMutex
+
+// redisMasterConn remembers which master a pooled connection was dialled to, so connections to an
+// old master are dropped when they are borrowed
+type redisMasterConn struct {
+	redis.Conn
+	addr string
+}
+
+func currentRedisMaster() string {
+	redisMasterLock.RLock()
+	defer redisMasterLock.RUnlock()
+	return redisMasterAddr
+}
+
+// redisMasterChangedNotify returns a channel that is closed the next time the master changes
+func redisMasterChangedNotify() <-chan struct{} {
+	redisMasterLock.RLock()
+	defer redisMasterLock.RUnlock()
+	return redisMasterChanged
+}
+
+// setRedisMaster stores the master address, returning false if it has not changed
+func setRedisMaster(addr string) bool {
+	redisMasterLock.Lock()
+	defer redisMasterLock.Unlock()
+
+	if addr == redisMasterAddr {
+		return false
+	}
+
+	redisMasterAddr = addr
+	return true
+}
+
+func notifyRedisMasterChanged() {
+	redisMasterLock.Lock()
+	defer redisMasterLock.Unlock()
+
+	close(redisMasterChanged)
+	redisMasterChanged = make(chan struct{})
+}
+
+func dialSentinel(addr string) (redis.Conn, error) {
+	return redis.DialTimeout("tcp", addr, 5*time.Second, 0, 0)
+}
+
+func getMasterAddrByName(c redis.Conn, name string) (string, error) {
+	reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", name))
+	if err != nil {
+		return "", err
+	}
+
+	if len(reply) != 2 {
+		return "", errors.New("Master not known to sentinel: " + name)
+	}
+
+	return reply[0] + ":" + reply[1], nil
+}
+
+func sentinelAddresses() []string {
+	addrs := []string{}
+	for h, p := range config.Storage.SentinelHosts {
+		addrs = append(addrs, h+":"+p)
+	}
+
+	return addrs
+}
+
+// discoverRedisMaster asks each sentinel in turn for the address of the master
+func discoverRedisMaster() (string, error) {
+	for _, addr := range sentinelAddresses() {
+		c, err := dialSentinel(addr)
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "sentinel",
+			}).Warning("Could not connect to sentinel ", addr, ": ", err)
+			continue
+		}
+
+		master, err := getMasterAddrByName(c, config.Storage.SentinelMasterName)
+		c.Close()
+		if err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "sentinel",
+			}).Warning("Sentinel ", addr, " could not find master: ", err)
+			continue
+		}
+
+		return master, nil
+	}
+
+	return "", errors.New("No sentinel could provide the master address")
+}
+
+// parseSwitchMasterMessage reads a +switch-master message: <master name> <old ip> <old port> <new ip> <new port>
+func parseSwitchMasterMessage(data []byte, name string) (string, bool) {
+	fields := strings.Fields(string(data))
+	if len(fields) != 5 || fields[0] != name {
+		return "", false
+	}
+
+	return fields[3] + ":" + fields[4], true
+}
+
+// handleRedisMasterSwitch rebuilds the connection pools against the new master and then tells
+// subscribers to reconnect
+func handleRedisMasterSwitch(addr string) {
+	if !setRedisMaster(addr) {
+		return
+	}
+
+	log.WithFields(logrus.Fields{
+		"prefix": "sentinel",
+	}).Warning("Redis master changed, now using: ", addr)
+
+	NewRedisClusterPool(true)
+	notifyRedisMasterChanged()
+}
+
+// watchSentinel listens for failovers on a single sentinel until the connection fails
+func watchSentinel(addr string) error {
+	c, err := dialSentinel(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	// We may have missed a failover while we were not subscribed
+	master, err := getMasterAddrByName(c, config.Storage.SentinelMasterName)
+	if err != nil {
+		return err
+	}
+	handleRedisMasterSwitch(master)
+
+	psc := redis.PubSubConn{c}
+	if err := psc.Subscribe(SentinelSwitchMasterChannel); err != nil {
+		return err
+	}
+
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			if newMaster, ok := parseSwitchMasterMessage(v.Data, config.Storage.SentinelMasterName); ok {
+				handleRedisMasterSwitch(newMaster)
+			}
+
+		case redis.Subscription:
+			log.WithFields(logrus.Fields{
+				"prefix": "sentinel",
+			}).Debug("Watching for failover on: ", addr)
+
+		case error:
+			return v
+		}
+	}
+}
+
+// StartSentinelWatcher follows master failovers, moving on to the next sentinel if one goes away
+func StartSentinelWatcher() {
+	for {
+		for _, addr := range sentinelAddresses() {
+			err := watchSentinel(addr)
+			log.WithFields(logrus.Fields{
+				"prefix": "sentinel",
+			}).Warning("Lost connection to sentinel ", addr, ": ", err)
+
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// InitRedisSentinel finds the current master before any pools are created, if sentinel is enabled
+func InitRedisSentinel() {
+	if !config.Storage.EnableSentinel {
+		return
+	}
+
+	if len(config.Storage.SentinelHosts) == 0 {
+		log.WithFields(logrus.Fields{
+			"prefix": "sentinel",
+		}).Error("Sentinel is enabled but no sentinel hosts are set, using the configured host")
+		return
+	}
+
+	master, err := discoverRedisMaster()
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "sentinel",
+		}).Error("Could not discover redis master, falling back to the configured host: ", err)
+	} else {
+		setRedisMaster(master)
+		log.WithFields(logrus.Fields{
+			"prefix": "sentinel",
+		}).Info("Using redis master: ", master)
+	}
+
+	go StartSentinelWatcher()
+}
+
+func redisHostPort(addr string) map[string]string {
+	i := strings.LastIndex(addr, ":")
+	return map[string]string{addr[:i]: addr[i+1:]}
+}
+
+// redisServerAddress is the master if sentinel has found one, otherwise the configured host
+func redisServerAddress() string {
+	if config.Storage.EnableSentinel {
+		if master := currentRedisMaster(); master != "" {
+			return master
+		}
+	}
+
+	return config.Storage.Host + ":" + strconv.Itoa(config.Storage.Port)
+}
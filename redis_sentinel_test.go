@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseSwitchMasterMessage(t *testing.T) {
+	addr, ok := parseSwitchMasterMessage([]byte("mymaster 10.0.0.1 6379 10.0.0.2 6380"), "mymaster")
+	if !ok || addr != "10.0.0.2:6380" {
+		t.Error("Expected new master address, got: ", addr, ok)
+	}
+
+	if _, ok := parseSwitchMasterMessage([]byte("other 10.0.0.1 6379 10.0.0.2 6380"), "mymaster"); ok {
+		t.Error("Failovers of other masters should be ignored")
+	}
+
+	if _, ok := parseSwitchMasterMessage([]byte("mymaster 10.0.0.1"), "mymaster"); ok {
+		t.Error("Malformed messages should be ignored")
+	}
+}
+
+func TestRedisMasterChangeNotifies(t *testing.T) {
+	defer func() {
+		redisMasterAddr = ""
+		config.Storage.EnableSentinel = false
+	}()
+
+	config.Storage.EnableSentinel = true
+	setRedisMaster("10.0.0.1:6379")
+
+	changed := redisMasterChangedNotify()
+	if setRedisMaster("10.0.0.1:6379") {
+		t.Error("Setting the same master should not be a change")
+	}
+
+	if !setRedisMaster("10.0.0.2:6379") {
+		t.Fatal("Expected master to change")
+	}
+	notifyRedisMasterChanged()
+
+	select {
+	case <-changed:
+	default:
+		t.Error("Subscribers should be told the master changed")
+	}
+
+	if redisServerAddress() != "10.0.0.2:6379" {
+		t.Error("Expected the new master to be used, got: ", redisServerAddress())
+	}
+
+	if hostPort := redisHostPort(redisServerAddress()); hostPort["10.0.0.2"] != "6379" {
+		t.Error("Unexpected seed host: ", hostPort)
+	}
+}
@@ -12,11 +12,20 @@ const (
 )
 
 func StartPubSubLoop() {
-	CacheStore := RedisClusterStorageManager{}
+	CacheStore := GetClusterStorageHandler("", false)
 	CacheStore.Connect()
 	// On message, synchronise
 	for {
 		err := CacheStore.StartPubSubHandler(RedisPubSubChannel, HandleRedisReloadMsg)
+		if err == ErrRedisMasterChanged {
+			log.WithFields(logrus.Fields{
+				"prefix": "pub-sub",
+			}).Info("Redis master changed, resubscribing")
+
+			CacheStore.Connect()
+			continue
+		}
+
 		if err != nil {
 			log.WithFields(logrus.Fields{
 				"prefix": "pub-sub",
@@ -43,6 +52,12 @@ func HandleRedisReloadMsg(message redis.Message) {
 		return
 	}
 
+	// Canary weights only need to be read again, the APIs don't change
+	if thisMessage.Command == NoticeCanaryUpdated {
+		CanaryWeights.Load(thisMessage.Payload)
+		return
+	}
+
 	log.WithFields(logrus.Fields{
 		"prefix": "pub-sub",
 	}).Info("Reloading endpoints")
@@ -400,6 +400,12 @@ func (r *RPCStorageHandler) IncrememntWithExpire(keyName string, expire int64) i
 
 }
 
+// IncrementByWithExpire is not supported by the RPC layer
+func (r *RPCStorageHandler) IncrementByWithExpire(keyName string, n int64, expire int64) int64 {
+	log.Warning("IncrementByWithExpire Not Implemented")
+	return 0
+}
+
 // GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
 func (r *RPCStorageHandler) GetKeys(filter string) []string {
 
@@ -585,6 +591,11 @@ func (r *RPCStorageHandler) SetRollingWindowPipeline(keyName string, per int64,
 
 }
 
+// SetRateLimit is not available over RPC, the limiter will fall back to the rolling window
+func (r *RPCStorageHandler) SetRateLimit(keyName string, algorithm string, rate float64, per float64, burst float64) (RateLimitResult, error) {
+	return RateLimitResult{}, ErrRateLimitAlgorithmNotSupported
+}
+
 func (r RPCStorageHandler) GetSet(keyName string) (map[string]string, error) {
 	log.Error("Not implemented")
 	return map[string]string{}, nil
@@ -57,11 +57,56 @@ func (l SessionLimiter) doRollingWindowWrite(key, rateLimiterKey, rateLimiterSen
 	return false
 }
 
-// ForwardMessage will enforce rate limiting, returning false if session limits have been exceeded.
-// Key values to manage rate are Rate and Per, e.g. Rate of 10 messages Per 10 seconds
-func (l SessionLimiter) ForwardMessage(currentSession *SessionState, key string, store StorageHandler) (bool, int) {
-	rateLimiterKey := RateLimitKeyPrefix + publicHash(key)
-	rateLimiterSentinelKey := RateLimitKeyPrefix + publicHash(key) + ".BLOCKED"
+// isRollingWindowExceeded adds a hit to a standalone rolling window and checks it against rate and per,
+// this is used for limits that are not tied to a session (endpoint, API and IP limits)
+func isRollingWindowExceeded(rateLimiterKey string, rate float64, per float64, store StorageHandler) bool {
+	if GlobalLocalRateLimiter != nil {
+		return !GlobalLocalRateLimiter.Allow(rateLimiterKey, rate, per)
+	}
+
+	var ratePerPeriodNow int
+	if config.EnableNonTransactionalRateLimiter {
+		ratePerPeriodNow, _ = store.SetRollingWindowPipeline(rateLimiterKey, int64(per), "-1")
+	} else {
+		ratePerPeriodNow, _ = store.SetRollingWindow(rateLimiterKey, int64(per), "-1")
+	}
+
+	// Subtract by 1 because of the delayed add in the window
+	return ratePerPeriodNow > (int(rate) - 1)
+}
+
+// doAlgorithmRateLimit applies a token bucket or GCRA limit, returning true if the request should be
+// blocked. If the storage handler can't run the algorithm, handled is false and the caller should
+// fall back to the rolling window.
+func (l SessionLimiter) doAlgorithmRateLimit(rateLimiterKey string, currentSession *SessionState, store StorageHandler) (bool, bool) {
+	algorithmKey := rateLimiterKey + "." + currentSession.RateLimitAlgorithm
+	result, err := store.SetRateLimit(algorithmKey, currentSession.RateLimitAlgorithm, currentSession.Rate, currentSession.Per, currentSession.RateLimitBurst)
+	if err != nil {
+		log.Warning("[RATELIMIT] Falling back to rolling window: ", err)
+		return false, false
+	}
+
+	currentSession.rateLimitResult = &result
+	return !result.Allowed, true
+}
+
+// isRateLimited checks the session against its configured rate limit algorithm, falling back to the
+// rolling window if the algorithm is not set or not supported by the store
+func (l SessionLimiter) isRateLimited(key, rateLimiterKey, rateLimiterSentinelKey string, currentSession *SessionState, store StorageHandler) bool {
+	currentSession.rateLimitResult = nil
+
+	switch currentSession.RateLimitAlgorithm {
+	case RateLimitTokenBucket, RateLimitGCRA:
+		blocked, handled := l.doAlgorithmRateLimit(rateLimiterKey, currentSession, store)
+		if handled {
+			return blocked
+		}
+	}
+
+	// Approximate limits from in-process counters, avoids the redis round trip
+	if GlobalLocalRateLimiter != nil {
+		return !GlobalLocalRateLimiter.Allow(rateLimiterKey, currentSession.Rate, currentSession.Per)
+	}
 
 	if config.EnableSentinelRateLImiter {
 		go l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, currentSession, store)
@@ -70,12 +115,23 @@ func (l SessionLimiter) ForwardMessage(currentSession *SessionState, key string,
 		_, sentinelActive := store.GetRawKey(rateLimiterSentinelKey)
 		if sentinelActive == nil {
 			// Sentinel is set, fail
-			return false, 1
-		}
-	} else {
-		if l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, currentSession, store) {
-			return false, 1
+			return true
 		}
+
+		return false
+	}
+
+	return l.doRollingWindowWrite(key, rateLimiterKey, rateLimiterSentinelKey, currentSession, store)
+}
+
+// ForwardMessage will enforce rate limiting, returning false if session limits have been exceeded.
+// Key values to manage rate are Rate and Per, e.g. Rate of 10 messages Per 10 seconds
+func (l SessionLimiter) ForwardMessage(currentSession *SessionState, key string, store StorageHandler) (bool, int) {
+	rateLimiterKey := RateLimitKeyPrefix + publicHash(key)
+	rateLimiterSentinelKey := RateLimitKeyPrefix + publicHash(key) + ".BLOCKED"
+
+	if l.isRateLimited(key, rateLimiterKey, rateLimiterSentinelKey, currentSession, store) {
+		return false, 1
 	}
 
 	currentSession.Allowance--
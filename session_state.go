@@ -54,5 +54,21 @@ type SessionState struct {
 	EnableDetailedRecording bool        `json:"enable_detail_recording"`
 	MetaData                interface{} `json:"meta_data"`
 	Tags                    []string    `json:"tags"`
-	Alias string `json:"alias"`
+	Alias                   string      `json:"alias"`
+	RateLimitAlgorithm      string      `json:"rate_limit_algorithm"`
+	RateLimitBurst          float64     `json:"rate_limit_burst"`
+
+	// rateLimitResult is the outcome of the last token bucket or GCRA check, it is only
+	// used to report the limiter state back to the client and is never stored
+	rateLimitResult *RateLimitResult
+}
+
+// RateLimitHeaderValues returns the values for the X-RateLimit-* headers, these reflect the
+// rate limiter if a token bucket or GCRA limiter was applied, otherwise the quota
+func (s *SessionState) RateLimitHeaderValues() (int64, int64, int64) {
+	if s.rateLimitResult != nil {
+		return s.rateLimitResult.Limit, s.rateLimitResult.Remaining, s.rateLimitResult.Reset
+	}
+
+	return s.QuotaMax, s.QuotaRemaining, s.QuotaRenews
 }
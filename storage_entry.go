@@ -0,0 +1,207 @@
+package main
+
+import (
+	"github.com/garyburd/redigo/redis"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// storageZMember is a member of a sorted set, only used for rolling windows
+type storageZMember struct {
+	Score  int64  `json:"score"`
+	Member string `json:"member"`
+}
+
+// storageEntry is a single key for the embedded storage handlers (memory and bolt), like redis a key
+// holds one type of value
+type storageEntry struct {
+	Value   string           `json:"value,omitempty"`
+	List    []string         `json:"list,omitempty"`
+	Set     map[string]bool  `json:"set,omitempty"`
+	ZSet    []storageZMember `json:"zset,omitempty"`
+	Expires time.Time        `json:"expires"`
+}
+
+func (e *storageEntry) expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+func (e *storageEntry) expireIn(seconds int64) {
+	e.Expires = time.Now().Add(time.Duration(seconds) * time.Second)
+}
+
+func (e *storageEntry) isString() bool {
+	return e.List == nil && e.Set == nil && e.ZSet == nil
+}
+
+// ttl returns the seconds left on a key, or -1 if it has no expiry
+func (e *storageEntry) ttl() int64 {
+	if e.Expires.IsZero() {
+		return -1
+	}
+
+	return int64(e.Expires.Sub(time.Now()).Seconds())
+}
+
+func (e *storageEntry) incrementBy(n int64) int64 {
+	current, _ := strconv.ParseInt(e.Value, 10, 64)
+	current += n
+	e.Value = strconv.FormatInt(current, 10)
+	return current
+}
+
+func (e *storageEntry) listValues() []interface{} {
+	vals := make([]interface{}, len(e.List))
+	for i, v := range e.List {
+		vals[i] = []byte(v)
+	}
+
+	return vals
+}
+
+func (e *storageEntry) addToSet(value string) {
+	if e.Set == nil {
+		e.Set = make(map[string]bool)
+	}
+	e.Set[value] = true
+}
+
+func (e *storageEntry) setMembers() map[string]string {
+	members := make([]string, 0, len(e.Set))
+	for member := range e.Set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+
+	vals := make(map[string]string)
+	for i, value := range members {
+		vals[strconv.Itoa(i)] = value
+	}
+
+	return vals
+}
+
+// rollingWindow trims the sorted set to the last per seconds, returns the hits in the window and
+// then adds this hit, the same as the redis rolling window transaction
+func (e *storageEntry) rollingWindow(per int64, value_override string) (int, []interface{}) {
+	now := time.Now()
+	onePeriodAgo := now.Add(time.Duration(-1*per) * time.Second).UnixNano()
+
+	window := e.ZSet[:0]
+	for _, member := range e.ZSet {
+		if member.Score > onePeriodAgo {
+			window = append(window, member)
+		}
+	}
+
+	vals := make([]interface{}, len(window))
+	for i, member := range window {
+		vals[i] = []byte(member.Member)
+	}
+
+	if value_override != "-1" {
+		window = append(window, storageZMember{now.UnixNano(), value_override})
+	} else {
+		window = append(window, storageZMember{now.UnixNano(), strconv.Itoa(int(now.UnixNano()))})
+	}
+
+	e.ZSet = window
+	e.expireIn(per)
+
+	return len(vals), vals
+}
+
+// rateLimit runs the token bucket or GCRA algorithms on the entry, these follow the redis scripts in
+// rate_limit_algorithms.go and return the same reply
+func (e *storageEntry) rateLimit(algorithm string, rate float64, per float64, burst float64, now time.Time) []interface{} {
+	burst = rateLimitBurst(rate, burst)
+	nowMS := float64(now.UnixNano() / int64(time.Millisecond))
+
+	if algorithm == RateLimitTokenBucket {
+		refill := rate / (per * 1000)
+		tokens, ts := burst, nowMS
+		if state := strings.Split(e.Value, ":"); len(state) == 2 {
+			tokens, _ = strconv.ParseFloat(state[0], 64)
+			ts, _ = strconv.ParseFloat(state[1], 64)
+		}
+
+		tokens = math.Min(burst, tokens+math.Max(0, nowMS-ts)*refill)
+
+		allowed, retry := int64(0), 0.0
+		if tokens >= 1 {
+			tokens--
+			allowed = 1
+		} else {
+			retry = math.Ceil((1 - tokens) / refill)
+		}
+
+		full := math.Ceil((burst - tokens) / refill)
+		e.Value = strconv.FormatFloat(tokens, 'f', -1, 64) + ":" + strconv.FormatFloat(nowMS, 'f', -1, 64)
+		e.Expires = now.Add(time.Duration(full+1000) * time.Millisecond)
+
+		return []interface{}{allowed, int64(math.Floor(tokens)), int64(full), int64(retry)}
+	}
+
+	emission := (per * 1000) / rate
+	tolerance := emission * burst
+
+	tat, err := strconv.ParseFloat(e.Value, 64)
+	if err != nil || tat < nowMS {
+		tat = nowMS
+	}
+
+	newTat := tat + emission
+	allowAt := newTat - tolerance
+	if nowMS < allowAt {
+		return []interface{}{int64(0), int64(0), int64(math.Ceil(tat - nowMS)), int64(math.Ceil(allowAt - nowMS))}
+	}
+
+	e.Value = strconv.FormatFloat(newTat, 'f', -1, 64)
+	e.Expires = now.Add(time.Duration(math.Ceil(newTat-nowMS)) * time.Millisecond)
+	return []interface{}{int64(1), int64(math.Floor((tolerance - (newTat - nowMS)) / emission)), int64(math.Ceil(newTat - nowMS)), int64(0)}
+}
+
+// localPubSub emulates redis pub/sub for the embedded storage handlers, messages are only
+// delivered to subscribers in this process
+type localPubSub struct {
+	mu          sync.Mutex
+	subscribers map[string][]chan redis.Message
+}
+
+func newLocalPubSub() *localPubSub {
+	return &localPubSub{subscribers: make(map[string][]chan redis.Message)}
+}
+
+func (p *localPubSub) subscribe(channel string) chan redis.Message {
+	messages := make(chan redis.Message, 100)
+
+	p.mu.Lock()
+	p.subscribers[channel] = append(p.subscribers[channel], messages)
+	p.mu.Unlock()
+
+	log.Debug("Subscription started: ", channel)
+	return messages
+}
+
+func (p *localPubSub) hasSubscribers(channel string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.subscribers[channel]) > 0
+}
+
+func (p *localPubSub) publish(channel string, message string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, subscriber := range p.subscribers[channel] {
+		select {
+		case subscriber <- redis.Message{Channel: channel, Data: []byte(message)}:
+		default:
+			log.Warning("Subscriber is not keeping up, dropping message on: ", channel)
+		}
+	}
+}
@@ -41,136 +41,22 @@ type StorageHandler interface {
 	DeleteKeys([]string) bool
 	Decrement(string)
 	IncrememntWithExpire(string, int64) int64
+	IncrementByWithExpire(string, int64, int64) int64
 	SetRollingWindow(string, int64, string) (int, []interface{})
 	SetRollingWindowPipeline(string, int64, string) (int, []interface{})
+	SetRateLimit(string, string, float64, float64, float64) (RateLimitResult, error)
 	GetSet(string) (map[string]string, error)
 	AddToSet(string, string)
 	RemoveFromSet(string, string)
 }
 
-// InMemoryStorageManager implements the StorageHandler interface,
-// it uses an in-memory map to store sessions, should only be used
-// for testing purposes
-type InMemoryStorageManager struct {
-	Sessions map[string]string
-}
-
-// Decrement is a dummy function
-func (s *InMemoryStorageManager) Decrement(n string) {
-	log.Warning("Not implemented!")
-}
-
-func (s *InMemoryStorageManager) SetRollingWindow(keyName string, per int64, val string) (int, []interface{}) {
-	log.Warning("Not Implemented!")
-	return 0, []interface{}{}
-}
-
-func (s *InMemoryStorageManager) SetRollingWindowPipeline(keyName string, per int64, val string) (int, []interface{}) {
-	log.Warning("Not Implemented!")
-	return 0, []interface{}{}
-}
-
-func (s *InMemoryStorageManager) IncrememntWithExpire(n string, i int64) int64 {
-	log.Warning("Not implemented!")
-	return 0
-}
-
-func (s *InMemoryStorageManager) Connect() bool {
-	return true
-}
-
-// GetKey retrieves the key from the in-memory map
-func (s InMemoryStorageManager) GetKey(keyName string) (string, error) {
-	value, ok := s.Sessions[keyName]
-	if !ok {
-		return "", KeyError{}
-	}
-
-	return value, nil
-
-}
-
-func (s InMemoryStorageManager) GetRawKey(keyName string) (string, error) {
-	value, ok := s.Sessions[keyName]
-	if !ok {
-		return "", KeyError{}
-	}
-
-	return value, nil
-
-}
-
-// SetKey updates the in-memory key
-func (s InMemoryStorageManager) SetKey(keyName string, sessionState string, timeout int64) error {
-	s.Sessions[keyName] = sessionState
-	return nil
-}
-
-func (s InMemoryStorageManager) SetRawKey(keyName string, sessionState string, timeout int64) error {
-	s.Sessions[keyName] = sessionState
-	return nil
-}
-
-func (s InMemoryStorageManager) GetExp(keyName string) (int64, error) {
-	return 0, nil
-}
-
-// GetKeys will retreive multiple keys based on a filter (prefix, e.g. tyk.keys)
-func (s InMemoryStorageManager) GetKeys(filter string) []string {
-	sessions := make([]string, 0, len(s.Sessions))
-	for key := range s.Sessions {
-		if strings.Contains(key, filter) {
-			sessions = append(sessions, key)
-		}
-	}
-
-	return sessions
-}
-
-// GetKeysAndValues returns all keys and their data, very expensive call.
-func (s InMemoryStorageManager) GetKeysAndValues() map[string]string {
-	return s.Sessions
-}
-
-// GetKeysAndValuesWithFilter does nothing here
-func (s InMemoryStorageManager) GetKeysAndValuesWithFilter(filter string) map[string]string {
-	log.Warning("NOT IMPLEMENTED")
-	return s.Sessions
-}
-
-// DeleteKey will remove a key from the storage engine
-func (s InMemoryStorageManager) DeleteKey(keyName string) bool {
-	delete(s.Sessions, keyName)
-	return true
-}
-
-// DeleteRawKey will remove a key from the storage engine
-func (s InMemoryStorageManager) DeleteRawKey(keyName string) bool {
-	delete(s.Sessions, keyName)
-	return true
-}
-
-// DeleteKeys remove keys from sessions DB
-func (s InMemoryStorageManager) DeleteKeys(keys []string) bool {
-
-	for _, keyName := range keys {
-		delete(s.Sessions, keyName)
-	}
-
-	return true
-}
-
-func (s InMemoryStorageManager) GetSet(keyName string) (map[string]string, error) {
-	log.Error("Not implemented")
-	return map[string]string{}, nil
-}
-
-func (s InMemoryStorageManager) AddToSet(keyName string, value string) {
-	log.Error("Not implemented")
-}
-
-func (s InMemoryStorageManager) RemoveFromSet(keyName string, value string) {
-	log.Error("Not implemented")
+// ClusterStorageHandler is a StorageHandler that is shared by all gateways in the cluster (redis, or
+// memory for a single node), these also provide lists and pub/sub for signals
+type ClusterStorageHandler interface {
+	StorageHandler
+	AppendToSet(string, string)
+	Publish(string, string) error
+	StartPubSubHandler(string, func(redis.Message)) error
 }
 
 // ------------------- REDIS STORAGE MANAGER -------------------------------
@@ -208,7 +94,12 @@ func NewRedisPool(server, password string, database int) *redis.Pool {
 		MaxActive:   maxActive,
 		IdleTimeout: 240 * time.Second,
 		Dial: func() (redis.Conn, error) {
-			c, err := redis.Dial("tcp", server)
+			addr := server
+			if config.Storage.EnableSentinel {
+				addr = redisServerAddress()
+			}
+
+			c, err := redis.Dial("tcp", addr)
 			if err != nil {
 				return nil, err
 			}
@@ -224,9 +115,17 @@ func NewRedisPool(server, password string, database int) *redis.Pool {
 					return nil, err
 				}
 			}
+			if config.Storage.EnableSentinel {
+				return &redisMasterConn{c, addr}, nil
+			}
 			return c, err
 		},
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
+			// Drop connections to a master that has been failed over
+			if masterConn, ok := c.(*redisMasterConn); ok && masterConn.addr != redisServerAddress() {
+				return ErrRedisMasterChanged
+			}
+
 			_, err := c.Do("PING")
 			return err
 		},
@@ -238,7 +137,7 @@ func NewRedisPool(server, password string, database int) *redis.Pool {
 func (r *RedisStorageManager) Connect() bool {
 
 	if r.pool == nil {
-		fullPath := config.Storage.Host + ":" + strconv.Itoa(config.Storage.Port)
+		fullPath := redisServerAddress()
 		log.Debug("Connecting to redis on: ", fullPath)
 		r.pool = NewRedisPool(fullPath, config.Storage.Password, config.Storage.Database)
 	} else {
@@ -298,10 +197,15 @@ func (r *RedisStorageManager) GetKey(keyName string) (string, error) {
 	log.Debug("[STORE] Getting WAS: ", keyName)
 	log.Debug("[STORE] Getting: ", r.fixKey(keyName))
 	value, err := redis.String(db.Do("GET", r.fixKey(keyName)))
-	if err != nil {
+	if err == redis.ErrNil {
 		log.Debug("Error trying to get value:", err)
 		return "", KeyError{}
 	}
+	if err != nil {
+		// Anything other than a missing key is passed on so that callers can tell an outage from no value
+		log.Error("Error trying to get value: ", err)
+		return "", err
+	}
 
 	return value, nil
 }
@@ -446,6 +350,26 @@ func (r *RedisStorageManager) IncrememntWithExpire(keyName string, expire int64)
 	return 0
 }
 
+// IncrementByWithExpire adds n to a raw counter key, setting the expiry if the key is new
+func (r *RedisStorageManager) IncrementByWithExpire(keyName string, n int64, expire int64) int64 {
+	db := r.pool.Get()
+	defer db.Close()
+
+	log.Debug("Incrementing raw key: ", keyName, " by: ", n)
+	val, err := redis.Int64(db.Do("INCRBY", keyName, n))
+	if err != nil {
+		log.Error("Error trying to increment value:", err)
+		return 0
+	}
+
+	if val == n {
+		log.Debug("--> Setting Expire")
+		db.Do("EXPIRE", keyName, expire)
+	}
+
+	return val
+}
+
 // GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
 func (r *RedisStorageManager) GetKeys(filter string) []string {
 	db := r.pool.Get()
@@ -817,6 +741,27 @@ func (r *RedisStorageManager) SetRollingWindowPipeline(keyName string, per int64
 	return 0, []interface{}{}
 }
 
+// SetRateLimit runs one of the atomic rate limit scripts (token bucket or GCRA) against a key
+func (r *RedisStorageManager) SetRateLimit(keyName string, algorithm string, rate float64, per float64, burst float64) (RateLimitResult, error) {
+	db := r.pool.Get()
+	defer db.Close()
+
+	if db == nil {
+		log.Info("Connection dropped, connecting..")
+		r.Connect()
+		return r.SetRateLimit(keyName, algorithm, rate, per, burst)
+	}
+
+	now := time.Now()
+	script, _, args, err := getRateLimitScript(algorithm, rate, per, burst, now)
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+
+	reply, err := script.Do(db, append([]interface{}{keyName}, args...)...)
+	return parseRateLimitReply(reply, err, rateLimitBurst(rate, burst), now)
+}
+
 func (r *RedisStorageManager) GetSet(keyName string) (map[string]string, error) {
 	log.Debug("Getting from key set: ", keyName)
 	log.Info("Getting from fixed key set: ", r.fixKey(keyName))
@@ -0,0 +1,525 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	TRACEPARENT_HEADER string = "traceparent"
+
+	TRACE_EXPORTER_OTLP string = "otlp"
+	TRACE_EXPORTER_FILE string = "file"
+
+	// OTLP span kinds
+	SpanKindInternal int = 1
+	SpanKindServer   int = 2
+	SpanKindClient   int = 3
+
+	defaultTraceBufferSize    int = 10000
+	defaultTraceBatchSize     int = 100
+	defaultTraceFlushInterval int = 1000
+)
+
+// Span is a single timed operation in a trace, a nil Span is valid and does nothing so callers
+// don't need to check if tracing is enabled
+type Span struct {
+	TraceID      [16]byte
+	SpanID       [8]byte
+	ParentSpanID [8]byte
+	Sampled      bool
+	Name         string
+	Kind         int
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]string
+	Failed       bool
+
+	mu sync.Mutex
+}
+
+// SetAttribute adds a string attribute to the span
+func (s *Span) SetAttribute(key string, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed and records the reason
+func (s *Span) SetError(code int, reason string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.Failed = true
+	s.Attributes["http.status_code"] = strconv.Itoa(code)
+	s.Attributes["error.message"] = reason
+	s.mu.Unlock()
+}
+
+// Finish ends the span and hands it to the exporter
+func (s *Span) Finish() {
+	if s == nil || GlobalTracer == nil {
+		return
+	}
+
+	s.End = time.Now()
+	GlobalTracer.enqueue(s)
+}
+
+// TraceParent renders the span as a W3C traceparent header value
+func (s *Span) TraceParent() string {
+	flags := "00"
+	if s.Sampled {
+		flags = "01"
+	}
+
+	return "00-" + hex.EncodeToString(s.TraceID[:]) + "-" + hex.EncodeToString(s.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceParent decodes a W3C traceparent header, the span returned only carries the remote
+// trace and span IDs to be used as a parent
+func ParseTraceParent(header string) (*Span, error) {
+	parts := strings.Split(strings.TrimSpace(header), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return nil, errors.New("Invalid traceparent header")
+	}
+
+	if len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return nil, errors.New("Invalid traceparent header")
+	}
+
+	remote := &Span{}
+	if _, err := hex.Decode(remote.TraceID[:], []byte(parts[1])); err != nil {
+		return nil, err
+	}
+	if _, err := hex.Decode(remote.SpanID[:], []byte(parts[2])); err != nil {
+		return nil, err
+	}
+	flags, err := hex.DecodeString(parts[3])
+	if err != nil {
+		return nil, err
+	}
+
+	if remote.TraceID == [16]byte{} || remote.SpanID == [8]byte{} {
+		return nil, errors.New("Invalid traceparent header, zero ID")
+	}
+
+	remote.Sampled = flags[0]&1 == 1
+	return remote, nil
+}
+
+// SpanExporter sends finished spans somewhere
+type SpanExporter interface {
+	Init() error
+	ExportSpans([]*Span) error
+}
+
+// Tracer creates spans and batches them to an exporter off the request path
+type Tracer struct {
+	ServiceName string
+	Exporter    SpanExporter
+
+	queue *batchQueue
+}
+
+var GlobalTracer *Tracer
+
+// InitTracer sets up the global tracer from the config, if tracing is disabled no spans are created
+func InitTracer() {
+	if !config.Tracing.Enabled {
+		GlobalTracer = nil
+		return
+	}
+
+	var exporter SpanExporter
+	switch config.Tracing.Exporter {
+	case TRACE_EXPORTER_FILE:
+		exporter = &FileSpanExporter{Path: config.Tracing.FilePath}
+	case TRACE_EXPORTER_OTLP:
+		exporter = &OTLPHTTPSpanExporter{
+			Endpoint:    config.Tracing.OTLPEndpoint,
+			Headers:     config.Tracing.OTLPHeaders,
+			ServiceName: config.Tracing.ServiceName,
+		}
+	default:
+		log.WithFields(logrus.Fields{
+			"prefix": "tracing",
+		}).Error("Unknown trace exporter, tracing disabled: ", config.Tracing.Exporter)
+		return
+	}
+
+	thisTracer, err := NewTracer(config.Tracing.ServiceName, exporter, config.Tracing.BufferSize, config.Tracing.BatchSize, config.Tracing.FlushInterval)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "tracing",
+		}).Error("Failed to initialise trace exporter: ", err)
+		return
+	}
+
+	GlobalTracer = thisTracer
+	log.WithFields(logrus.Fields{
+		"prefix": "tracing",
+	}).Info("Tracing enabled, exporting to: ", config.Tracing.Exporter)
+}
+
+// NewTracer initialises an exporter and starts the export worker, zero values will use the defaults
+func NewTracer(serviceName string, exporter SpanExporter, bufferSize int, batchSize int, flushInterval int) (*Tracer, error) {
+	if err := exporter.Init(); err != nil {
+		return nil, err
+	}
+
+	if serviceName == "" {
+		serviceName = "tyk-gateway"
+	}
+
+	if bufferSize <= 0 {
+		bufferSize = defaultTraceBufferSize
+	}
+
+	if batchSize <= 0 {
+		batchSize = defaultTraceBatchSize
+	}
+
+	if flushInterval <= 0 {
+		flushInterval = defaultTraceFlushInterval
+	}
+
+	thisTracer := &Tracer{
+		ServiceName: serviceName,
+		Exporter:    exporter,
+	}
+	thisTracer.queue = newBatchQueue(bufferSize, batchSize, time.Duration(flushInterval)*time.Millisecond, thisTracer.export)
+	thisTracer.queue.start()
+
+	return thisTracer, nil
+}
+
+func newSpanID() [8]byte {
+	var id [8]byte
+	rand.Read(id[:])
+	return id
+}
+
+func newTraceID() [16]byte {
+	var id [16]byte
+	rand.Read(id[:])
+	return id
+}
+
+// StartSpan creates a new span, if parent is nil a new trace is started
+func (t *Tracer) StartSpan(name string, kind int, parent *Span) *Span {
+	if t == nil {
+		return nil
+	}
+
+	thisSpan := &Span{
+		SpanID:     newSpanID(),
+		Name:       name,
+		Kind:       kind,
+		Start:      time.Now(),
+		Attributes: make(map[string]string),
+		Sampled:    true,
+	}
+
+	if parent != nil {
+		thisSpan.TraceID = parent.TraceID
+		thisSpan.ParentSpanID = parent.SpanID
+		thisSpan.Sampled = parent.Sampled
+	} else {
+		thisSpan.TraceID = newTraceID()
+	}
+
+	return thisSpan
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	if !s.Sampled {
+		return
+	}
+
+	if !t.queue.push(s) {
+		log.WithFields(logrus.Fields{
+			"prefix": "tracing",
+		}).Debug("Span buffer full, dropping span")
+	}
+}
+
+func (t *Tracer) export(items []interface{}) {
+	spans := make([]*Span, len(items))
+	for i, item := range items {
+		spans[i] = item.(*Span)
+	}
+
+	if err := t.Exporter.ExportSpans(spans); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "tracing",
+		}).Error("Failed to export spans: ", err)
+	}
+}
+
+// StartRequestSpan returns the span for the request passing through the middleware chain, creating
+// it if this is the first middleware to see the request. created is true if the caller owns the span
+// and must finish it.
+func StartRequestSpan(r *http.Request, spec *APISpec) (*Span, bool) {
+	if GlobalTracer == nil {
+		return nil, false
+	}
+
+	if existing, found := context.GetOk(r, TraceContext); found {
+		return existing.(*Span), false
+	}
+
+	var parent *Span
+	if header := r.Header.Get(TRACEPARENT_HEADER); header != "" {
+		remote, err := ParseTraceParent(header)
+		if err != nil {
+			log.Debug("Ignoring traceparent: ", err)
+		} else {
+			parent = remote
+		}
+	}
+
+	thisSpan := GlobalTracer.StartSpan(r.Method+" "+spec.Proxy.ListenPath, SpanKindServer, parent)
+	thisSpan.SetAttribute("http.method", r.Method)
+	thisSpan.SetAttribute("http.target", r.URL.Path)
+	thisSpan.SetAttribute("tyk.api_id", spec.APIID)
+	thisSpan.SetAttribute("tyk.org_id", spec.OrgID)
+
+	context.Set(r, TraceContext, thisSpan)
+	return thisSpan, true
+}
+
+// StartChildSpan starts a span under the request span, if there is one
+func StartChildSpan(r *http.Request, name string, kind int) *Span {
+	if GlobalTracer == nil {
+		return nil
+	}
+
+	parent, found := context.GetOk(r, TraceContext)
+	if !found {
+		return nil
+	}
+
+	return GlobalTracer.StartSpan(name, kind, parent.(*Span))
+}
+
+// middlewareSpanName gives a readable name for a middleware in the chain
+func middlewareSpanName(mw TykMiddlewareImplementation) string {
+	if dynamic, ok := mw.(*DynamicMiddleware); ok {
+		return "JSVM " + dynamic.MiddlewareClassName
+	}
+
+	return reflect.Indirect(reflect.ValueOf(mw)).Type().Name()
+}
+
+// FileSpanExporter writes spans as newline delimited JSON
+type FileSpanExporter struct {
+	Path string
+
+	mu   sync.Mutex
+	file *os.File
+}
+
+type jsonSpan struct {
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Name         string            `json:"name"`
+	Kind         int               `json:"kind"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	DurationMS   float64           `json:"duration_ms"`
+	Attributes   map[string]string `json:"attributes"`
+	Error        bool              `json:"error"`
+}
+
+func (f *FileSpanExporter) Init() error {
+	if f.Path == "" {
+		return errors.New("No trace file path set")
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.file = file
+	return nil
+}
+
+func (f *FileSpanExporter) ExportSpans(spans []*Span) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	w := bufio.NewWriter(f.file)
+	for _, s := range spans {
+		thisSpan := jsonSpan{
+			TraceID:    hex.EncodeToString(s.TraceID[:]),
+			SpanID:     hex.EncodeToString(s.SpanID[:]),
+			Name:       s.Name,
+			Kind:       s.Kind,
+			Start:      s.Start,
+			End:        s.End,
+			DurationMS: float64(s.End.Sub(s.Start)) / float64(time.Millisecond),
+			Attributes: s.Attributes,
+			Error:      s.Failed,
+		}
+		if s.ParentSpanID != [8]byte{} {
+			thisSpan.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+
+		asJSON, err := json.Marshal(thisSpan)
+		if err != nil {
+			return err
+		}
+		w.Write(asJSON)
+		w.WriteByte('\n')
+	}
+
+	return w.Flush()
+}
+
+// OTLPHTTPSpanExporter posts spans to an OpenTelemetry collector using the OTLP/HTTP JSON encoding
+type OTLPHTTPSpanExporter struct {
+	Endpoint    string
+	Headers     map[string]string
+	ServiceName string
+
+	client *http.Client
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code int `json:"code"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraceRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+func (o *OTLPHTTPSpanExporter) Init() error {
+	if o.Endpoint == "" {
+		return errors.New("No OTLP endpoint set")
+	}
+
+	if o.ServiceName == "" {
+		o.ServiceName = "tyk-gateway"
+	}
+
+	o.client = &http.Client{Timeout: 10 * time.Second}
+	return nil
+}
+
+func (o *OTLPHTTPSpanExporter) encode(spans []*Span) otlpTraceRequest {
+	scope := otlpScopeSpans{Spans: make([]otlpSpan, 0, len(spans))}
+	scope.Scope.Name = "tyk"
+
+	for _, s := range spans {
+		thisSpan := otlpSpan{
+			TraceID:           hex.EncodeToString(s.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        make([]otlpAttribute, 0, len(s.Attributes)),
+		}
+		if s.ParentSpanID != [8]byte{} {
+			thisSpan.ParentSpanID = hex.EncodeToString(s.ParentSpanID[:])
+		}
+		for k, v := range s.Attributes {
+			thisSpan.Attributes = append(thisSpan.Attributes, otlpAttribute{k, otlpValue{v}})
+		}
+		if s.Failed {
+			thisSpan.Status.Code = 2
+		}
+		scope.Spans = append(scope.Spans, thisSpan)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = []otlpAttribute{{"service.name", otlpValue{o.ServiceName}}}
+
+	return otlpTraceRequest{ResourceSpans: []otlpResourceSpans{resource}}
+}
+
+func (o *OTLPHTTPSpanExporter) ExportSpans(spans []*Span) error {
+	asJSON, err := json.Marshal(o.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", o.Endpoint, bytes.NewReader(asJSON))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New("OTLP collector returned status: " + resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTraceParent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	remote, err := ParseTraceParent(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !remote.Sampled {
+		t.Error("Sampled flag should be set")
+	}
+
+	if remote.TraceParent() != header {
+		t.Error("Traceparent did not round trip, got: ", remote.TraceParent())
+	}
+
+	invalid := []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-zzf067aa0ba902b7-01",
+	}
+	for _, h := range invalid {
+		if _, err := ParseTraceParent(h); err == nil {
+			t.Error("Expected traceparent to be rejected: ", h)
+		}
+	}
+}
+
+func TestChildSpanJoinsTrace(t *testing.T) {
+	tracer := &Tracer{}
+	remote, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+
+	child := tracer.StartSpan("test", SpanKindServer, remote)
+	if child.TraceID != remote.TraceID {
+		t.Error("Child span should share the parent trace ID")
+	}
+
+	if child.ParentSpanID != remote.SpanID {
+		t.Error("Child span should point at the parent span")
+	}
+
+	if child.Sampled {
+		t.Error("Child span should inherit the sampling decision")
+	}
+
+	if !strings.HasSuffix(child.TraceParent(), "-00") {
+		t.Error("Unsampled span should propagate unsampled flag, got: ", child.TraceParent())
+	}
+}
+
+func TestFileSpanExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exporter := &FileSpanExporter{Path: filepath.Join(dir, "spans.json")}
+	if err := exporter.Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := &Tracer{}
+	root := tracer.StartSpan("root", SpanKindServer, nil)
+	child := tracer.StartSpan("AuthKey", SpanKindInternal, root)
+	child.SetError(403, "Key not authorised")
+
+	if err := exporter.ExportSpans([]*Span{root, child}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(exporter.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatal("Expected 2 spans, got: ", len(lines))
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[1]), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded["parent_span_id"] == nil || decoded["error"] != true {
+		t.Error("Child span was not exported correctly: ", lines[1])
+	}
+}
+
+func TestOTLPEncoding(t *testing.T) {
+	exporter := &OTLPHTTPSpanExporter{ServiceName: "test"}
+	tracer := &Tracer{}
+	thisSpan := tracer.StartSpan("upstream GET", SpanKindClient, nil)
+	thisSpan.SetError(500, "upstream failed")
+
+	encoded := exporter.encode([]*Span{thisSpan})
+	spans := encoded.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 1 {
+		t.Fatal("Expected 1 span")
+	}
+
+	if spans[0].Status.Code != 2 || spans[0].Kind != SpanKindClient {
+		t.Error("Span status or kind not encoded: ", spans[0])
+	}
+
+	if len(spans[0].TraceID) != 32 || len(spans[0].SpanID) != 16 {
+		t.Error("IDs should be hex encoded")
+	}
+}
+
+type testSpanExporter struct {
+	batches [][]*Span
+}
+
+func (e *testSpanExporter) Init() error {
+	return nil
+}
+
+func (e *testSpanExporter) ExportSpans(spans []*Span) error {
+	e.batches = append(e.batches, spans)
+	return nil
+}
+
+func TestTracerBatchesSpans(t *testing.T) {
+	exporter := &testSpanExporter{}
+	tracer, err := NewTracer("test", exporter, 10, 2, 60000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		tracer.enqueue(tracer.StartSpan("test", SpanKindInternal, nil))
+	}
+
+	unsampled := tracer.StartSpan("test", SpanKindInternal, nil)
+	unsampled.Sampled = false
+	tracer.enqueue(unsampled)
+
+	// The last span is only written once the queue is closed
+	tracer.queue.close(time.Second)
+	if len(exporter.batches) != 2 || len(exporter.batches[0]) != 2 || len(exporter.batches[1]) != 1 {
+		t.Error("Sampled spans should be exported in batches, got: ", exporter.batches)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	TrafficMirrorDefaultTimeout int    = 5
+	TrafficMirrorMaxBodySize    int64  = 1048576
+	TrafficMirrorMaxInFlight    int    = 100
+	TrafficMirrorTag            string = "traffic-mirror"
+)
+
+// mirrorSlots bounds the number of mirrored requests in flight, requests are not mirrored while it is full
+var mirrorSlots = make(chan struct{}, TrafficMirrorMaxInFlight)
+
+// TrafficMirrorConfig sends a copy of a sample of the requests (after the request transforms) to a second
+// upstream and discards the response. With RecordAnalytics the mirror's response code and request time
+// are added to the analytics record of the original request
+type TrafficMirrorConfig struct {
+	TargetURL       string  `mapstructure:"target_url" bson:"target_url" json:"target_url"`
+	SamplePercent   float64 `mapstructure:"sample_percent" bson:"sample_percent" json:"sample_percent"`
+	Timeout         int     `mapstructure:"timeout" bson:"timeout" json:"timeout"`
+	RecordAnalytics bool    `mapstructure:"record_analytics" bson:"record_analytics" json:"record_analytics"`
+}
+
+type trafficMirrorRawData struct {
+	TrafficMirror *TrafficMirrorConfig `mapstructure:"traffic_mirror"`
+	VersionData   struct {
+		Versions map[string]struct {
+			TrafficMirror *TrafficMirrorConfig `mapstructure:"traffic_mirror"`
+		} `mapstructure:"versions"`
+	} `mapstructure:"version_data"`
+}
+
+// getTrafficMirrorConfig decodes the API wide mirror and the per version mirrors, which take precedence
+func getTrafficMirrorConfig(rawData map[string]interface{}) (*TrafficMirrorConfig, map[string]*TrafficMirrorConfig) {
+	var thisConfig trafficMirrorRawData
+	if rawData == nil {
+		return nil, nil
+	}
+
+	if !decodeRawConfig(rawData, "traffic mirror options", &thisConfig) {
+		return nil, nil
+	}
+
+	versionMirrors := make(map[string]*TrafficMirrorConfig)
+	for versionName, version := range thisConfig.VersionData.Versions {
+		if version.TrafficMirror != nil {
+			versionMirrors[versionName] = version.TrafficMirror
+		}
+	}
+
+	return thisConfig.TrafficMirror, versionMirrors
+}
+
+// trafficMirrorFor finds the mirror for the version of the request, if any
+func (a *APISpec) trafficMirrorFor(r *http.Request) *TrafficMirrorConfig {
+	if versionKey, found := context.Get(r, VersionKeyContext).(string); found {
+		if versionMirror, found := a.TrafficMirrors[versionKey]; found {
+			return versionMirror
+		}
+	}
+
+	return a.TrafficMirror
+}
+
+// trafficMirror is a mirrored copy of one request
+type trafficMirror struct {
+	spec    *APISpec
+	config  *TrafficMirrorConfig
+	req     *http.Request
+	records chan AnalyticsRecord
+	mu      sync.Mutex
+	done    bool
+}
+
+// startTrafficMirror samples the request and, if it is picked, sends a copy to the mirror in the background.
+// The body is buffered so both upstreams get it, requests with large or unknown length bodies are not mirrored
+func startTrafficMirror(spec *APISpec, r *http.Request) *trafficMirror {
+	mirrorConfig := spec.trafficMirrorFor(r)
+	if mirrorConfig == nil || mirrorConfig.TargetURL == "" || rand.Float64()*100 >= mirrorConfig.SamplePercent {
+		return nil
+	}
+
+	if r.Body != nil && (r.ContentLength < 0 || r.ContentLength > TrafficMirrorMaxBodySize) {
+		return nil
+	}
+
+	mirrorReq, err := mirrorRequest(mirrorConfig.TargetURL, r)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "proxy",
+			"api_id": spec.APIID,
+		}).Error("Couldn't create mirror request: ", err)
+		return nil
+	}
+
+	select {
+	case mirrorSlots <- struct{}{}:
+	default:
+		log.WithFields(logrus.Fields{
+			"prefix": "proxy",
+			"api_id": spec.APIID,
+		}).Debug("Too many mirrored requests in flight, skipping")
+		return nil
+	}
+
+	mirror := &trafficMirror{
+		spec:    spec,
+		config:  mirrorConfig,
+		req:     mirrorReq,
+		records: make(chan AnalyticsRecord, 1),
+	}
+
+	go mirror.send()
+	return mirror
+}
+
+// mirrorRequest copies the request for the mirror target, keeping the path and query of the original
+func mirrorRequest(targetURL string, r *http.Request) (*http.Request, error) {
+	target, err := url.Parse(EnsureTransport(targetURL))
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.Reader
+	if r.Body != nil {
+		bodyBytes, err := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(bodyBytes))
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewReader(bodyBytes)
+	}
+
+	mirrorURL := *r.URL
+	mirrorURL.Scheme = target.Scheme
+	mirrorURL.Host = target.Host
+	mirrorURL.Path = singleJoiningSlash(target.Path, r.URL.Path)
+
+	mirrorReq, err := http.NewRequest(r.Method, mirrorURL.String(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	copyHeader(mirrorReq.Header, r.Header)
+	for _, h := range hopHeaders {
+		mirrorReq.Header.Del(h)
+	}
+	mirrorReq.Header.Set("X-Forwarded-For", GetIPFromRequest(r))
+
+	return mirrorReq, nil
+}
+
+// send makes the mirrored request, the response is discarded
+func (m *trafficMirror) send() {
+	defer func() { <-mirrorSlots }()
+
+	timeout := m.config.Timeout
+	if timeout == 0 {
+		timeout = TrafficMirrorDefaultTimeout
+	}
+	client := &http.Client{Timeout: time.Duration(timeout) * time.Second}
+
+	var responseCode int
+	t1 := time.Now()
+	res, err := client.Do(m.req)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "proxy",
+			"api_id": m.spec.APIID,
+		}).Debug("Mirror request failed: ", err)
+	} else {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		responseCode = res.StatusCode
+	}
+	millisec := float64(time.Now().UnixNano()-t1.UnixNano()) * 0.000001
+
+	// The record of the original request is handed over once that has finished
+	thisRecord, ok := <-m.records
+	if !ok {
+		return
+	}
+
+	thisRecord.MirrorCode = responseCode
+	thisRecord.MirrorTime = int64(millisec)
+	thisRecord.Tags = append(append([]string{}, thisRecord.Tags...), TrafficMirrorTag)
+	analytics.RecordHit(thisRecord)
+}
+
+// recordWith hands the analytics record of the original request to the mirror, it returns false if the
+// record should be stored straight away
+func (m *trafficMirror) recordWith(thisRecord AnalyticsRecord) bool {
+	if m == nil || !m.config.RecordAnalytics {
+		return false
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.done {
+		return false
+	}
+
+	select {
+	case m.records <- thisRecord:
+		return true
+	default:
+		return false
+	}
+}
+
+// finish is called once the original request is done, a mirror that didn't get a record stops waiting
+func (m *trafficMirror) finish() {
+	if m == nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.done {
+		m.done = true
+		close(m.records)
+	}
+}
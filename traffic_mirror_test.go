@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/gorilla/context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrafficMirrorConfigDecoded(t *testing.T) {
+	apiMirror, versionMirrors := getTrafficMirrorConfig(map[string]interface{}{
+		"traffic_mirror": map[string]interface{}{"target_url": "http://mirror", "sample_percent": 10},
+		"version_data": map[string]interface{}{
+			"versions": map[string]interface{}{
+				"v1": map[string]interface{}{"name": "v1"},
+				"v2": map[string]interface{}{
+					"name":           "v2",
+					"traffic_mirror": map[string]interface{}{"target_url": "http://mirror-v2", "sample_percent": 100},
+				},
+			},
+		},
+	})
+
+	if apiMirror == nil || apiMirror.SamplePercent != 10 {
+		t.Fatal("API mirror was not decoded: ", apiMirror)
+	}
+
+	if len(versionMirrors) != 1 || versionMirrors["v2"].TargetURL != "http://mirror-v2" {
+		t.Error("Version mirrors were not decoded: ", versionMirrors)
+	}
+
+	spec := &APISpec{TrafficMirror: apiMirror, TrafficMirrors: versionMirrors}
+	req, _ := http.NewRequest("GET", "/test", nil)
+	context.Set(req, VersionKeyContext, "v2")
+	defer context.Clear(req)
+
+	if spec.trafficMirrorFor(req).TargetURL != "http://mirror-v2" {
+		t.Error("Version mirror should take precedence")
+	}
+}
+
+func TestTrafficMirrorSendsCopy(t *testing.T) {
+	mirrored := make(chan *http.Request, 1)
+	mirroredBody := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		mirroredBody <- string(body)
+		mirrored <- r
+	}))
+	defer server.Close()
+
+	spec := &APISpec{TrafficMirror: &TrafficMirrorConfig{TargetURL: server.URL + "/shadow", SamplePercent: 100}}
+
+	req, _ := http.NewRequest("PUT", "http://gateway/widgets?id=1", strings.NewReader("payload"))
+	req.Header.Set("X-Transformed", "yes")
+
+	mirror := startTrafficMirror(spec, req)
+	if mirror == nil {
+		t.Fatal("Request should be mirrored")
+	}
+	defer mirror.finish()
+
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "payload" {
+		t.Error("Original request body should be kept, got: ", string(body))
+	}
+
+	select {
+	case mirroredReq := <-mirrored:
+		if mirroredReq.URL.Path != "/shadow/widgets" || mirroredReq.URL.RawQuery != "id=1" || mirroredReq.Header.Get("X-Transformed") != "yes" {
+			t.Error("Mirror request was not a copy: ", mirroredReq.URL, mirroredReq.Header)
+		}
+		if body := <-mirroredBody; body != "payload" {
+			t.Error("Mirror should get the body, got: ", body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Mirror request was not sent")
+	}
+}
+
+func TestTrafficMirrorSampling(t *testing.T) {
+	spec := &APISpec{TrafficMirror: &TrafficMirrorConfig{TargetURL: "http://mirror", SamplePercent: 0}}
+	req, _ := http.NewRequest("GET", "/test", nil)
+
+	if startTrafficMirror(spec, req) != nil {
+		t.Error("Requests should not be mirrored with a zero sample")
+	}
+
+	mirror := &trafficMirror{config: &TrafficMirrorConfig{RecordAnalytics: true}, records: make(chan AnalyticsRecord, 1)}
+	mirror.finish()
+	if mirror.recordWith(AnalyticsRecord{}) {
+		t.Error("Records can't be handed over once the request is done")
+	}
+}
@@ -47,35 +47,49 @@ func EnsureTransport(host string) string {
 	return host
 }
 
-func GetNextTarget(targetData interface{}, spec *APISpec, tryCount int) string {
+// GetNextTarget picks the upstream for the request, with load balancing enabled the target data is a list of
+// targets and the API's balancer chooses one, hosts that fail their uptime tests are skipped. The picked
+// target is stored in the request context so the balancer can be told when the request is done
+func GetNextTarget(targetData interface{}, spec *APISpec, req *http.Request) string {
 	if spec.Proxy.EnableLoadBalancing {
 		log.Debug("[PROXY] [LOAD BALANCING] Load balancer enabled, getting upstream target")
 		// Use a list
-		spec.RoundRobin.SetMax(targetData)
 		td := *targetData.(*[]string)
+		if len(td) == 0 {
+			log.Error("[PROXY] [LOAD BALANCING] No upstream targets available")
+			return ""
+		}
+
+		spec.OutlierDetector.SetTargets(td)
 
-		pos := spec.RoundRobin.GetPos()
-		if pos > (len(td) - 1) {
-			// problem
-			spec.RoundRobin.SetMax(td)
-			pos = 0
+		// Targets that already failed this request are avoided when it is retried
+		excluded := make(map[string]bool)
+		if failed, found := context.Get(req, UpstreamExcluded).(map[string]bool); found {
+			for failedTarget := range failed {
+				excluded[failedTarget] = true
+			}
 		}
 
-		thisHost := EnsureTransport(td[pos])
+		for tryCount := 0; tryCount < len(td); tryCount++ {
+			thisTarget := spec.LoadBalancer.Next(td, req, excluded)
+			thisHost := EnsureTransport(thisTarget)
 
-		// Check hosts against uptime tests
-		if spec.Proxy.CheckHostAgainstUptimeTests {
-			if !GlobalHostChecker.IsHostDown(thisHost) {
-				// Don't overdo it
-				if tryCount < len(td) {
-					// Host is down, skip
-					return GetNextTarget(targetData, spec, tryCount+1)
-				}
-				log.Error("[PROXY] [LOAD BALANCING] All hosts seem to be down, all uptime tests are failing!")
+			// Check hosts against uptime tests and the proxy's own results
+			if (spec.Proxy.CheckHostAgainstUptimeTests && !GlobalHostChecker.IsHostDown(thisHost)) || spec.OutlierDetector.IsEjected(thisTarget) {
+				// Host is down, skip
+				spec.LoadBalancer.Done(thisTarget)
+				excluded[thisTarget] = true
+				continue
 			}
+
+			context.Set(req, UpstreamTarget, thisTarget)
+			return thisHost
 		}
 
-		return thisHost
+		log.Error("[PROXY] [LOAD BALANCING] All hosts seem to be down, all uptime tests are failing!")
+		thisTarget := spec.LoadBalancer.Next(td, req, excluded)
+		context.Set(req, UpstreamTarget, thisTarget)
+		return EnsureTransport(thisTarget)
 	}
 	// Use standard target - might still be service data
 	log.Debug("TARGET DATA:", targetData)
@@ -92,6 +106,8 @@ func TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec) *ReverseProxy
 	// initalise round robin
 	spec.RoundRobin = &RoundRobin{}
 	spec.RoundRobin.SetMax(&[]string{})
+	spec.LoadBalancer = NewLoadBalancer(spec)
+	spec.OutlierDetector = NewOutlierDetector(spec)
 
 	if spec.Proxy.ServiceDiscovery.UseDiscoveryService {
 		log.Debug("[PROXY] Service discovery enabled")
@@ -116,7 +132,7 @@ func TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec) *ReverseProxy
 				// No error, replace the target
 				if spec.Proxy.EnableLoadBalancing {
 					var targetPtr *[]string = tempTargetURL.(*[]string)
-					remote, err := url.Parse(GetNextTarget(targetPtr, spec, 0))
+					remote, err := url.Parse(GetNextTarget(targetPtr, spec, req))
 					if err != nil {
 						log.Error("[PROXY] [SERVICE DISCOVERY] Couldn't parse target URL:", err)
 					} else {
@@ -126,7 +142,7 @@ func TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec) *ReverseProxy
 					}
 				} else {
 					var targetPtr string = tempTargetURL.(string)
-					remote, err := url.Parse(GetNextTarget(targetPtr, spec, 0))
+					remote, err := url.Parse(GetNextTarget(targetPtr, spec, req))
 					if err != nil {
 						log.Error("[PROXY] [SERVICE DISCOVERY] Couldn't parse target URL:", err)
 					} else {
@@ -144,7 +160,7 @@ func TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec) *ReverseProxy
 			// no override, better check if LB is enabled
 			if spec.Proxy.EnableLoadBalancing {
 				// it is, lets get that target data
-				lbRemote, lbErr := url.Parse(GetNextTarget(&spec.Proxy.TargetList, spec, 0))
+				lbRemote, lbErr := url.Parse(GetNextTarget(&spec.Proxy.TargetList, spec, req))
 				if lbErr != nil {
 					log.Error("[PROXY] [LOAD BALANCING] Couldn't parse target URL:", lbErr)
 				} else {
@@ -172,6 +188,24 @@ func TykNewSingleHostReverseProxy(target *url.URL, spec *APISpec) *ReverseProxy
 	return &ReverseProxy{Director: director, TykAPISpec: spec, FlushInterval: time.Duration(config.HttpServerOptions.FlushInterval) * time.Millisecond}
 }
 
+// selectTarget runs the director on the outbound request and returns the load balanced target it picked,
+// targets that already failed this request are passed on so a retry goes elsewhere
+func (p *ReverseProxy) selectTarget(req, outreq *http.Request, failedTargets map[string]bool) string {
+	// The director works on the copy, so the key is passed on for balancers that hash on it
+	if authVal, found := context.GetOk(req, AuthHeaderValue); found {
+		context.Set(outreq, AuthHeaderValue, authVal)
+	}
+	if len(failedTargets) > 0 {
+		context.Set(outreq, UpstreamExcluded, failedTargets)
+	}
+	defer context.Clear(outreq)
+
+	p.Director(outreq)
+
+	upstreamTarget, _ := context.Get(outreq, UpstreamTarget).(string)
+	return upstreamTarget
+}
+
 // onExitFlushLoop is a callback set by tests to detect the state of the
 // flushLoop() goroutine.
 var onExitFlushLoop func()
@@ -386,8 +420,18 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	log.Debug("UPSTREAM REQUEST URL: ", req.URL)
 	*outreq = *req // includes shallow copies of maps, but okay
 	*logreq = *req
+	defer context.Clear(logreq)
 
-	p.Director(outreq)
+	// The director changes the URL in place, retries need the original
+	originalURL := *req.URL
+	originalHost := req.Host
+
+	upstreamTarget := p.selectTarget(req, outreq, nil)
+	defer func() {
+		if upstreamTarget != "" {
+			p.TykAPISpec.LoadBalancer.Done(upstreamTarget)
+		}
+	}()
 
 	outreq.Proto = "HTTP/1.1"
 	outreq.ProtoMajor = 1
@@ -439,12 +483,56 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	// 2. when we init the APISpec, we need to create CBs for each monitored endpoint, this means extending the APISpec so we can store pointers
 	// 3. Set up monitoring functions and hook them up to the event handler
 
-	var res *http.Response
-	var err error
+	// Trace the upstream call and let the upstream join the trace
+	upstreamSpan := StartChildSpan(req, "upstream "+outreq.Method, SpanKindClient)
+	if upstreamSpan != nil {
+		tracedHeader := make(http.Header)
+		copyHeader(tracedHeader, outreq.Header)
+		tracedHeader.Set(TRACEPARENT_HEADER, upstreamSpan.TraceParent())
+		outreq.Header = tracedHeader
+		upstreamSpan.SetAttribute("http.url", outreq.URL.String())
+	}
+
 	if breakerEnforced {
 		log.Debug("ON REQUEST: Breaker status: ", breakerConf.CB.Ready())
-		if breakerConf.CB.Ready() {
-			res, err = transport.RoundTrip(outreq)
+		if !breakerConf.CB.Ready() {
+			upstreamSpan.SetError(503, "Circuit breaker open")
+			upstreamSpan.Finish()
+			p.ErrorHandler.HandleError(rw, logreq, "Service temporarily unnavailable.", 503)
+			return nil
+		}
+	}
+
+	retry := newUpstreamRetry(p.TykAPISpec.RetryPolicy, outreq)
+	failedTargets := make(map[string]bool)
+
+	var res *http.Response
+	var err error
+	for {
+		retry.resetBody(outreq)
+
+		// Sign the request last so the signature covers everything sent upstream
+		if p.TykAPISpec.UpstreamSigning.Method != "" {
+			signedHeader := make(http.Header)
+			copyHeader(signedHeader, outreq.Header)
+			outreq.Header = signedHeader
+
+			if signErr := signUpstreamRequest(outreq, p.TykAPISpec.UpstreamSigning); signErr != nil {
+				log.WithFields(logrus.Fields{
+					"prefix": "proxy",
+					"api_id": p.TykAPISpec.APIID,
+				}).Error("Failed to sign upstream request: ", signErr)
+
+				upstreamSpan.SetError(500, signErr.Error())
+				upstreamSpan.Finish()
+				p.ErrorHandler.HandleError(rw, logreq, "There was a problem proxying the request", 500)
+				return nil
+			}
+		}
+
+		res, err = transport.RoundTrip(outreq)
+		p.TykAPISpec.OutlierDetector.Report(upstreamTarget, res, err)
+		if breakerEnforced {
 			if err != nil {
 				breakerConf.CB.Fail()
 			} else if res.StatusCode == 500 {
@@ -452,13 +540,53 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 			} else {
 				breakerConf.CB.Success()
 			}
-		} else {
-			p.ErrorHandler.HandleError(rw, logreq, "Service temporarily unnavailable.", 503)
-			return nil
 		}
+
+		if !retry.shouldRetry(res, err) || (breakerEnforced && !breakerConf.CB.Ready()) {
+			break
+		}
+
+		log.WithFields(logrus.Fields{
+			"prefix":  "proxy",
+			"api_id":  p.TykAPISpec.APIID,
+			"attempt": retry.Attempts,
+			"server":  outreq.URL.Host,
+		}).Warning("Upstream request failed, retrying")
+
+		if res != nil {
+			res.Body.Close()
+		}
+
+		// Pick a new target for the next attempt, starting again from the original URL
+		if upstreamTarget != "" {
+			failedTargets[upstreamTarget] = true
+			p.TykAPISpec.LoadBalancer.Done(upstreamTarget)
+		}
+
+		time.Sleep(retry.backoff())
+		retry.Attempts++
+
+		retryReq := new(http.Request)
+		*retryReq = *outreq
+		retryURL := originalURL
+		retryReq.URL = &retryURL
+		retryReq.Host = originalHost
+		upstreamTarget = p.selectTarget(req, retryReq, failedTargets)
+		outreq = retryReq
+		upstreamSpan.SetAttribute("http.url", outreq.URL.String())
+	}
+
+	context.Set(req, UpstreamAttempts, retry.Attempts)
+	context.Set(logreq, UpstreamAttempts, retry.Attempts)
+
+	if err != nil {
+		upstreamSpan.SetError(500, err.Error())
+	} else if res.StatusCode >= 500 {
+		upstreamSpan.SetError(res.StatusCode, res.Status)
 	} else {
-		res, err = transport.RoundTrip(outreq)
+		upstreamSpan.SetAttribute("http.status_code", strconv.Itoa(res.StatusCode))
 	}
+	upstreamSpan.Finish()
 
 	if err != nil {
 
@@ -564,9 +692,10 @@ func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response
 	// Add resource headers
 	if ses != nil {
 		// We have found a session, lets report back
-		res.Header.Add("X-RateLimit-Limit", strconv.Itoa(int(ses.QuotaMax)))
-		res.Header.Add("X-RateLimit-Remaining", strconv.Itoa(int(ses.QuotaRemaining)))
-		res.Header.Add("X-RateLimit-Reset", strconv.Itoa(int(ses.QuotaRenews)))
+		limit, remaining, reset := ses.RateLimitHeaderValues()
+		res.Header.Add("X-RateLimit-Limit", strconv.Itoa(int(limit)))
+		res.Header.Add("X-RateLimit-Remaining", strconv.Itoa(int(remaining)))
+		res.Header.Add("X-RateLimit-Reset", strconv.Itoa(int(reset)))
 	}
 
 	copyHeader(rw.Header(), res.Header)
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	UpstreamSigningHMAC    string = "hmac"
+	UpstreamSigningAWSV4   string = "aws_sigv4"
+	AWSV4Algorithm         string = "AWS4-HMAC-SHA256"
+	AWSV4DateFormat        string = "20060102T150405Z"
+	AWSV4ShortDateFormat   string = "20060102"
+	AWSV4RequestTerminator string = "aws4_request"
+)
+
+// UpstreamSigningConfig signs requests to the upstream, either with the same HTTP Signatures HMAC
+// scheme that the gateway checks inbound, or with AWS Signature V4. AWS credentials are read from
+// the environment if they are not set
+type UpstreamSigningConfig struct {
+	Method          string   `mapstructure:"method" bson:"method" json:"method"`
+	KeyID           string   `mapstructure:"key_id" bson:"key_id" json:"key_id"`
+	Secret          string   `mapstructure:"secret" bson:"secret" json:"secret"`
+	Algorithm       string   `mapstructure:"algorithm" bson:"algorithm" json:"algorithm"`
+	Headers         []string `mapstructure:"headers" bson:"headers" json:"headers"`
+	Region          string   `mapstructure:"region" bson:"region" json:"region"`
+	Service         string   `mapstructure:"service" bson:"service" json:"service"`
+	AccessKeyID     string   `mapstructure:"access_key_id" bson:"access_key_id" json:"access_key_id"`
+	SecretAccessKey string   `mapstructure:"secret_access_key" bson:"secret_access_key" json:"secret_access_key"`
+	SessionToken    string   `mapstructure:"session_token" bson:"session_token" json:"session_token"`
+}
+
+type upstreamSigningRawData struct {
+	UpstreamSigning UpstreamSigningConfig `mapstructure:"upstream_signing"`
+}
+
+// getUpstreamSigningConfig decodes the upstream signing options from the raw API definition
+func getUpstreamSigningConfig(rawData map[string]interface{}) UpstreamSigningConfig {
+	var thisConfig upstreamSigningRawData
+	if rawData == nil {
+		return thisConfig.UpstreamSigning
+	}
+
+	if !decodeRawConfig(rawData, "upstream signing options", &thisConfig) {
+		return UpstreamSigningConfig{}
+	}
+
+	return thisConfig.UpstreamSigning
+}
+
+// signUpstreamRequest signs the outbound request, this must be the last change made to it
+func signUpstreamRequest(req *http.Request, signing UpstreamSigningConfig) error {
+	switch signing.Method {
+	case "":
+		return nil
+	case UpstreamSigningHMAC:
+		return signUpstreamHMAC(req, signing, time.Now())
+	case UpstreamSigningAWSV4:
+		return signUpstreamAWSV4(req, signing, time.Now())
+	}
+
+	return errors.New("Unknown upstream signing method: " + signing.Method)
+}
+
+func signUpstreamHMAC(req *http.Request, signing UpstreamSigningConfig, now time.Time) error {
+	algorithm := strings.ToLower(signing.Algorithm)
+	if algorithm == "" {
+		algorithm = HMACSHA256
+	}
+
+	if _, found := hmacAlgorithms[algorithm]; !found {
+		return errors.New("Upstream signing algorithm is not supported: " + algorithm)
+	}
+
+	if req.Header.Get(DateHeaderSpec) == "" && req.Header.Get(AltHeaderSpec) == "" {
+		req.Header.Set(DateHeaderSpec, now.UTC().Format(http.TimeFormat))
+	}
+
+	fieldValues := &HMACFieldValues{
+		KeyID:    signing.KeyID,
+		Algorthm: algorithm,
+		Headers:  signing.Headers,
+	}
+
+	if len(fieldValues.Headers) == 0 {
+		fieldValues.Headers = []string{"date"}
+	}
+
+	signatureString, err := generateHMACSignatureStringFromRequest(req, fieldValues)
+	if err != nil {
+		return err
+	}
+
+	fieldValues.Signature = generateEncodedSignature(signatureString, signing.Secret, algorithm)
+	req.Header.Set("Authorization", generateAuthHeaderValue(fieldValues))
+
+	return nil
+}
+
+func awsCredentials(signing UpstreamSigningConfig) (string, string, string) {
+	if signing.AccessKeyID != "" {
+		return signing.AccessKeyID, signing.SecretAccessKey, signing.SessionToken
+	}
+
+	return os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// awsURIEncode encodes everything except the RFC 3986 unreserved characters
+func awsURIEncode(in string, encodeSlash bool) string {
+	var buf bytes.Buffer
+	for _, b := range []byte(in) {
+		if (b >= 'A' && b <= 'Z') || (b >= 'a' && b <= 'z') || (b >= '0' && b <= '9') ||
+			b == '-' || b == '_' || b == '.' || b == '~' || (b == '/' && !encodeSlash) {
+			buf.WriteByte(b)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", b)
+		}
+	}
+
+	return buf.String()
+}
+
+func awsCanonicalQuery(req *http.Request) string {
+	params := []string{}
+	for key, values := range req.URL.Query() {
+		for _, value := range values {
+			params = append(params, awsURIEncode(key, true)+"="+awsURIEncode(value, true))
+		}
+	}
+	sort.Strings(params)
+
+	return strings.Join(params, "&")
+}
+
+// awsCanonicalHeaders returns the canonical headers and the signed header list
+func awsCanonicalHeaders(req *http.Request, host string) (string, string) {
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		lowered := strings.ToLower(name)
+		if lowered == "content-type" || strings.HasPrefix(lowered, "x-amz-") {
+			headers[lowered] = strings.Join(strings.Fields(strings.Join(values, ",")), " ")
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonical := ""
+	for _, name := range names {
+		canonical += name + ":" + headers[name] + "\n"
+	}
+
+	return canonical, strings.Join(names, ";")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func signUpstreamAWSV4(req *http.Request, signing UpstreamSigningConfig, now time.Time) error {
+	accessKeyID, secretAccessKey, sessionToken := awsCredentials(signing)
+	if accessKeyID == "" || secretAccessKey == "" {
+		return errors.New("No AWS credentials for upstream signing")
+	}
+
+	// The body has to be hashed, so it is buffered and replaced
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.ContentLength = int64(len(body))
+	}
+	payloadHash := sha256.Sum256(body)
+	payloadHex := hex.EncodeToString(payloadHash[:])
+
+	now = now.UTC()
+	amzDate := now.Format(AWSV4DateFormat)
+	shortDate := now.Format(AWSV4ShortDateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+	if signing.Service == "s3" {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHex)
+	}
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+
+	// S3 paths are only encoded once, all other services expect them to be encoded twice
+	uri := req.URL.EscapedPath()
+	if uri == "" {
+		uri = "/"
+	}
+	if signing.Service != "s3" {
+		uri = awsURIEncode(uri, false)
+	}
+
+	canonicalHeaders, signedHeaders := awsCanonicalHeaders(req, host)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uri,
+		awsCanonicalQuery(req),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHex,
+	}, "\n")
+
+	scope := strings.Join([]string{shortDate, signing.Region, signing.Service, AWSV4RequestTerminator}, "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		AWSV4Algorithm,
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), shortDate)
+	signingKey = hmacSHA256(signingKey, signing.Region)
+	signingKey = hmacSHA256(signingKey, signing.Service)
+	signingKey = hmacSHA256(signingKey, AWSV4RequestTerminator)
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", AWSV4Algorithm+" Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUpstreamSigningConfigDecoded(t *testing.T) {
+	rawData := map[string]interface{}{
+		"upstream_signing": map[string]interface{}{
+			"method":  "aws_sigv4",
+			"region":  "us-east-1",
+			"service": "iam",
+		},
+	}
+
+	signing := getUpstreamSigningConfig(rawData)
+	if signing.Method != UpstreamSigningAWSV4 || signing.Region != "us-east-1" || signing.Service != "iam" {
+		t.Error("Upstream signing options were not decoded: ", signing)
+	}
+}
+
+func TestUpstreamSigningAWSV4(t *testing.T) {
+	// Example request from the AWS Signature Version 4 documentation
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	signing := UpstreamSigningConfig{
+		Method:          UpstreamSigningAWSV4,
+		Region:          "us-east-1",
+		Service:         "iam",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	}
+
+	now, _ := time.Parse(AWSV4DateFormat, "20150830T123600Z")
+	if err := signUpstreamAWSV4(req, signing, now); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, " +
+		"SignedHeaders=content-type;host;x-amz-date, " +
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Error("Unexpected signature:\n", got, "\nexpected:\n", expected)
+	}
+}
+
+func TestUpstreamSigningHMAC(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://upstream.example.com/widgets", nil)
+	req.Header.Set("X-Test", "hello")
+
+	signing := UpstreamSigningConfig{
+		Method:    UpstreamSigningHMAC,
+		KeyID:     "9876",
+		Secret:    "9879879878787878",
+		Algorithm: HMACSHA512,
+		Headers:   []string{"(request-target)", "date", "x-test"},
+	}
+
+	if err := signUpstreamRequest(req, signing); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upstream should be able to verify it the same way the gateway checks inbound requests
+	fieldValues, err := getFieldValues(stripSignature(req.Header.Get("Authorization")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fieldValues.KeyID != "9876" || fieldValues.Algorthm != HMACSHA512 || len(fieldValues.Headers) != 3 {
+		t.Error("Unexpected signature fields: ", fieldValues)
+	}
+
+	signatureString, _ := generateHMACSignatureStringFromRequest(req, fieldValues)
+	if !strings.HasPrefix(signatureString, "(request-target): post /widgets\ndate: ") {
+		t.Error("Unexpected signature string: ", signatureString)
+	}
+
+	if generateEncodedSignature(signatureString, signing.Secret, HMACSHA512) != fieldValues.Signature {
+		t.Error("Signature could not be verified")
+	}
+}